@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/pyroscope-io/pyroscope/pkg/agent/profiler"
@@ -18,7 +19,7 @@ func fastFunction() {
 	work(2000)
 }
 
-func slowFunction() {
+func slowFunction(_ context.Context) {
 	work(8000)
 }
 
@@ -31,6 +32,7 @@ func main() {
 	log.Println("test")
 	for {
 		fastFunction()
-		slowFunction()
+		// CPU time spent in slowFunction is also reported as simple.golang.app.cpu{function=slow}
+		profiler.TagWrapper(context.Background(), profiler.Labels("function", "slow"), slowFunction)
 	}
 }
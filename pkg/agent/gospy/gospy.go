@@ -26,6 +26,7 @@ type GoSpy struct {
 	profileType   spy.ProfileType
 	disableGCRuns bool
 	sampleRate    uint32
+	frameFormat   convert.FrameFormat
 
 	lastGCGeneration uint32
 
@@ -33,6 +34,20 @@ type GoSpy struct {
 	buf    *bytes.Buffer
 }
 
+// lookupProfile is a profile read with pprof.Lookup
+type lookupProfile struct {
+	name       string
+	sampleType string
+}
+
+var lookupProfiles = map[spy.ProfileType]lookupProfile{
+	spy.ProfileGoroutines:    {"goroutine", "goroutine"},
+	spy.ProfileMutexCount:    {"mutex", "contentions"},
+	spy.ProfileMutexDuration: {"mutex", "delay"},
+	spy.ProfileBlockCount:    {"block", "contentions"},
+	spy.ProfileBlockDuration: {"block", "delay"},
+}
+
 func startCPUProfile(w io.Writer, hz uint32) error {
 	// idea here is that for most people we're starting the default profiler
 	//   but if you want to use a different sampling rate we use our experimental profiler
@@ -42,13 +57,17 @@ func startCPUProfile(w io.Writer, hz uint32) error {
 	return custom_pprof.StartCPUProfile(w, hz)
 }
 
-func Start(profileType spy.ProfileType, sampleRate uint32, disableGCRuns bool) (spy.Spy, error) {
+func Start(profileType spy.ProfileType, sampleRate uint32, disableGCRuns bool, frameFormat convert.FrameFormat) (spy.Spy, error) {
+	if frameFormat == "" {
+		frameFormat = convert.FrameFunction
+	}
 	s := &GoSpy{
 		stopCh:        make(chan struct{}),
 		buf:           &bytes.Buffer{},
 		profileType:   profileType,
 		disableGCRuns: disableGCRuns,
 		sampleRate:    sampleRate,
+		frameFormat:   frameFormat,
 	}
 	if s.profileType == spy.ProfileCPU {
 		if err := startCPUProfile(s.buf, sampleRate); err != nil {
@@ -95,6 +114,13 @@ func numGC() uint32 {
 
 // Snapshot calls callback function with stack-trace or error.
 func (s *GoSpy) Snapshot(cb func([]byte, uint64, error)) {
+	s.SnapshotWithLabels(func(_ spy.Labels, name []byte, samples uint64, err error) {
+		cb(name, samples, err)
+	})
+}
+
+// SnapshotWithLabels is like Snapshot, but it also passes pprof labels of the samples to the callback.
+func (s *GoSpy) SnapshotWithLabels(cb func(spy.Labels, []byte, uint64, error)) {
 	s.resetMutex.Lock()
 	defer s.resetMutex.Unlock()
 
@@ -110,26 +136,28 @@ func (s *GoSpy) Snapshot(cb func([]byte, uint64, error)) {
 		defer func() {
 			// start a new cycle of sample collection
 			if err := startCPUProfile(s.buf, s.sampleRate); err != nil {
-				cb(nil, uint64(0), err)
+				cb(nil, nil, uint64(0), err)
 			}
 		}()
 
 		// new gzip reader with the read data in buffer
 		r, err := gzip.NewReader(bytes.NewReader(s.buf.Bytes()))
 		if err != nil {
-			cb(nil, uint64(0), fmt.Errorf("new gzip reader: %v", err))
+			cb(nil, nil, uint64(0), fmt.Errorf("new gzip reader: %v", err))
 			return
 		}
 
 		// parse the read data with pprof format
 		profile, err := convert.ParsePprof(r)
 		if err != nil {
-			cb(nil, uint64(0), fmt.Errorf("parse pprof: %v", err))
+			cb(nil, nil, uint64(0), fmt.Errorf("parse pprof: %v", err))
 			return
 		}
-		profile.Get("samples", func(name []byte, val int) {
-			cb(name, uint64(val), nil)
+		profile.GetWithFormat("samples", s.frameFormat, func(labels map[string]string, name []byte, val int) {
+			cb(labels, name, uint64(val), nil)
 		})
+	} else if p, ok := lookupProfiles[s.profileType]; ok {
+		s.snapshotLookupProfile(p, cb)
 	} else {
 		// this is current GC generation
 		currentGCGeneration := numGC()
@@ -145,8 +173,8 @@ func (s *GoSpy) Snapshot(cb func([]byte, uint64, error)) {
 		// if there's no GC run then the profile is gonna be the same
 		//   in such case it does not make sense to upload the same profile twice
 		if currentGCGeneration != s.lastGCGeneration {
-			getHeapProfile(s.buf).Get(string(s.profileType), func(name []byte, val int) {
-				cb(name, uint64(val), nil)
+			getHeapProfile(s.buf).GetWithFormat(string(s.profileType), s.frameFormat, func(labels map[string]string, name []byte, val int) {
+				cb(labels, name, uint64(val), nil)
 			})
 			s.lastGCGeneration = currentGCGeneration
 		}
@@ -154,6 +182,21 @@ func (s *GoSpy) Snapshot(cb func([]byte, uint64, error)) {
 	s.buf.Reset()
 }
 
+func (s *GoSpy) snapshotLookupProfile(p lookupProfile, cb func(spy.Labels, []byte, uint64, error)) {
+	if err := pprof.Lookup(p.name).WriteTo(s.buf, 0); err != nil {
+		cb(nil, nil, uint64(0), fmt.Errorf("write %s profile: %v", p.name, err))
+		return
+	}
+	profile, err := convert.ParsePprof(bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		cb(nil, nil, uint64(0), fmt.Errorf("parse pprof: %v", err))
+		return
+	}
+	profile.GetWithFormat(p.sampleType, s.frameFormat, func(labels map[string]string, name []byte, val int) {
+		cb(labels, name, uint64(val), nil)
+	})
+}
+
 func (s *GoSpy) Reset() {
 	s.resetMutex.Lock()
 	defer s.resetMutex.Unlock()
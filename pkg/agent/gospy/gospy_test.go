@@ -2,12 +2,15 @@ package gospy
 
 import (
 	"log"
+	"runtime"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
 	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
 	"github.com/pyroscope-io/pyroscope/pkg/testing"
 )
 
@@ -15,7 +18,7 @@ var _ = Describe("analytics", func() {
 	testing.WithConfig(func(cfg **config.Config) {
 		Describe("NewSession", func() {
 			It("works as expected", func(done Done) {
-				s, err := Start(spy.ProfileCPU, 100, false)
+				s, err := Start(spy.ProfileCPU, 100, false, convert.FrameFunction)
 				Expect(err).ToNot(HaveOccurred())
 				go func() {
 					s := time.Now()
@@ -33,5 +36,58 @@ var _ = Describe("analytics", func() {
 				close(done)
 			})
 		})
+
+		Describe("lookup profiles", func() {
+			It("reads goroutine profiles", func() {
+				s, err := Start(spy.ProfileGoroutines, 100, false, convert.FrameFunction)
+				Expect(err).ToNot(HaveOccurred())
+				s.(spy.Resettable).Reset()
+				var total uint64
+				s.Snapshot(func(name []byte, v uint64, err error) {
+					Expect(err).ToNot(HaveOccurred())
+					total += v
+				})
+				Expect(total).To(BeNumerically(">", 0))
+			})
+
+			It("uses the frame format", func() {
+				s, err := Start(spy.ProfileGoroutines, 100, false, convert.FrameLine)
+				Expect(err).ToNot(HaveOccurred())
+				s.(spy.Resettable).Reset()
+				var names []string
+				s.Snapshot(func(name []byte, v uint64, err error) {
+					Expect(err).ToNot(HaveOccurred())
+					names = append(names, string(name))
+				})
+				Expect(names).To(ContainElement(MatchRegexp(`gospy_test\.go:\d+`)))
+			})
+
+			It("reads mutex profiles", func() {
+				defer runtime.SetMutexProfileFraction(runtime.SetMutexProfileFraction(1))
+				var m sync.Mutex
+				unlocked := make(chan struct{})
+				m.Lock()
+				go func() {
+					m.Lock()
+					m.Unlock()
+					close(unlocked)
+				}()
+				time.Sleep(10 * time.Millisecond)
+				m.Unlock()
+				<-unlocked
+
+				for _, pt := range []spy.ProfileType{spy.ProfileMutexCount, spy.ProfileMutexDuration} {
+					s, err := Start(pt, 100, false, convert.FrameFunction)
+					Expect(err).ToNot(HaveOccurred())
+					s.(spy.Resettable).Reset()
+					var total uint64
+					s.Snapshot(func(name []byte, v uint64, err error) {
+						Expect(err).ToNot(HaveOccurred())
+						total += v
+					})
+					Expect(total).To(BeNumerically(">", 0))
+				}
+			})
+		})
 	})
 })
@@ -3,13 +3,17 @@
 package profiler
 
 import (
+	"context"
 	"fmt"
+	"runtime/pprof"
 	"time"
 
 	"github.com/pyroscope-io/pyroscope/pkg/agent"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/types"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream/remote"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
 )
 
 type ProfileType = spy.ProfileType
@@ -20,20 +24,49 @@ var (
 	ProfileAllocSpace   = spy.ProfileAllocSpace
 	ProfileInuseObjects = spy.ProfileInuseObjects
 	ProfileInuseSpace   = spy.ProfileInuseSpace
+	ProfileGoroutines   = spy.ProfileGoroutines
+
+	// Mutex and block profiles are only collected if enabled
+	// with runtime.SetMutexProfileFraction and runtime.SetBlockProfileRate.
+	ProfileMutexCount    = spy.ProfileMutexCount
+	ProfileMutexDuration = spy.ProfileMutexDuration
+	ProfileBlockCount    = spy.ProfileBlockCount
+	ProfileBlockDuration = spy.ProfileBlockDuration
+)
+
+type FrameFormat = convert.FrameFormat
+
+var (
+	FrameFunction = convert.FrameFunction // e.g main.work
+	FrameLine     = convert.FrameLine     // e.g main.work /src/app/main.go:42
+	FrameModule   = convert.FrameModule   // e.g app!main.work
 )
 
 type Config struct {
-	ApplicationName string // e.g backend.purchases
-	ServerAddress   string // e.g http://pyroscope.services.internal:4040
-	AuthToken       string // specify this token when using pyroscope cloud
+	ApplicationName string            // e.g backend.purchases
+	Tags            map[string]string // e.g {"env": "prod"}, added to the application name
+	ServerAddress   string            // e.g http://pyroscope.services.internal:4040
+	AuthToken       string            // specify this token when using pyroscope cloud
 	SampleRate      uint32
 	Logger          agent.Logger
 	ProfileTypes    []ProfileType
-	DisableGCRuns   bool // this will disable automatic runtime.GC runs
+	DisableGCRuns   bool          // this will disable automatic runtime.GC runs
+	FrameFormat     FrameFormat   // FrameFunction by default
+	UploadRate      time.Duration // 10s by default
+
+	UpstreamThreads        int           // 4 by default
+	UpstreamRequestTimeout time.Duration // 30s by default
+	// Upstream overrides the default one sending data to ServerAddress,
+	// it's not stopped when the profiler stops.
+	Upstream upstream.Upstream
 }
 
 type Profiler struct {
-	session *agent.ProfileSession
+	session  *agent.ProfileSession
+	upstream upstream.Upstream
+	remote   *remote.Remote // nil if a custom upstream is used
+
+	flushTimeout time.Duration
 }
 
 // Start starts continuously profiling go code
@@ -44,44 +77,94 @@ func Start(cfg Config) (*Profiler, error) {
 	if cfg.SampleRate == 0 {
 		cfg.SampleRate = types.DefaultSampleRate
 	}
+	if cfg.UploadRate == 0 {
+		cfg.UploadRate = types.DefaultUploadRate
+	}
 	if cfg.Logger == nil {
 		cfg.Logger = &agent.NoopLogger{}
 	}
-
-	rc := remote.RemoteConfig{
-		AuthToken:              cfg.AuthToken,
-		UpstreamAddress:        cfg.ServerAddress,
-		UpstreamThreads:        4,
-		UpstreamRequestTimeout: 30 * time.Second,
+	if cfg.UpstreamThreads == 0 {
+		cfg.UpstreamThreads = 4
 	}
-	upstream, err := remote.New(rc, cfg.Logger)
-	if err != nil {
-		return nil, err
+	if cfg.UpstreamRequestTimeout == 0 {
+		cfg.UpstreamRequestTimeout = 30 * time.Second
+	}
+
+	p := Profiler{upstream: cfg.Upstream, flushTimeout: cfg.UpstreamRequestTimeout}
+	if p.upstream == nil {
+		rc := remote.RemoteConfig{
+			AuthToken:              cfg.AuthToken,
+			UpstreamAddress:        cfg.ServerAddress,
+			UpstreamThreads:        cfg.UpstreamThreads,
+			UpstreamRequestTimeout: cfg.UpstreamRequestTimeout,
+			UpstreamMaxRetries:     3,
+			UpstreamRetryBackoff:   time.Second,
+		}
+		r, err := remote.New(rc, cfg.Logger)
+		if err != nil {
+			return nil, err
+		}
+		p.remote = r
+		p.upstream = r
 	}
 
 	sc := agent.SessionConfig{
-		Upstream:         upstream,
+		Upstream:         p.upstream,
 		AppName:          cfg.ApplicationName,
-		ProfilingTypes:   types.DefaultProfileTypes,
+		Tags:             cfg.Tags,
+		ProfilingTypes:   cfg.ProfileTypes,
 		DisableGCRuns:    cfg.DisableGCRuns,
+		FrameFormat:      cfg.FrameFormat,
 		SpyName:          types.GoSpy,
 		SampleRate:       cfg.SampleRate,
-		UploadRate:       10 * time.Second,
+		UploadRate:       cfg.UploadRate,
 		Pid:              0,
 		WithSubprocesses: false,
 	}
-	session := agent.NewSession(&sc, cfg.Logger)
-	if err := session.Start(); err != nil {
+	p.session = agent.NewSession(&sc, cfg.Logger)
+	if err := p.session.Start(); err != nil {
 		return nil, fmt.Errorf("start session: %v", err)
 	}
-
-	return &Profiler{
-		session: session,
-	}, nil
+	return &p, nil
 }
 
-// Stop stops continious profiling session
+// Stop stops continious profiling session.
+// The default upstream is stopped too, after sending the remaining data. Stop blocks
+// while the data is sent, but it waits for retries for no longer than UpstreamRequestTimeout,
+// the data that is not sent by then is dropped. Requests in flight are not interrupted,
+// so Stop can take up to twice UpstreamRequestTimeout when the server is unavailable.
 func (p *Profiler) Stop() error {
 	p.session.Stop()
+	if p.remote != nil {
+		p.remote.FlushTimeout(p.flushTimeout)
+		p.remote.Stop()
+	}
 	return nil
 }
+
+// Flush uploads the profiling data collected so far without waiting for the
+// upload rate, so that short-lived programs don't lose the last interval.
+// It blocks until the data is sent, unless a custom upstream not implementing
+// upstream.Flusher is used.
+func (p *Profiler) Flush() {
+	p.session.Flush()
+	if f, ok := p.upstream.(upstream.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// LabelSet is a set of labels attached to profiling data, see TagWrapper.
+type LabelSet = pprof.LabelSet
+
+// Labels takes an even number of strings representing key-value pairs
+// and makes a LabelSet containing them, e.g Labels("endpoint", "/checkout").
+func Labels(args ...string) LabelSet {
+	return pprof.Labels(args...)
+}
+
+// TagWrapper calls fn with a copy of the parent context with the labels added.
+// CPU time spent in fn, including goroutines started by it, is uploaded separately
+// with the labels as tags, e.g backend.purchases.cpu{endpoint=/checkout}.
+func TagWrapper(ctx context.Context, labels LabelSet, fn func(context.Context)) {
+	pprof.Do(ctx, labels, fn)
+}
@@ -0,0 +1,87 @@
+package profiler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent/profiler"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+)
+
+type upstreamMock struct {
+	m       sync.Mutex
+	jobs    []*upstream.UploadJob
+	stopped bool
+}
+
+func (u *upstreamMock) Stop() {
+	u.m.Lock()
+	defer u.m.Unlock()
+	u.stopped = true
+}
+
+func (u *upstreamMock) Upload(j *upstream.UploadJob) {
+	u.m.Lock()
+	defer u.m.Unlock()
+	u.jobs = append(u.jobs, j)
+}
+
+var _ = Describe("profiler", func() {
+	It("uploads profiles of the configured types on flush", func() {
+		u := &upstreamMock{}
+		p, err := profiler.Start(profiler.Config{
+			ApplicationName: "test.app",
+			Tags:            map[string]string{"env": "test"},
+			ProfileTypes:    []profiler.ProfileType{profiler.ProfileCPU, profiler.ProfileGoroutines},
+			UploadRate:      time.Hour,
+			Upstream:        u,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		time.Sleep(100 * time.Millisecond)
+
+		p.Flush()
+		u.m.Lock()
+		names := []string{}
+		for _, j := range u.jobs {
+			names = append(names, j.Name)
+			Expect(j.Resolution).To(Equal(time.Hour))
+			Expect(j.EndTime.After(j.StartTime)).To(BeTrue())
+		}
+		u.m.Unlock()
+		Expect(names).To(ConsistOf("test.app.cpu{env=test}", "test.app.goroutines{env=test}"))
+
+		Expect(p.Stop()).ToNot(HaveOccurred())
+		u.m.Lock()
+		defer u.m.Unlock()
+		Expect(u.stopped).To(BeFalse())
+	})
+
+	It("does not wait for retries longer than the request timeout on stop", func() {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		p, err := profiler.Start(profiler.Config{
+			ApplicationName:        "test.app",
+			ServerAddress:          server.URL,
+			ProfileTypes:           []profiler.ProfileType{profiler.ProfileGoroutines},
+			UploadRate:             time.Hour,
+			UpstreamRequestTimeout: 200 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		start := time.Now()
+		Expect(p.Stop()).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(atomic.LoadInt32(&requests)).To(BeNumerically(">", 0))
+	})
+})
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"os"
 	"sync"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	_ "github.com/pyroscope-io/pyroscope/pkg/agent/rbspy"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/types"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
 	"github.com/pyroscope-io/pyroscope/pkg/util/slices"
 
 	// revive:enable:blank-imports
@@ -28,19 +30,25 @@ import (
 type ProfileSession struct {
 	upstream   upstream.Upstream
 	appName    string
+	tags       spy.Labels
+	tagsKey    string // tags formatted with spy.Labels.String
 	spyName    string
 	sampleRate uint32
 	uploadRate time.Duration
 	pids       []int
 	spies      []spy.Spy
 	stopCh     chan struct{}
+	flushCh    chan chan struct{}
 	trieMutex  sync.Mutex
 
-	previousTries []*transporttrie.Trie
-	tries         []*transporttrie.Trie
+	// tries are keyed by labels of the samples merged with tags, see spy.Labels.String.
+	//   Samples without labels are stored under the empty key
+	previousTries map[string][]*transporttrie.Trie
+	tries         map[string][]*transporttrie.Trie
 
 	profileTypes     []spy.ProfileType
 	disableGCRuns    bool
+	frameFormat      convert.FrameFormat
 	withSubprocesses bool
 
 	startTime time.Time
@@ -52,8 +60,10 @@ type ProfileSession struct {
 type SessionConfig struct {
 	Upstream         upstream.Upstream
 	AppName          string
+	Tags             map[string]string // environment variables in values are expanded, e.g $HOSTNAME
 	ProfilingTypes   []spy.ProfileType
 	DisableGCRuns    bool
+	FrameFormat      convert.FrameFormat // gospy only, convert.FrameFunction by default
 	SpyName          string
 	SampleRate       uint32
 	UploadRate       time.Duration
@@ -65,71 +75,57 @@ func NewSession(c *SessionConfig, logger Logger) *ProfileSession {
 	ps := &ProfileSession{
 		upstream:         c.Upstream,
 		appName:          c.AppName,
+		tags:             expandTags(c.Tags),
 		spyName:          c.SpyName,
 		profileTypes:     c.ProfilingTypes,
 		disableGCRuns:    c.DisableGCRuns,
+		frameFormat:      c.FrameFormat,
 		sampleRate:       c.SampleRate,
 		uploadRate:       c.UploadRate,
 		pids:             []int{c.Pid},
 		stopCh:           make(chan struct{}),
+		flushCh:          make(chan chan struct{}),
 		withSubprocesses: c.WithSubprocesses,
 		logger:           logger,
 	}
 
-	if ps.spyName == types.GoSpy {
-		ps.previousTries = make([]*transporttrie.Trie, len(ps.profileTypes))
-		ps.tries = make([]*transporttrie.Trie, len(ps.profileTypes))
-	} else {
-		ps.previousTries = make([]*transporttrie.Trie, 1)
-		ps.tries = make([]*transporttrie.Trie, 1)
-	}
+	ps.tagsKey = ps.tags.String()
+	ps.previousTries = map[string][]*transporttrie.Trie{"": ps.newTries()}
+	ps.tries = map[string][]*transporttrie.Trie{"": ps.newTries()}
 
 	return ps
 }
 
+func expandTags(tags map[string]string) spy.Labels {
+	if len(tags) == 0 {
+		return nil
+	}
+	res := make(spy.Labels, len(tags))
+	for k, v := range tags {
+		res[k] = os.ExpandEnv(v)
+	}
+	return res
+}
+
+// newTries returns a slice with a trie per profile type
+func (ps *ProfileSession) newTries() []*transporttrie.Trie {
+	if ps.spyName == types.GoSpy {
+		return make([]*transporttrie.Trie, len(ps.profileTypes))
+	}
+	return make([]*transporttrie.Trie, 1)
+}
+
 func (ps *ProfileSession) takeSnapshots() {
 	ticker := time.NewTicker(time.Second / time.Duration(ps.sampleRate))
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			isdueToReset := ps.isDueForReset()
-			// reset the profiler for spies every upload rate(10s), and before uploading, it needs to read profile data every sample rate
-			if isdueToReset {
-				for _, s := range ps.spies {
-					if sr, ok := s.(spy.Resettable); ok {
-						sr.Reset()
-					}
-				}
-			}
+			ps.takeSnapshot(ps.isDueForReset())
 
-			for i, s := range ps.spies {
-				s.Snapshot(func(stack []byte, v uint64, err error) {
-					if err != nil {
-						// TODO: figure out what to do with these messages. A couple of considerations:
-						// * We probably shouldn't just suppress these messages as they might be useful for users
-						// * We probably want to throttle the messages because this is code that runs 100 times per second.
-						//   If we don't throttle we risk upsetting users with a flood of messages
-						// * In gospy case we need to add ability for users to bring their own logger, we can't just use logrus here
-						return
-					}
-					if len(stack) > 0 {
-						ps.trieMutex.Lock()
-						defer ps.trieMutex.Unlock()
-
-						if ps.spyName == types.GoSpy {
-							ps.tries[i].Insert(stack, v, true)
-						} else {
-							ps.tries[0].Insert(stack, v, true)
-						}
-					}
-				})
-			}
-
-			// upload the read data to server and reset the start time
-			if isdueToReset {
-				ps.reset()
-			}
+		case done := <-ps.flushCh:
+			ps.takeSnapshot(true)
+			close(done)
 
 		case <-ps.stopCh:
 			// stop the spies
@@ -141,12 +137,83 @@ func (ps *ProfileSession) takeSnapshots() {
 	}
 }
 
+func (ps *ProfileSession) takeSnapshot(isdueToReset bool) {
+	// reset the profiler for spies every upload rate(10s), and before uploading, it needs to read profile data every sample rate
+	if isdueToReset {
+		for _, s := range ps.spies {
+			if sr, ok := s.(spy.Resettable); ok {
+				sr.Reset()
+			}
+		}
+	}
+
+	for i, s := range ps.spies {
+		if ls, ok := s.(spy.LabelledSpy); ok {
+			ls.SnapshotWithLabels(func(labels spy.Labels, stack []byte, v uint64, err error) {
+				ps.insert(i, labels, stack, v, err)
+			})
+		} else {
+			s.Snapshot(func(stack []byte, v uint64, err error) {
+				ps.insert(i, nil, stack, v, err)
+			})
+		}
+	}
+
+	// upload the read data to server and reset the start time
+	if isdueToReset {
+		ps.reset()
+	}
+}
+
+func (ps *ProfileSession) insert(spyIndex int, labels spy.Labels, stack []byte, v uint64, err error) {
+	if err != nil {
+		// TODO: figure out what to do with these messages. A couple of considerations:
+		// * We probably shouldn't just suppress these messages as they might be useful for users
+		// * We probably want to throttle the messages because this is code that runs 100 times per second.
+		//   If we don't throttle we risk upsetting users with a flood of messages
+		// * In gospy case we need to add ability for users to bring their own logger, we can't just use logrus here
+		return
+	}
+	if len(stack) == 0 {
+		return
+	}
+
+	ps.trieMutex.Lock()
+	defer ps.trieMutex.Unlock()
+
+	i := 0
+	if ps.spyName == types.GoSpy {
+		i = spyIndex
+	}
+	key := labels.String()
+	if key != "" && len(ps.tags) > 0 {
+		// labels of samples take precedence over static tags
+		merged := make(spy.Labels, len(ps.tags)+len(labels))
+		for k, v := range ps.tags {
+			merged[k] = v
+		}
+		for k, v := range labels {
+			merged[k] = v
+		}
+		key = merged.String()
+	}
+	tries, ok := ps.tries[key]
+	if !ok {
+		tries = ps.newTries()
+		ps.tries[key] = tries
+	}
+	if tries[i] == nil {
+		tries[i] = transporttrie.New()
+	}
+	tries[i].Insert(stack, v, true)
+}
+
 func (ps *ProfileSession) Start() error {
 	ps.reset()
 
 	if ps.spyName == types.GoSpy {
 		for _, pt := range ps.profileTypes {
-			s, err := gospy.Start(pt, ps.sampleRate, ps.disableGCRuns)
+			s, err := gospy.Start(pt, ps.sampleRate, ps.disableGCRuns, ps.frameFormat)
 			if err != nil {
 				return err
 			}
@@ -191,6 +258,17 @@ func (ps *ProfileSession) reset() {
 	}
 }
 
+// Flush uploads the profiling data collected so far without waiting for the end
+// of the current upload interval, e.g. before a short-lived program exits.
+func (ps *ProfileSession) Flush() {
+	done := make(chan struct{})
+	select {
+	case ps.flushCh <- done:
+		<-done
+	case <-ps.stopCh:
+	}
+}
+
 func (ps *ProfileSession) Stop() {
 	ps.trieMutex.Lock()
 	defer ps.trieMutex.Unlock()
@@ -205,15 +283,37 @@ func (ps *ProfileSession) Stop() {
 
 // upload the read profile data about 10s to server
 func (ps *ProfileSession) uploadTries(now time.Time) {
-	for i, trie := range ps.tries {
+	for labels, tries := range ps.tries {
+		ps.uploadLabelledTries(now, labels, tries)
+		// tries of label sets are only kept as long as there are samples with these labels.
+		// Go runtime only labels samples of non-cumulative profiles, so previous tries
+		// of label sets are not needed to compute the next diff
+		if labels != "" {
+			delete(ps.tries, labels)
+			delete(ps.previousTries, labels)
+		}
+	}
+}
+
+func (ps *ProfileSession) uploadLabelledTries(now time.Time, labels string, tries []*transporttrie.Trie) {
+	previousTries, ok := ps.previousTries[labels]
+	if !ok {
+		previousTries = ps.newTries()
+		ps.previousTries[labels] = previousTries
+	}
+	for i, trie := range tries {
 		skipUpload := false
 
 		if trie != nil {
 			endTime := now.Truncate(ps.uploadRate)
+			// flushes and stops may happen in the middle of an upload interval
+			if !endTime.After(ps.startTime) {
+				endTime = now
+			}
 
 			uploadTrie := trie
 			if ps.profileTypes[i].IsCumulative() {
-				previousTrie := ps.previousTries[i]
+				previousTrie := previousTries[i]
 				if previousTrie == nil {
 					skipUpload = true
 				} else {
@@ -223,7 +323,10 @@ func (ps *ProfileSession) uploadTries(now time.Time) {
 			}
 
 			if !skipUpload {
-				name := ps.appName + "." + string(ps.profileTypes[i])
+				name := ps.appName + "." + string(ps.profileTypes[i]) + labels
+				if labels == "" {
+					name += ps.tagsKey
+				}
 				ps.upstream.Upload(&upstream.UploadJob{
 					Name:            name,
 					StartTime:       ps.startTime,
@@ -232,14 +335,15 @@ func (ps *ProfileSession) uploadTries(now time.Time) {
 					SampleRate:      ps.sampleRate,
 					Units:           ps.profileTypes[i].Units(),
 					AggregationType: ps.profileTypes[i].AggregationType(),
+					Resolution:      ps.uploadRate,
 					Trie:            uploadTrie,
 				})
 			}
 			if ps.profileTypes[i].IsCumulative() {
-				ps.previousTries[i] = trie
+				previousTries[i] = trie
 			}
 		}
-		ps.tries[i] = transporttrie.New()
+		tries[i] = transporttrie.New()
 	}
 }
 
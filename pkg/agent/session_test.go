@@ -2,6 +2,7 @@ package agent
 
 import (
 	"os"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -17,6 +18,8 @@ import (
 const durThreshold = 30 * time.Millisecond
 
 type upstreamMock struct {
+	m     sync.Mutex
+	names []string
 	tries []*transporttrie.Trie
 }
 
@@ -25,9 +28,27 @@ func (*upstreamMock) Stop() {
 }
 
 func (u *upstreamMock) Upload(j *upstream.UploadJob) {
+	u.m.Lock()
+	defer u.m.Unlock()
+	u.names = append(u.names, j.Name)
 	u.tries = append(u.tries, j.Trie)
 }
 
+type labelledSpy struct{}
+
+func (*labelledSpy) Stop() error { return nil }
+
+func (*labelledSpy) Snapshot(cb func([]byte, uint64, error)) {}
+
+func (*labelledSpy) SnapshotWithLabels(cb func(spy.Labels, []byte, uint64, error)) {
+	cb(nil, []byte("foo;bar"), 1, nil)
+	cb(spy.Labels{"endpoint": "/checkout"}, []byte("foo;baz"), 1, nil)
+}
+
+func init() {
+	spy.RegisterSpy("labelledspy", func(int) (spy.Spy, error) { return &labelledSpy{}, nil })
+}
+
 var _ = Describe("agent.Session", func() {
 	testing.WithConfig(func(cfg **config.Config) {
 		Describe("NewSession", func() {
@@ -64,6 +85,65 @@ var _ = Describe("agent.Session", func() {
 				})
 				close(done)
 			})
+
+			It("adds tags to the application name", func() {
+				os.Setenv("PYROSCOPE_TEST_HOST", "host-1")
+				defer os.Unsetenv("PYROSCOPE_TEST_HOST")
+
+				u := &upstreamMock{}
+				s := NewSession(&SessionConfig{
+					Upstream:       u,
+					AppName:        "test-app",
+					Tags:           map[string]string{"env": "prod", "host": "$PYROSCOPE_TEST_HOST"},
+					ProfilingTypes: []spy.ProfileType{spy.ProfileCPU},
+					SpyName:        "labelledspy",
+					SampleRate:     100,
+					UploadRate:     time.Hour,
+					Pid:            os.Getpid(),
+				}, logrus.StandardLogger())
+				Expect(s.Start()).ToNot(HaveOccurred())
+				time.Sleep(100 * time.Millisecond)
+				s.Stop()
+
+				u.m.Lock()
+				defer u.m.Unlock()
+				Expect(u.names).To(ConsistOf(
+					"test-app.cpu{env=prod,host=host-1}",
+					"test-app.cpu{endpoint=/checkout,env=prod,host=host-1}",
+				))
+			})
+
+			It("uploads samples with labels separately", func() {
+				u := &upstreamMock{}
+				s := NewSession(&SessionConfig{
+					Upstream:       u,
+					AppName:        "test-app",
+					ProfilingTypes: []spy.ProfileType{spy.ProfileCPU},
+					SpyName:        "labelledspy",
+					SampleRate:     100,
+					UploadRate:     time.Hour,
+					Pid:            os.Getpid(),
+				}, logrus.StandardLogger())
+				Expect(s.Start()).ToNot(HaveOccurred())
+				time.Sleep(100 * time.Millisecond)
+				s.Stop()
+
+				u.m.Lock()
+				defer u.m.Unlock()
+				Expect(u.names).To(ConsistOf("test-app.cpu", "test-app.cpu{endpoint=/checkout}"))
+				// label sets are forgotten once their data is uploaded
+				Expect(s.tries).To(HaveLen(1))
+				Expect(s.previousTries).To(HaveLen(1))
+				for i, name := range u.names {
+					u.tries[i].Iterate(func(k []byte, _ uint64) {
+						if name == "test-app.cpu{endpoint=/checkout}" {
+							Expect(string(k)).To(Equal("foo;baz"))
+						} else {
+							Expect(string(k)).To(Equal("foo;bar"))
+						}
+					})
+				}
+			})
 		})
 	})
 })
@@ -3,6 +3,8 @@ package spy
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type Spy interface {
@@ -14,6 +16,48 @@ type Resettable interface {
 	Reset()
 }
 
+// LabelledSpy is implemented by spies that can break samples down by labels,
+// e.g gospy reads labels set with pprof.Do.
+type LabelledSpy interface {
+	SnapshotWithLabels(cb func(Labels, []byte, uint64, error))
+}
+
+// Labels is a set of labels attached to profiling samples.
+type Labels map[string]string
+
+// String returns labels in the format used in application names, e.g {endpoint=/checkout,tenant=a}.
+// Labels are sorted by key. Labels with names or values that would break the format are skipped.
+// Empty string is returned if there are no labels.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k, v := range l {
+		if validLabel(k, v) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(l[k])
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func validLabel(k, v string) bool {
+	return k != "" && k != "__name__" && v != "" && !strings.ContainsAny(k+v, "{}=,")
+}
+
 type ProfileType string
 
 const (
@@ -22,6 +66,14 @@ const (
 	ProfileAllocObjects ProfileType = "alloc_objects"
 	ProfileInuseSpace   ProfileType = "inuse_space"
 	ProfileAllocSpace   ProfileType = "alloc_space"
+	ProfileGoroutines   ProfileType = "goroutines"
+
+	// Mutex and block profiles are only collected if enabled
+	// with runtime.SetMutexProfileFraction and runtime.SetBlockProfileRate.
+	ProfileMutexCount    ProfileType = "mutex_count"
+	ProfileMutexDuration ProfileType = "mutex_duration"
+	ProfileBlockCount    ProfileType = "block_count"
+	ProfileBlockDuration ProfileType = "block_duration"
 
 	Go     = "gospy"
 	Python = "pyspy"
@@ -29,22 +81,34 @@ const (
 )
 
 func (t ProfileType) IsCumulative() bool {
-	return t == ProfileAllocObjects || t == ProfileAllocSpace
+	switch t {
+	case ProfileAllocObjects, ProfileAllocSpace,
+		ProfileMutexCount, ProfileMutexDuration,
+		ProfileBlockCount, ProfileBlockDuration:
+		return true
+	}
+	return false
 }
 
 func (t ProfileType) Units() string {
-	if t == ProfileInuseObjects || t == ProfileAllocObjects {
+	switch t {
+	case ProfileInuseObjects, ProfileAllocObjects:
 		return "objects"
-	}
-	if t == ProfileInuseSpace || t == ProfileAllocSpace {
+	case ProfileInuseSpace, ProfileAllocSpace:
 		return "bytes"
+	case ProfileGoroutines:
+		return "goroutines"
+	case ProfileMutexCount, ProfileBlockCount:
+		return "lock_samples"
+	case ProfileMutexDuration, ProfileBlockDuration:
+		return "lock_nanoseconds"
 	}
 
 	return "samples"
 }
 
 func (t ProfileType) AggregationType() string {
-	if t == ProfileInuseObjects || t == ProfileInuseSpace {
+	if t == ProfileInuseObjects || t == ProfileInuseSpace || t == ProfileGoroutines {
 		return "average"
 	}
 
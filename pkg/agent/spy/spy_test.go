@@ -0,0 +1,37 @@
+package spy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Labels", func() {
+	It("formats labels sorted by key", func() {
+		Expect(Labels(nil).String()).To(BeEmpty())
+		Expect(Labels{"tenant": "a", "endpoint": "/checkout"}.String()).To(Equal("{endpoint=/checkout,tenant=a}"))
+	})
+
+	It("skips labels that would break the format", func() {
+		Expect(Labels{"__name__": "foo", "a": "b,c", "d{": "e", "f": ""}.String()).To(BeEmpty())
+		Expect(Labels{"a": "b=c", "ok": "1"}.String()).To(Equal("{ok=1}"))
+	})
+})
+
+var _ = Describe("ProfileType", func() {
+	It("describes lock and goroutine profiles", func() {
+		Expect(ProfileGoroutines.Units()).To(Equal("goroutines"))
+		Expect(ProfileGoroutines.AggregationType()).To(Equal("average"))
+		Expect(ProfileGoroutines.IsCumulative()).To(BeFalse())
+
+		for _, pt := range []ProfileType{ProfileMutexCount, ProfileBlockCount} {
+			Expect(pt.Units()).To(Equal("lock_samples"))
+			Expect(pt.AggregationType()).To(Equal("sum"))
+			Expect(pt.IsCumulative()).To(BeTrue())
+		}
+		for _, pt := range []ProfileType{ProfileMutexDuration, ProfileBlockDuration} {
+			Expect(pt.Units()).To(Equal("lock_nanoseconds"))
+			Expect(pt.AggregationType()).To(Equal("sum"))
+			Expect(pt.IsCumulative()).To(BeTrue())
+		}
+	})
+})
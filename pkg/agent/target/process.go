@@ -0,0 +1,245 @@
+package target
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-ps"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/pyspy"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/rbspy"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+// processScanInterval specifies how often the list of running processes is checked
+// for new matching processes and exited ones.
+var processScanInterval = 10 * time.Second
+
+// processTarget attaches to all running processes matching the target: by PID file,
+// executable name or command line. Every process is profiled in a separate session,
+// the data is tagged with the process PID. The matching processes are rescanned
+// periodically, so that restarted processes are re-attached.
+type processTarget struct {
+	logger *logrus.Entry
+	target config.Target
+	sc     agent.SessionConfig
+	match  func() ([]int, error)
+
+	sessions map[int]*agent.ProfileSession
+	// failed holds matching processes the spy could not be attached to,
+	// so that the error is not logged again on every scan.
+	failed map[int]struct{}
+}
+
+func newProcessTarget(logger *logrus.Logger, u upstream.Upstream, c *config.Agent, t config.Target) *processTarget {
+	p := processTarget{
+		target: t,
+		sc: agent.SessionConfig{
+			Upstream:         u,
+			AppName:          t.ApplicationName,
+			Tags:             t.Tags,
+			ProfilingTypes:   []spy.ProfileType{spy.ProfileCPU},
+			SpyName:          t.SpyName,
+			SampleRate:       uint32(t.SampleRate),
+			UploadRate:       c.UploadRate,
+			WithSubprocesses: t.DetectSubprocesses,
+		},
+		sessions: make(map[int]*agent.ProfileSession),
+		failed:   make(map[int]struct{}),
+	}
+	fields := logrus.Fields{
+		"app-name": t.ApplicationName,
+		"spy-name": t.SpyName,
+	}
+	switch {
+	case t.PidFile != "":
+		fields["pid-file"] = t.PidFile
+		p.match = func() ([]int, error) { return pidFromFile(t.PidFile) }
+	case t.ProcessName != "":
+		fields["process-name"] = t.ProcessName
+		p.match = func() ([]int, error) {
+			return findProcesses(func(proc ps.Process) bool { return processNameMatches(proc, t.ProcessName) })
+		}
+	default:
+		fields["cmdline-regex"] = t.CmdlineRegex
+		re := regexp.MustCompile(t.CmdlineRegex)
+		p.match = func() ([]int, error) {
+			return findProcesses(func(proc ps.Process) bool {
+				cmdline, err := processCmdline(proc.Pid())
+				return err == nil && re.MatchString(cmdline)
+			})
+		}
+	}
+	p.logger = logger.WithFields(fields)
+	return &p
+}
+
+func (p *processTarget) attach(ctx context.Context) {
+	// TODO: this is somewhat hacky, we need to find a better way to configure agents
+	pyspy.Blocking = p.target.PyspyBlocking
+	rbspy.Blocking = p.target.RbspyBlocking
+
+	defer func() {
+		for pid := range p.sessions {
+			p.detach(pid)
+		}
+	}()
+
+	t := time.NewTicker(processScanInterval)
+	defer t.Stop()
+	for {
+		p.scan()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *processTarget) scan() {
+	pids, err := p.match()
+	if err != nil {
+		p.logger.WithError(err).Debug("failed to find matching processes")
+	}
+	if p.sc.WithSubprocesses && len(pids) > 1 {
+		// subprocesses are profiled by the session of their parent
+		// process, attaching to them separately would profile them twice
+		pids = withoutSubprocesses(pids)
+	}
+	matching := make(map[int]struct{}, len(pids))
+	for _, pid := range pids {
+		matching[pid] = struct{}{}
+	}
+	for pid := range p.sessions {
+		if _, ok := matching[pid]; !ok {
+			p.detach(pid)
+		}
+	}
+	for pid := range p.failed {
+		if _, ok := matching[pid]; !ok {
+			delete(p.failed, pid)
+		}
+	}
+	for _, pid := range pids {
+		if _, ok := p.sessions[pid]; !ok {
+			p.start(pid)
+		}
+	}
+}
+
+func (p *processTarget) start(pid int) {
+	logger := p.logger.WithField("pid", pid)
+	sc := p.sc
+	sc.Pid = pid
+	sc.Tags = make(map[string]string, len(p.sc.Tags)+1)
+	for k, v := range p.sc.Tags {
+		sc.Tags[k] = v
+	}
+	sc.Tags["pid"] = strconv.Itoa(pid)
+	session := agent.NewSession(&sc, p.logger.Logger)
+	if err := session.Start(); err != nil {
+		// the process is retried on the next scan, but the error
+		// is only reported once for every process
+		if _, ok := p.failed[pid]; ok {
+			logger.WithError(err).Debug("failed to attach spy to process")
+			return
+		}
+		p.failed[pid] = struct{}{}
+		logger.WithError(err).Error("failed to attach spy to process")
+		return
+	}
+	logger.Debug("started session")
+	delete(p.failed, pid)
+	p.sessions[pid] = session
+}
+
+func (p *processTarget) detach(pid int) {
+	p.sessions[pid].Stop()
+	delete(p.sessions, pid)
+	p.logger.WithField("pid", pid).Debug("session ended")
+}
+
+func pidFromFile(path string) ([]int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pid file: %w", err)
+	}
+	proc, err := ps.FindProcess(pid)
+	if err != nil || proc == nil {
+		return nil, err
+	}
+	return []int{pid}, nil
+}
+
+func processNameMatches(p ps.Process, name string) bool {
+	if p.Executable() == name {
+		return true
+	}
+	// process names may be truncated, e.g. to 15 characters on linux
+	if exe, err := processExe(p.Pid()); err == nil && filepath.Base(exe) == name {
+		return true
+	}
+	return false
+}
+
+func findProcesses(match func(ps.Process) bool) ([]int, error) {
+	procs, err := ps.Processes()
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	var pids []int
+	for _, proc := range procs {
+		if proc.Pid() != self && match(proc) {
+			pids = append(pids, proc.Pid())
+		}
+	}
+	return pids, nil
+}
+
+// withoutSubprocesses removes processes that have an ancestor among pids.
+func withoutSubprocesses(pids []int) []int {
+	procs, err := ps.Processes()
+	if err != nil {
+		return pids
+	}
+	parents := make(map[int]int, len(procs))
+	for _, proc := range procs {
+		parents[proc.Pid()] = proc.PPid()
+	}
+	set := make(map[int]struct{}, len(pids))
+	for _, pid := range pids {
+		set[pid] = struct{}{}
+	}
+	res := pids[:0]
+	for _, pid := range pids {
+		subprocess := false
+		// the number of steps is limited in case of a pid reuse cycle
+		for ppid, i := parents[pid], 0; ppid > 0 && i < len(parents); ppid, i = parents[ppid], i+1 {
+			if _, ok := set[ppid]; ok {
+				subprocess = true
+				break
+			}
+		}
+		if !subprocess {
+			res = append(res, pid)
+		}
+	}
+	return res
+}
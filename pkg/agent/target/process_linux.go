@@ -0,0 +1,21 @@
+package target
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+func processCmdline(pid int) (string, error) {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return "", err
+	}
+	// arguments are separated and terminated by null bytes
+	return string(bytes.ReplaceAll(bytes.TrimRight(b, "\x00"), []byte{0}, []byte(" "))), nil
+}
+
+func processExe(pid int) (string, error) {
+	return os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+}
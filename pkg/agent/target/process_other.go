@@ -0,0 +1,15 @@
+// +build !linux
+
+package target
+
+import (
+	"errors"
+)
+
+func processCmdline(_ int) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func processExe(_ int) (string, error) {
+	return "", errors.New("not implemented")
+}
@@ -0,0 +1,152 @@
+// +build debugspy
+
+package target
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mitchellh/go-ps"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("process target", func() {
+	var (
+		u      *upstreamMock
+		ctx    context.Context
+		cancel context.CancelFunc
+		done   chan struct{}
+		cmds   []*exec.Cmd
+	)
+
+	startProcess := func() int {
+		cmd := exec.Command("sleep", "3601")
+		Expect(cmd.Start()).ToNot(HaveOccurred())
+		cmds = append(cmds, cmd)
+		return cmd.Process.Pid
+	}
+
+	stopProcess := func(pid int) {
+		for _, cmd := range cmds {
+			if cmd.Process.Pid == pid {
+				Expect(cmd.Process.Kill()).ToNot(HaveOccurred())
+				cmd.Wait()
+			}
+		}
+	}
+
+	attach := func(t config.Target) {
+		t.SpyName = "debugspy"
+		t.ApplicationName = "my.app"
+		t.SampleRate = 100
+		p := newProcessTarget(logrus.StandardLogger(), u, &config.Agent{UploadRate: 100 * time.Millisecond}, t)
+		go func() {
+			p.attach(ctx)
+			close(done)
+		}()
+	}
+
+	BeforeEach(func() {
+		processScanInterval = 50 * time.Millisecond
+		u = newUpstreamMock()
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		cmds = nil
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+		for _, cmd := range cmds {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	})
+
+	It("attaches to every process matching the command line", func() {
+		pid1 := startProcess()
+		pid2 := startProcess()
+		attach(config.Target{CmdlineRegex: `^sleep 3601$`, Tags: map[string]string{"env": "prod"}})
+
+		Eventually(u.uploaded("my.app.cpu{env=prod,pid=" + strconv.Itoa(pid1) + "}")).Should(BeTrue())
+		Eventually(u.uploaded("my.app.cpu{env=prod,pid=" + strconv.Itoa(pid2) + "}")).Should(BeTrue())
+	})
+
+	It("re-attaches to restarted processes", func() {
+		testing.TmpDir(func(dir string) {
+			pidFile := filepath.Join(dir, "app.pid")
+			pid := startProcess()
+			Expect(ioutil.WriteFile(pidFile, []byte(strconv.Itoa(pid)+"\n"), 0o644)).ToNot(HaveOccurred())
+			attach(config.Target{PidFile: pidFile})
+			Eventually(u.uploaded("my.app.cpu{pid=" + strconv.Itoa(pid) + "}")).Should(BeTrue())
+
+			stopProcess(pid)
+			pid = startProcess()
+			Expect(ioutil.WriteFile(pidFile, []byte(strconv.Itoa(pid)), 0o644)).ToNot(HaveOccurred())
+			Eventually(u.uploaded("my.app.cpu{pid=" + strconv.Itoa(pid) + "}")).Should(BeTrue())
+		})
+	})
+
+	It("does not attach to subprocesses of profiled processes twice", func() {
+		cmd := exec.Command("sh", "-c", "sleep 3602; true")
+		Expect(cmd.Start()).ToNot(HaveOccurred())
+		cmds = append(cmds, cmd)
+		var children []int
+		Eventually(func() []int {
+			children, _ = findProcesses(func(p ps.Process) bool { return p.PPid() == cmd.Process.Pid })
+			return children
+		}).Should(HaveLen(1))
+		child := children[0]
+		defer func() {
+			if p, err := os.FindProcess(child); err == nil {
+				p.Kill()
+			}
+		}()
+
+		attach(config.Target{CmdlineRegex: `sleep 3602`, DetectSubprocesses: true})
+		Eventually(u.uploaded("my.app.cpu{pid=" + strconv.Itoa(cmd.Process.Pid) + "}")).Should(BeTrue())
+		Consistently(u.uploaded("my.app.cpu{pid="+strconv.Itoa(child)+"}"), 300*time.Millisecond).Should(BeFalse())
+	})
+
+	It("reports processes it failed to attach to once", func() {
+		startProcess()
+		logger, hook := logtest.NewNullLogger()
+		logger.SetLevel(logrus.DebugLevel)
+		t := config.Target{CmdlineRegex: `^sleep 3601$`, SpyName: "unknownspy", ApplicationName: "my.app", SampleRate: 100}
+		p := newProcessTarget(logger, u, &config.Agent{UploadRate: 100 * time.Millisecond}, t)
+		go func() {
+			p.attach(ctx)
+			close(done)
+		}()
+
+		attempts := func() int { return len(hook.AllEntries()) }
+		Eventually(attempts).Should(BeNumerically(">=", 3))
+		var errors int
+		for _, e := range hook.AllEntries() {
+			if e.Level == logrus.ErrorLevel {
+				errors++
+			}
+		}
+		Expect(errors).To(Equal(1))
+	})
+
+	It("ignores missing pid files", func() {
+		attach(config.Target{PidFile: filepath.Join(os.TempDir(), "pyroscope-missing.pid")})
+		Consistently(func() int {
+			u.m.Lock()
+			defer u.m.Unlock()
+			return len(u.jobs)
+		}, 300*time.Millisecond).Should(BeZero())
+	})
+})
@@ -0,0 +1,196 @@
+package target
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/types"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/transporttrie"
+)
+
+const (
+	scrapeProfileCPU  = "cpu"
+	scrapeProfileHeap = "heap"
+)
+
+var heapProfileTypes = []spy.ProfileType{
+	spy.ProfileInuseObjects,
+	spy.ProfileInuseSpace,
+	spy.ProfileAllocObjects,
+	spy.ProfileAllocSpace,
+}
+
+// scrapeTarget periodically fetches profiles from net/http/pprof endpoints of a go application.
+type scrapeTarget struct {
+	logger   *logrus.Entry
+	target   config.ScrapeTarget
+	upstream upstream.Upstream
+	client   *http.Client
+	tags     spy.Labels
+
+	// previous profiles of cumulative types by application name,
+	// the difference between subsequent ones is uploaded. Only the profiles
+	// of the last scrape are kept, so label sets that are gone are forgotten
+	previous map[spy.ProfileType]map[string]*transporttrie.Trie
+}
+
+func canoniseScrapeTarget(t *config.ScrapeTarget) error {
+	if !strings.HasPrefix(t.URL, "http://") && !strings.HasPrefix(t.URL, "https://") {
+		return fmt.Errorf("invalid url %q", t.URL)
+	}
+	t.URL = strings.TrimSuffix(t.URL, "/")
+	if t.ScrapeInterval == 0 {
+		t.ScrapeInterval = types.DefaultUploadRate
+	}
+	if t.ScrapeInterval < time.Second || t.ScrapeInterval%time.Second != 0 {
+		return fmt.Errorf("scrape interval must be a whole number of seconds")
+	}
+	if len(t.ProfileTypes) == 0 {
+		t.ProfileTypes = []string{scrapeProfileCPU, scrapeProfileHeap}
+	}
+	for _, pt := range t.ProfileTypes {
+		if pt != scrapeProfileCPU && pt != scrapeProfileHeap {
+			return fmt.Errorf("profile type %q is not supported", pt)
+		}
+	}
+	return nil
+}
+
+func newScrapeTarget(logger *logrus.Logger, u upstream.Upstream, t config.ScrapeTarget) *scrapeTarget {
+	tags := make(spy.Labels, len(t.Tags))
+	for k, v := range t.Tags {
+		tags[k] = os.ExpandEnv(v)
+	}
+	return &scrapeTarget{
+		logger: logger.WithFields(logrus.Fields{
+			"app-name": t.ApplicationName,
+			"url":      t.URL,
+		}),
+		target:   t,
+		upstream: u,
+		client:   &http.Client{Timeout: t.ScrapeInterval + 10*time.Second},
+		tags:     tags,
+		previous: make(map[spy.ProfileType]map[string]*transporttrie.Trie),
+	}
+}
+
+func (s *scrapeTarget) attach(ctx context.Context) {
+	for {
+		st := time.Now()
+		s.scrape(ctx, st)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(st.Add(s.target.ScrapeInterval))):
+		}
+	}
+}
+
+// scrape fetches and uploads all the profiles, it blocks for the scrape interval
+// while the CPU profile is being collected.
+func (s *scrapeTarget) scrape(ctx context.Context, st time.Time) {
+	for _, pt := range s.target.ProfileTypes {
+		var err error
+		switch pt {
+		case scrapeProfileCPU:
+			seconds := int(s.target.ScrapeInterval / time.Second)
+			err = s.scrapeProfile(ctx, st, "/debug/pprof/profile?seconds="+strconv.Itoa(seconds), spy.ProfileCPU)
+		case scrapeProfileHeap:
+			err = s.scrapeProfile(ctx, st, "/debug/pprof/heap", heapProfileTypes...)
+		}
+		if err != nil && ctx.Err() == nil {
+			s.logger.WithError(err).WithField("profile-type", pt).Error("failed to scrape profile")
+		}
+	}
+}
+
+func (s *scrapeTarget) scrapeProfile(ctx context.Context, st time.Time, path string, profileTypes ...spy.ProfileType) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.target.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	p, err := convert.ParsePprof(resp.Body)
+	if err != nil {
+		return fmt.Errorf("parse pprof: %w", err)
+	}
+	et := time.Now()
+	for _, pt := range profileTypes {
+		s.upload(p, pt, st, et)
+	}
+	return nil
+}
+
+func (s *scrapeTarget) upload(p *convert.Profile, pt spy.ProfileType, st, et time.Time) {
+	sampleType := string(pt)
+	sampleRate := uint32(types.DefaultSampleRate)
+	if pt == spy.ProfileCPU {
+		sampleType = "samples"
+		if p.Period > 0 {
+			sampleRate = uint32(time.Second / time.Duration(p.Period))
+		}
+	}
+
+	// samples are split by pprof labels merged with the target tags
+	tries := make(map[string]*transporttrie.Trie)
+	p.GetWithLabels(sampleType, func(labels map[string]string, name []byte, val int) {
+		merged := make(spy.Labels, len(s.tags)+len(labels))
+		for k, v := range s.tags {
+			merged[k] = v
+		}
+		for k, v := range labels {
+			merged[k] = v
+		}
+		key := merged.String()
+		t, ok := tries[key]
+		if !ok {
+			t = transporttrie.New()
+			tries[key] = t
+		}
+		t.Insert(name, uint64(val), true)
+	})
+
+	previous := s.previous[pt]
+	if pt.IsCumulative() {
+		s.previous[pt] = make(map[string]*transporttrie.Trie, len(tries))
+	}
+	for key, t := range tries {
+		name := s.target.ApplicationName + "." + string(pt) + key
+		if pt.IsCumulative() {
+			s.previous[pt][name] = t
+			if previous[name] == nil {
+				continue
+			}
+			t = t.Diff(previous[name])
+		}
+		s.upstream.Upload(&upstream.UploadJob{
+			Name:            name,
+			StartTime:       st,
+			EndTime:         et,
+			SpyName:         types.GoSpy,
+			SampleRate:      sampleRate,
+			Units:           pt.Units(),
+			AggregationType: pt.AggregationType(),
+			Resolution:      s.target.ScrapeInterval,
+			Trie:            t,
+		})
+	}
+}
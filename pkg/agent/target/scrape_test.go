@@ -0,0 +1,143 @@
+package target
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
+	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/transporttrie"
+)
+
+type upstreamMock struct {
+	m    sync.Mutex
+	jobs map[string]*upstream.UploadJob
+}
+
+func newUpstreamMock() *upstreamMock {
+	return &upstreamMock{jobs: make(map[string]*upstream.UploadJob)}
+}
+
+func (*upstreamMock) Stop() {}
+
+func (u *upstreamMock) Upload(j *upstream.UploadJob) {
+	u.m.Lock()
+	defer u.m.Unlock()
+	u.jobs[j.Name] = j
+}
+
+func (u *upstreamMock) uploaded(name string) func() bool {
+	return func() bool {
+		u.m.Lock()
+		defer u.m.Unlock()
+		_, ok := u.jobs[name]
+		return ok
+	}
+}
+
+var _ = Describe("scrape target", func() {
+	var (
+		u      *upstreamMock
+		server *httptest.Server
+		ctx    context.Context
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+
+	BeforeEach(func() {
+		u = newUpstreamMock()
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		cpu, err := ioutil.ReadFile("../../convert/fixtures/cpu.pprof")
+		Expect(err).ToNot(HaveOccurred())
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/profile", func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Query().Get("seconds")).To(Equal("1"))
+			w.Write(cpu)
+		})
+		mux.HandleFunc("/debug/pprof/heap", func(w http.ResponseWriter, r *http.Request) {
+			pprof.Lookup("heap").WriteTo(w, 0)
+		})
+		server = httptest.NewServer(mux)
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+		server.Close()
+	})
+
+	It("uploads scraped profiles", func() {
+		t := config.ScrapeTarget{
+			ApplicationName: "my.app",
+			URL:             server.URL + "/",
+			ScrapeInterval:  time.Second,
+			Tags:            map[string]string{"env": "prod"},
+		}
+		Expect(canoniseScrapeTarget(&t)).ToNot(HaveOccurred())
+		go func() {
+			newScrapeTarget(logrus.StandardLogger(), u, t).attach(ctx)
+			close(done)
+		}()
+
+		Eventually(u.uploaded("my.app.cpu{env=prod}")).Should(BeTrue())
+		Eventually(u.uploaded("my.app.inuse_space{env=prod}")).Should(BeTrue())
+		By("uploading cumulative profiles starting from the second scrape")
+		Eventually(u.uploaded("my.app.alloc_objects{env=prod}"), 5).Should(BeTrue())
+
+		u.m.Lock()
+		defer u.m.Unlock()
+		j := u.jobs["my.app.cpu{env=prod}"]
+		Expect(j.SampleRate).To(Equal(uint32(100)))
+		Expect(j.Resolution).To(Equal(time.Second))
+		stacks := []string{}
+		j.Trie.Iterate(func(name []byte, _ uint64) {
+			stacks = append(stacks, string(name))
+		})
+		Expect(stacks).To(ContainElement("runtime.main;main.main;main.slowFunction;main.work"))
+	})
+
+	It("forgets previous profiles of label sets that are gone", func() {
+		t := config.ScrapeTarget{ApplicationName: "my.app", URL: server.URL + "/"}
+		Expect(canoniseScrapeTarget(&t)).ToNot(HaveOccurred())
+		s := newScrapeTarget(logrus.StandardLogger(), u, t)
+		s.previous[spy.ProfileAllocObjects] = map[string]*transporttrie.Trie{
+			"my.app.alloc_objects{handler=/gone}": transporttrie.New(),
+		}
+
+		var b bytes.Buffer
+		Expect(pprof.Lookup("heap").WriteTo(&b, 0)).ToNot(HaveOccurred())
+		p, err := convert.ParsePprof(&b)
+		Expect(err).ToNot(HaveOccurred())
+		s.upload(p, spy.ProfileAllocObjects, time.Now(), time.Now())
+		Expect(s.previous[spy.ProfileAllocObjects]).To(HaveLen(1))
+		Expect(s.previous[spy.ProfileAllocObjects]).To(HaveKey("my.app.alloc_objects"))
+		close(done)
+	})
+})
+
+var _ = Describe("canoniseScrapeTarget", func() {
+	It("validates targets", func() {
+		Expect(canoniseScrapeTarget(&config.ScrapeTarget{URL: "localhost:6060"})).To(HaveOccurred())
+		Expect(canoniseScrapeTarget(&config.ScrapeTarget{URL: "http://localhost:6060", ScrapeInterval: 1500 * time.Millisecond})).To(HaveOccurred())
+		Expect(canoniseScrapeTarget(&config.ScrapeTarget{URL: "http://localhost:6060", ProfileTypes: []string{"goroutine"}})).To(HaveOccurred())
+
+		t := config.ScrapeTarget{URL: "http://localhost:6060"}
+		Expect(canoniseScrapeTarget(&t)).ToNot(HaveOccurred())
+		Expect(t.ScrapeInterval).To(Equal(10 * time.Second))
+		Expect(t.ProfileTypes).To(Equal([]string{"cpu", "heap"}))
+	})
+})
@@ -28,17 +28,18 @@ type service struct {
 	sc     *agent.SessionConfig
 }
 
-func newServiceTarget(logger *logrus.Logger, upstream *remote.Remote, t config.Target) *service {
+func newServiceTarget(logger *logrus.Logger, upstream *remote.Remote, c *config.Agent, t config.Target) *service {
 	return &service{
 		logger: logger,
 		target: t,
 		sc: &agent.SessionConfig{
 			Upstream:         upstream,
 			AppName:          t.ApplicationName,
+			Tags:             t.Tags,
 			ProfilingTypes:   []spy.ProfileType{spy.ProfileCPU},
 			SpyName:          t.SpyName,
 			SampleRate:       uint32(t.SampleRate),
-			UploadRate:       10 * time.Second,
+			UploadRate:       c.UploadRate,
 			WithSubprocesses: t.DetectSubprocesses,
 			// PID to be specified.
 		},
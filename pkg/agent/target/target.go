@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -69,11 +70,26 @@ func (mgr *Manager) canonise(t *config.Target) error {
 	if !found {
 		return fmt.Errorf("spy %q is not supported", t.SpyName)
 	}
+	var selectors int
+	for _, s := range []string{t.ServiceName, t.PidFile, t.ProcessName, t.CmdlineRegex} {
+		if s != "" {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return fmt.Errorf("only one of service-name, pid-file, process-name and cmdline-regex can be specified")
+	}
+	if t.CmdlineRegex != "" {
+		if _, err := regexp.Compile(t.CmdlineRegex); err != nil {
+			return fmt.Errorf("invalid cmdline-regex: %w", err)
+		}
+	}
 	if t.SampleRate == 0 {
 		t.SampleRate = types.DefaultSampleRate
 	}
 	if t.ApplicationName == "" {
-		t.ApplicationName = t.SpyName + "." + names.GetRandomName(generateSeed(t.ServiceName, t.SpyName))
+		id := t.ServiceName + t.PidFile + t.ProcessName + t.CmdlineRegex
+		t.ApplicationName = t.SpyName + "." + names.GetRandomName(generateSeed(id, t.SpyName))
 		logger := mgr.logger.WithField("spy-name", t.SpyName)
 		if t.ServiceName != "" {
 			logger = logger.WithField("service-name", t.ServiceName)
@@ -105,6 +121,22 @@ func (mgr *Manager) Start() {
 		mgr.wg.Add(1)
 		go mgr.runTarget(tgt)
 	}
+	for _, t := range mgr.config.ScrapeTargets {
+		if err := canoniseScrapeTarget(&t); err != nil {
+			mgr.logger.
+				WithField("app-name", t.ApplicationName).
+				WithField("url", t.URL).
+				WithError(err).Error("failed to setup scrape target")
+			continue
+		}
+		if t.ApplicationName == "" {
+			t.ApplicationName = types.GoSpy + "." + names.GetRandomName(generateSeed(t.URL))
+			mgr.logger.WithField("url", t.URL).
+				Infof("no application name specified for scrape target, we chose %q", t.ApplicationName)
+		}
+		mgr.wg.Add(1)
+		go mgr.runTarget(newScrapeTarget(mgr.logger, mgr.remote, t))
+	}
 }
 
 func (mgr *Manager) Stop() {
@@ -117,7 +149,9 @@ func (mgr *Manager) resolveTarget(t config.Target) (target, bool) {
 	var tgt target
 	switch {
 	case t.ServiceName != "":
-		tgt = newServiceTarget(mgr.logger, mgr.remote, t)
+		tgt = newServiceTarget(mgr.logger, mgr.remote, mgr.config, t)
+	case t.PidFile != "", t.ProcessName != "", t.CmdlineRegex != "":
+		tgt = newProcessTarget(mgr.logger, mgr.remote, mgr.config, t)
 	default:
 		return nil, false
 	}
@@ -40,4 +40,11 @@ var _ = Describe("target", func() {
 
 		Expect(t.attached).ToNot(BeZero())
 	})
+
+	It("validates targets", func() {
+		tgtMgr := NewManager(logrus.StandardLogger(), new(remote.Remote), new(config.Agent))
+		Expect(tgtMgr.canonise(&config.Target{SpyName: "debugspy", CmdlineRegex: "^python .*"})).ToNot(HaveOccurred())
+		Expect(tgtMgr.canonise(&config.Target{SpyName: "debugspy", CmdlineRegex: "("})).To(HaveOccurred())
+		Expect(tgtMgr.canonise(&config.Target{SpyName: "debugspy", ServiceName: "foo", ProcessName: "foo"})).To(HaveOccurred())
+	})
 })
@@ -1,9 +1,14 @@
 package types
 
-import "github.com/pyroscope-io/pyroscope/pkg/agent/spy"
+import (
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
+)
 
 const (
 	DefaultSampleRate = 100 // 100 times per second
+	DefaultUploadRate = 10 * time.Second
 	GoSpy             = spy.Go
 	PySpy             = spy.Python
 	RbSpy             = spy.Ruby
@@ -71,6 +71,7 @@ func (u *Direct) uploadProfile(j *upstream.UploadJob) {
 		SampleRate:      j.SampleRate,
 		Units:           j.Units,
 		AggregationType: j.AggregationType,
+		Resolution:      j.Resolution,
 	}
 	if err = u.s.Put(pi); err != nil {
 		logrus.WithError(err).Error("failed to store a local profile")
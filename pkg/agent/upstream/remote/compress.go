@@ -0,0 +1,49 @@
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// compress returns the upload body compressed with the given algorithm
+// and the corresponding Content-Encoding header value
+func compress(algorithm string, b []byte) ([]byte, string, error) {
+	switch algorithm {
+	case "", CompressionNone:
+		return b, "", nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, "", err
+		}
+		if err := w.Close(); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "gzip", nil
+	case CompressionZstd:
+		c, err := zstdCompress(b)
+		return c, "zstd", err
+	}
+	return nil, "", fmt.Errorf("unknown compression algorithm %q", algorithm)
+}
+
+func validateCompression(algorithm string) error {
+	switch algorithm {
+	case "", CompressionNone, CompressionGzip:
+		return nil
+	case CompressionZstd:
+		if !zstdSupported {
+			return fmt.Errorf("zstd compression is not supported by this build, it requires cgo")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown compression algorithm %q", algorithm)
+}
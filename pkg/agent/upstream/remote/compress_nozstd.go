@@ -0,0 +1,12 @@
+// +build !cgo
+
+package remote
+
+import "errors"
+
+// zstd library used here is a cgo wrapper, so apps built without cgo can only use gzip
+const zstdSupported = false
+
+func zstdCompress(_ []byte) ([]byte, error) {
+	return nil, errors.New("zstd compression requires cgo")
+}
@@ -0,0 +1,11 @@
+// +build cgo
+
+package remote
+
+import "github.com/DataDog/zstd"
+
+const zstdSupported = true
+
+func zstdCompress(b []byte) ([]byte, error) {
+	return zstd.Compress(nil, b)
+}
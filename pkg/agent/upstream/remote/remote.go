@@ -16,22 +16,35 @@ import (
 
 	"github.com/pyroscope-io/pyroscope/pkg/agent"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+	"github.com/pyroscope-io/pyroscope/pkg/util/metrics"
 )
 
 var (
 	ErrCloudTokenRequired = errors.New("Please provide an authentication token. You can find it here: https://pyroscope.io/cloud")
 	ErrUpload             = errors.New("Failed to upload a profile")
 	cloudHostnameSuffix   = "pyroscope.cloud"
+
+	maxRetryBackoff    = time.Minute
+	spoolDrainInterval = 10 * time.Second
+	// maxSpooledJobAttempts is the number of times the server may fail to accept
+	// a spooled job before it's dropped, so that it doesn't block the jobs behind it
+	maxSpooledJobAttempts = 5
 )
 
 type Remote struct {
 	cfg    RemoteConfig
 	jobs   chan *upstream.UploadJob
 	client *http.Client
+	spool  *spool
 	Logger agent.Logger
 
 	done chan struct{}
 	wg   sync.WaitGroup
+
+	// pending is the number of jobs passed to Upload that haven't been processed yet
+	pendingMutex sync.Mutex
+	pendingCond  *sync.Cond
+	pending      int
 }
 
 type RemoteConfig struct {
@@ -39,6 +52,18 @@ type RemoteConfig struct {
 	UpstreamThreads        int
 	UpstreamAddress        string
 	UpstreamRequestTimeout time.Duration
+	// UpstreamCompression is the algorithm used to compress upload bodies: none|gzip|zstd
+	UpstreamCompression string
+
+	// UpstreamMaxRetries is the number of times a failed upload is retried,
+	// the delay between attempts starts at UpstreamRetryBackoff and doubles every time
+	UpstreamMaxRetries   int
+	UpstreamRetryBackoff time.Duration
+
+	// SpoolPath is a directory where jobs are stored when they can't be uploaded
+	// after all the retries, or when the upload queue is full. Disabled if empty
+	SpoolPath    string
+	SpoolMaxSize int64
 
 	ManualStart bool
 }
@@ -56,6 +81,7 @@ func New(cfg RemoteConfig, logger agent.Logger) (*Remote, error) {
 		Logger: logger,
 		done:   make(chan struct{}),
 	}
+	remote.pendingCond = sync.NewCond(&remote.pendingMutex)
 
 	// parse the upstream address
 	u, err := url.Parse(cfg.UpstreamAddress)
@@ -68,6 +94,16 @@ func New(cfg RemoteConfig, logger agent.Logger) (*Remote, error) {
 		return nil, ErrCloudTokenRequired
 	}
 
+	if err = validateCompression(cfg.UpstreamCompression); err != nil {
+		return nil, err
+	}
+
+	if cfg.SpoolPath != "" {
+		if remote.spool, err = newSpool(cfg.SpoolPath, cfg.SpoolMaxSize); err != nil {
+			return nil, fmt.Errorf("spool: %v", err)
+		}
+	}
+
 	if !cfg.ManualStart {
 		// start goroutines for uploading profile data
 		remote.start()
@@ -82,8 +118,13 @@ func (r *Remote) Start() {
 
 func (r *Remote) start() {
 	for i := 0; i < r.cfg.UpstreamThreads; i++ {
+		r.wg.Add(1)
 		go r.handleJobs()
 	}
+	if r.spool != nil {
+		r.wg.Add(1)
+		go r.drainSpool()
+	}
 }
 
 func (r *Remote) Stop() {
@@ -93,16 +134,74 @@ func (r *Remote) Stop() {
 
 	// wait for uploading goroutines exit
 	r.wg.Wait()
+
+	// jobs that haven't been uploaded yet will be uploaded after restart
+	for {
+		select {
+		case job := <-r.jobs:
+			if r.spool != nil {
+				r.spoolJob(job)
+			}
+			r.jobDone()
+		default:
+			return
+		}
+	}
 }
 
 func (r *Remote) Upload(job *upstream.UploadJob) {
+	r.pendingMutex.Lock()
+	r.pending++
+	r.pendingMutex.Unlock()
+
 	select {
 	case r.jobs <- job:
 	default:
+		defer r.jobDone()
+		if r.spool != nil {
+			r.spoolJob(job)
+			return
+		}
+		metrics.Count("agent_upload_jobs_dropped", 1)
 		r.Logger.Errorf("remote upload queue is full, dropping a profile job")
 	}
 }
 
+// Flush blocks until all the jobs passed to Upload so far are either uploaded,
+// spooled or dropped after all the retries.
+func (r *Remote) Flush() {
+	r.pendingMutex.Lock()
+	defer r.pendingMutex.Unlock()
+	for r.pending > 0 {
+		r.pendingCond.Wait()
+	}
+}
+
+// FlushTimeout is like Flush, but it gives up after the timeout.
+// It returns false if some jobs are still pending
+func (r *Remote) FlushTimeout(timeout time.Duration) bool {
+	flushed := make(chan struct{})
+	go func() {
+		r.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (r *Remote) jobDone() {
+	r.pendingMutex.Lock()
+	defer r.pendingMutex.Unlock()
+	r.pending--
+	if r.pending == 0 {
+		r.pendingCond.Broadcast()
+	}
+}
+
 // UploadSync is only used in benchmarks right now
 func (r *Remote) UploadSync(job *upstream.UploadJob) error {
 	return r.uploadProfile(job)
@@ -123,17 +222,28 @@ func (r *Remote) uploadProfile(j *upstream.UploadJob) error {
 	q.Set("sampleRate", strconv.Itoa(int(j.SampleRate)))
 	q.Set("units", j.Units)
 	q.Set("aggregationType", j.AggregationType)
+	if j.Resolution > 0 {
+		q.Set("resolution", strconv.Itoa(int(j.Resolution/time.Second)))
+	}
 
 	u.Path = path.Join(u.Path, "/ingest")
 	u.RawQuery = q.Encode()
 
 	r.Logger.Debugf("uploading at %s", u.String())
+	body, encoding, err := compress(r.cfg.UpstreamCompression, j.Trie.Bytes())
+	if err != nil {
+		return fmt.Errorf("compress: %v", err)
+	}
+
 	// new a request for the job
-	request, err := http.NewRequest("POST", u.String(), bytes.NewReader(j.Trie.Bytes()))
+	request, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("new http request: %v", err)
 	}
 	request.Header.Set("Content-Type", "binary/octet-stream+trie")
+	if encoding != "" {
+		request.Header.Set("Content-Encoding", encoding)
+	}
 
 	if r.cfg.AuthToken != "" {
 		request.Header.Set("Authorization", "Bearer "+r.cfg.AuthToken)
@@ -153,20 +263,57 @@ func (r *Remote) uploadProfile(j *upstream.UploadJob) error {
 	}
 
 	if response.StatusCode != 200 {
-		return ErrUpload
+		return statusError(response.StatusCode)
 	}
 
 	return nil
 }
 
+// statusError is returned when the server responds with a non-200 status code
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("%v: server responded with status %d", ErrUpload, int(e))
+}
+
+func (e statusError) Unwrap() error {
+	return ErrUpload
+}
+
+// retriable reports whether the upload might succeed if it's tried again later.
+// Client errors (e.g. an invalid auth token) won't go away by themselves
+func retriable(err error) bool {
+	var se statusError
+	if errors.As(err, &se) {
+		return se >= 500 || se == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// unavailable reports whether the upload failed because the server could not be reached
+// or is temporarily unable to handle requests, rather than because of the job itself
+func unavailable(err error) bool {
+	var se statusError
+	if !errors.As(err, &se) {
+		return true
+	}
+	switch se {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
 // handle the jobs
 func (r *Remote) handleJobs() {
+	defer r.wg.Done()
 	for {
 		select {
 		case <-r.done:
 			return
 		case job := <-r.jobs:
 			r.safeUpload(job)
+			r.jobDone()
 		}
 	}
 }
@@ -183,8 +330,104 @@ func (r *Remote) safeUpload(job *upstream.UploadJob) {
 		}
 	}()
 
-	// update the profile data to server
-	if err := r.uploadProfile(job); err != nil {
-		r.Logger.Errorf("upload profile: %v", err)
+	backoff := r.cfg.UpstreamRetryBackoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		// update the profile data to server
+		err := r.uploadProfile(job)
+		if err == nil {
+			return
+		}
+		if !retriable(err) {
+			metrics.Count("agent_upload_jobs_dropped", 1)
+			r.Logger.Errorf("upload profile: %v", err)
+			return
+		}
+		if attempt >= r.cfg.UpstreamMaxRetries {
+			r.Logger.Errorf("upload profile: %v", err)
+			r.spoolJob(job)
+			return
+		}
+
+		if attempt == 0 {
+			metrics.Count("agent_upload_jobs_retried", 1)
+		}
+		r.Logger.Debugf("upload profile: %v, retrying in %v", err, backoff)
+		select {
+		case <-r.done:
+			r.spoolJob(job)
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// spoolJob saves the job so that it's uploaded once the server is reachable again.
+// The job is dropped if spooling is disabled
+func (r *Remote) spoolJob(job *upstream.UploadJob) {
+	if r.spool == nil {
+		metrics.Count("agent_upload_jobs_dropped", 1)
+		return
+	}
+	dropped, err := r.spool.push(job)
+	if err != nil {
+		metrics.Count("agent_upload_jobs_dropped", 1)
+		r.Logger.Errorf("spool profile: %v", err)
+	} else {
+		metrics.Count("agent_upload_jobs_spooled", 1)
+	}
+	if dropped > 0 {
+		metrics.Count("agent_upload_jobs_dropped", dropped)
+		r.Logger.Errorf("spool is full, dropped %d profile jobs", dropped)
+	}
+}
+
+func (r *Remote) drainSpool() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(spoolDrainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.uploadSpooled()
+		}
+	}
+}
+
+// uploadSpooled uploads spooled jobs, oldest first, until the queue is empty or an upload fails
+func (r *Remote) uploadSpooled() {
+	for {
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		name, job, err := r.spool.peek()
+		if name == "" {
+			return
+		}
+		if err == nil {
+			err = r.uploadProfile(job)
+			if err != nil && retriable(err) && (unavailable(err) || r.spool.failed(name) < maxSpooledJobAttempts) {
+				r.Logger.Debugf("upload spooled profile: %v", err)
+				return
+			}
+		}
+		if err != nil {
+			metrics.Count("agent_upload_jobs_dropped", 1)
+			r.Logger.Errorf("upload spooled profile: %v", err)
+		}
+		if err = r.spool.remove(name); err != nil {
+			r.Logger.Errorf("remove spooled profile: %v", err)
+			return
+		}
 	}
 }
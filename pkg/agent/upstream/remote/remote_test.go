@@ -1,12 +1,14 @@
 package remote
 
 import (
+	"compress/gzip"
 	"fmt"
 	"html"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -72,5 +74,232 @@ var _ = Describe("remote.Remote", func() {
 			}()
 			Eventually(done, 5).Should(BeClosed())
 		})
+
+		newJob := func(name string) *upstream.UploadJob {
+			t := transporttrie.New()
+			t.Insert([]byte("foo;bar"), 1)
+			return &upstream.UploadJob{
+				Name:       name,
+				StartTime:  testing.SimpleTime(0),
+				EndTime:    testing.SimpleTime(10),
+				SpyName:    "debugspy",
+				SampleRate: 100,
+				Units:      "samples",
+				Trie:       t,
+			}
+		}
+
+		It("retries failed uploads", func() {
+			var requests int32
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer httpServer.Close()
+
+			r, err := New(RemoteConfig{
+				UpstreamThreads:        1,
+				UpstreamAddress:        httpServer.URL,
+				UpstreamRequestTimeout: time.Second,
+				UpstreamMaxRetries:     3,
+				UpstreamRetryBackoff:   10 * time.Millisecond,
+			}, logrus.New())
+			Expect(err).ToNot(HaveOccurred())
+			defer r.Stop()
+
+			r.Upload(newJob("test{}"))
+			Eventually(func() int32 { return atomic.LoadInt32(&requests) }).Should(Equal(int32(3)))
+			Consistently(func() int32 { return atomic.LoadInt32(&requests) }, 0.1).Should(Equal(int32(3)))
+		})
+
+		It("does not retry client errors", func() {
+			var requests int32
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+			defer httpServer.Close()
+
+			r, err := New(RemoteConfig{
+				UpstreamThreads:        1,
+				UpstreamAddress:        httpServer.URL,
+				UpstreamRequestTimeout: time.Second,
+				UpstreamMaxRetries:     3,
+				UpstreamRetryBackoff:   10 * time.Millisecond,
+			}, logrus.New())
+			Expect(err).ToNot(HaveOccurred())
+			defer r.Stop()
+
+			r.Upload(newJob("test{}"))
+			Eventually(func() int32 { return atomic.LoadInt32(&requests) }).Should(Equal(int32(1)))
+			Consistently(func() int32 { return atomic.LoadInt32(&requests) }, 0.1).Should(Equal(int32(1)))
+		})
+
+		It("flushes queued jobs", func() {
+			var requests int32
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(50 * time.Millisecond)
+				atomic.AddInt32(&requests, 1)
+			}))
+			defer httpServer.Close()
+
+			r, err := New(RemoteConfig{
+				UpstreamThreads:        1,
+				UpstreamAddress:        httpServer.URL,
+				UpstreamRequestTimeout: time.Second,
+			}, logrus.New())
+			Expect(err).ToNot(HaveOccurred())
+			defer r.Stop()
+
+			for i := 0; i < 3; i++ {
+				r.Upload(newJob("test{}"))
+			}
+			r.Flush()
+			Expect(atomic.LoadInt32(&requests)).To(Equal(int32(3)))
+		})
+
+		It("stops waiting for retried jobs after the flush timeout", func() {
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer httpServer.Close()
+
+			r, err := New(RemoteConfig{
+				UpstreamThreads:        1,
+				UpstreamAddress:        httpServer.URL,
+				UpstreamRequestTimeout: time.Second,
+				UpstreamMaxRetries:     3,
+				UpstreamRetryBackoff:   time.Second,
+			}, logrus.New())
+			Expect(err).ToNot(HaveOccurred())
+
+			r.Upload(newJob("test{}"))
+			start := time.Now()
+			Expect(r.FlushTimeout(100 * time.Millisecond)).To(BeFalse())
+			r.Stop()
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(r.FlushTimeout(100 * time.Millisecond)).To(BeTrue())
+		})
+
+		It("compresses upload bodies", func() {
+			done := make(chan *transporttrie.Trie, 1)
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.Header.Get("Content-Encoding")).To(Equal("gzip"))
+				gr, err := gzip.NewReader(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				t, err := transporttrie.Deserialize(gr)
+				Expect(err).ToNot(HaveOccurred())
+				done <- t
+			}))
+			defer httpServer.Close()
+
+			r, err := New(RemoteConfig{
+				UpstreamThreads:        1,
+				UpstreamAddress:        httpServer.URL,
+				UpstreamRequestTimeout: time.Second,
+				UpstreamCompression:    CompressionGzip,
+			}, logrus.New())
+			Expect(err).ToNot(HaveOccurred())
+			defer r.Stop()
+
+			job := newJob("test{}")
+			r.Upload(job)
+			var t *transporttrie.Trie
+			Eventually(done).Should(Receive(&t))
+			Expect(t.String()).To(Equal(job.Trie.String()))
+
+			_, err = New(RemoteConfig{UpstreamAddress: httpServer.URL, UpstreamCompression: "lz4"}, logrus.New())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("spools jobs until the server is available", func() {
+			defer func(v time.Duration) { spoolDrainInterval = v }(spoolDrainInterval)
+			spoolDrainInterval = 10 * time.Millisecond
+
+			var available int32
+			var namesMutex sync.Mutex
+			names := []string{}
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&available) == 0 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				namesMutex.Lock()
+				names = append(names, r.URL.Query().Get("name"))
+				namesMutex.Unlock()
+			}))
+			defer httpServer.Close()
+
+			testing.TmpDir(func(dir string) {
+				r, err := New(RemoteConfig{
+					UpstreamThreads:        1,
+					UpstreamAddress:        httpServer.URL,
+					UpstreamRequestTimeout: time.Second,
+					SpoolPath:              dir,
+					SpoolMaxSize:           1 << 20,
+				}, logrus.New())
+				Expect(err).ToNot(HaveOccurred())
+				defer r.Stop()
+
+				r.Upload(newJob("foo{}"))
+				r.Upload(newJob("bar{}"))
+				Eventually(r.spool.len).Should(Equal(2))
+
+				atomic.StoreInt32(&available, 1)
+				Eventually(r.spool.len).Should(BeZero())
+				namesMutex.Lock()
+				Expect(names).To(Equal([]string{"foo{}", "bar{}"}))
+				namesMutex.Unlock()
+			})
+		})
+
+		It("drops spooled jobs the server keeps failing to accept", func() {
+			defer func(v time.Duration) { spoolDrainInterval = v }(spoolDrainInterval)
+			spoolDrainInterval = 10 * time.Millisecond
+
+			var available int32
+			var failures int32
+			var namesMutex sync.Mutex
+			names := []string{}
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case atomic.LoadInt32(&available) == 0:
+					w.WriteHeader(http.StatusServiceUnavailable)
+				case r.URL.Query().Get("name") == "foo{}":
+					atomic.AddInt32(&failures, 1)
+					w.WriteHeader(http.StatusInternalServerError)
+				default:
+					namesMutex.Lock()
+					names = append(names, r.URL.Query().Get("name"))
+					namesMutex.Unlock()
+				}
+			}))
+			defer httpServer.Close()
+
+			testing.TmpDir(func(dir string) {
+				r, err := New(RemoteConfig{
+					UpstreamThreads:        1,
+					UpstreamAddress:        httpServer.URL,
+					UpstreamRequestTimeout: time.Second,
+					SpoolPath:              dir,
+					SpoolMaxSize:           1 << 20,
+				}, logrus.New())
+				Expect(err).ToNot(HaveOccurred())
+				defer r.Stop()
+
+				r.Upload(newJob("foo{}"))
+				r.Upload(newJob("bar{}"))
+				Eventually(r.spool.len).Should(Equal(2))
+
+				atomic.StoreInt32(&available, 1)
+				Eventually(r.spool.len).Should(BeZero())
+				Expect(atomic.LoadInt32(&failures)).To(BeEquivalentTo(maxSpooledJobAttempts))
+				namesMutex.Lock()
+				Expect(names).To(Equal([]string{"bar{}"}))
+				namesMutex.Unlock()
+			})
+		})
 	})
 })
@@ -0,0 +1,223 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/transporttrie"
+	"github.com/pyroscope-io/pyroscope/pkg/util/serialization"
+)
+
+const (
+	spoolFileExt = ".job"
+	// jobs are written to temporary files first, see push
+	spoolTmpFileExt = spoolFileExt + ".tmp"
+)
+
+// spool is a bounded on-disk queue of upload jobs that could not be delivered.
+// Every job is stored in a separate file, file names sort in insertion order.
+// When the queue is full the oldest jobs are dropped.
+type spool struct {
+	dir     string
+	maxSize int64
+
+	m     sync.Mutex
+	seq   uint64
+	size  int64
+	files []spoolFile // oldest first
+	// attempts is the number of failed uploads of the jobs, it's not persisted
+	attempts map[string]int
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+func newSpool(dir string, maxSize int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir, maxSize: maxSize, attempts: make(map[string]int)}
+	// ReadDir returns entries sorted by name
+	for _, fi := range infos {
+		if fi.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(fi.Name(), spoolTmpFileExt) {
+			// left by a crash while the job was written
+			if err = os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !strings.HasSuffix(fi.Name(), spoolFileExt) {
+			continue
+		}
+		s.files = append(s.files, spoolFile{fi.Name(), fi.Size()})
+		s.size += fi.Size()
+	}
+	return s, nil
+}
+
+// push stores the job on disk and returns the number of jobs dropped to make room for it,
+// including the job itself if it's bigger than the whole queue.
+func (s *spool) push(job *upstream.UploadJob) (int, error) {
+	b, err := encodeJob(job)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(len(b))
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if size > s.maxSize {
+		return 1, nil
+	}
+	var dropped int
+	for len(s.files) > 0 && s.size+size > s.maxSize {
+		if err = s.removeOldest(); err != nil {
+			return dropped, err
+		}
+		dropped++
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1e6, spoolFileExt)
+	path := filepath.Join(s.dir, name)
+	// the file is renamed once it's complete, so that a crash never leaves a partially written job
+	if err = ioutil.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return dropped, err
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return dropped, err
+	}
+	s.files = append(s.files, spoolFile{name, size})
+	s.size += size
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+	return dropped, nil
+}
+
+// peek returns the oldest job and its name. The name is empty if the queue is empty.
+func (s *spool) peek() (string, *upstream.UploadJob, error) {
+	s.m.Lock()
+	if len(s.files) == 0 {
+		s.m.Unlock()
+		return "", nil, nil
+	}
+	name := s.files[0].name
+	s.m.Unlock()
+
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return name, nil, err
+	}
+	job, err := decodeJob(b)
+	return name, job, err
+}
+
+// failed records a failed upload of the job and returns the number of failed uploads so far
+func (s *spool) failed(name string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.attempts[name]++
+	return s.attempts[name]
+}
+
+// remove deletes the job, unless it has been dropped already
+func (s *spool) remove(name string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.attempts, name)
+	for i, f := range s.files {
+		if f.name == name {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			s.size -= f.size
+			return os.Remove(filepath.Join(s.dir, name))
+		}
+	}
+	return nil
+}
+
+func (s *spool) removeOldest() error {
+	f := s.files[0]
+	s.files = s.files[1:]
+	s.size -= f.size
+	delete(s.attempts, f.name)
+	if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *spool) len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.files)
+}
+
+func encodeJob(job *upstream.UploadJob) ([]byte, error) {
+	var b bytes.Buffer
+	err := serialization.WriteMetadata(&b, map[string]interface{}{
+		"name":            job.Name,
+		"startTime":       job.StartTime.Unix(),
+		"endTime":         job.EndTime.Unix(),
+		"spyName":         job.SpyName,
+		"sampleRate":      job.SampleRate,
+		"units":           job.Units,
+		"aggregationType": job.AggregationType,
+		"resolution":      int64(job.Resolution / time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = job.Trie.Serialize(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodeJob(b []byte) (*upstream.UploadJob, error) {
+	br := bufio.NewReader(bytes.NewReader(b))
+	metadata, err := serialization.ReadMetadata(br)
+	if err != nil {
+		return nil, err
+	}
+	t, err := transporttrie.Deserialize(br)
+	if err != nil {
+		return nil, err
+	}
+	job := upstream.UploadJob{Trie: t}
+	job.Name, _ = metadata["name"].(string)
+	job.SpyName, _ = metadata["spyName"].(string)
+	job.Units, _ = metadata["units"].(string)
+	job.AggregationType, _ = metadata["aggregationType"].(string)
+	// json numbers are decoded as float64
+	if v, ok := metadata["startTime"].(float64); ok {
+		job.StartTime = time.Unix(int64(v), 0)
+	}
+	if v, ok := metadata["endTime"].(float64); ok {
+		job.EndTime = time.Unix(int64(v), 0)
+	}
+	if v, ok := metadata["sampleRate"].(float64); ok {
+		job.SampleRate = uint32(v)
+	}
+	if v, ok := metadata["resolution"].(float64); ok {
+		job.Resolution = time.Duration(v) * time.Second
+	}
+	return &job, nil
+}
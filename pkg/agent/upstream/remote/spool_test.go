@@ -0,0 +1,94 @@
+package remote
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/transporttrie"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("spool", func() {
+	newJob := func(name string) *upstream.UploadJob {
+		t := transporttrie.New()
+		t.Insert([]byte("foo;bar"), 1)
+		return &upstream.UploadJob{
+			Name:            name,
+			StartTime:       testing.SimpleTime(0),
+			EndTime:         testing.SimpleTime(10),
+			SpyName:         "debugspy",
+			SampleRate:      100,
+			Units:           "samples",
+			AggregationType: "sum",
+			Resolution:      time.Minute,
+			Trie:            t,
+		}
+	}
+
+	It("stores jobs on disk", func() {
+		testing.TmpDir(func(dir string) {
+			s, err := newSpool(dir, 1<<20)
+			Expect(err).ToNot(HaveOccurred())
+			job := newJob("foo{}")
+			Expect(s.push(job)).To(BeZero())
+
+			By("reading jobs saved by a previous instance")
+			s, err = newSpool(dir, 1<<20)
+			Expect(err).ToNot(HaveOccurred())
+			name, restored, err := s.peek()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(name).ToNot(BeEmpty())
+			Expect(restored.Trie.String()).To(Equal(job.Trie.String()))
+			restored.Trie = job.Trie
+			Expect(restored.StartTime.Equal(job.StartTime)).To(BeTrue())
+			Expect(restored.EndTime.Equal(job.EndTime)).To(BeTrue())
+			restored.StartTime, restored.EndTime = job.StartTime, job.EndTime
+			Expect(restored).To(Equal(job))
+
+			Expect(s.remove(name)).ToNot(HaveOccurred())
+			Expect(s.len()).To(BeZero())
+			_, _, size := testing.DirStats(dir)
+			Expect(size).To(BeZero())
+		})
+	})
+
+	It("removes jobs that were not written completely", func() {
+		testing.TmpDir(func(dir string) {
+			s, err := newSpool(dir, 1<<20)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.push(newJob("foo{}"))).To(BeZero())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "00000000000000000001-000001"+spoolTmpFileExt), []byte("foo"), 0o644)).To(Succeed())
+
+			s, err = newSpool(dir, 1<<20)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.len()).To(Equal(1))
+			files, err := filepath.Glob(filepath.Join(dir, "*"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+			Expect(files[0]).To(HaveSuffix(spoolFileExt))
+		})
+	})
+
+	It("drops the oldest jobs when full", func() {
+		testing.TmpDir(func(dir string) {
+			b, err := encodeJob(newJob("foo{}"))
+			Expect(err).ToNot(HaveOccurred())
+			s, err := newSpool(dir, int64(len(b)*2))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(s.push(newJob("foo{}"))).To(BeZero())
+			Expect(s.push(newJob("bar{}"))).To(BeZero())
+			Expect(s.push(newJob("baz{}"))).To(Equal(1))
+			Expect(s.len()).To(Equal(2))
+
+			_, job, err := s.peek()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(job.Name).To(Equal("bar{}"))
+		})
+	})
+})
@@ -14,7 +14,10 @@ type UploadJob struct {
 	SampleRate      uint32
 	Units           string
 	AggregationType string
-	Trie            *transporttrie.Trie
+	// Resolution is the finest resolution the data should be stored at,
+	// it's equal to the upload rate. Zero means server default
+	Resolution time.Duration
+	Trie       *transporttrie.Trie
 }
 
 type Upstream interface {
@@ -22,3 +25,9 @@ type Upstream interface {
 	// TODO: too complex, fix it
 	Upload(u *UploadJob)
 }
+
+// Flusher is implemented by upstreams uploading jobs asynchronously.
+type Flusher interface {
+	// Flush blocks until all the jobs passed to Upload so far are processed.
+	Flush()
+}
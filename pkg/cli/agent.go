@@ -26,6 +26,11 @@ func newAgentService(logger *logrus.Logger, config *config.Agent) (*agentService
 		UpstreamThreads:        config.UpstreamThreads,
 		UpstreamAddress:        config.ServerAddress,
 		UpstreamRequestTimeout: config.UpstreamRequestTimeout,
+		UpstreamMaxRetries:     config.UpstreamMaxRetries,
+		UpstreamRetryBackoff:   config.UpstreamRetryBackoff,
+		UpstreamCompression:    config.UpstreamCompression,
+		SpoolPath:              config.SpoolPath,
+		SpoolMaxSize:           int64(config.SpoolMaxSize),
 		ManualStart:            true,
 	}
 	upstream, err := remote.New(rc, logger)
@@ -60,11 +65,17 @@ func loadTargets(c *config.Agent) error {
 	default:
 		return err
 	}
-	var a config.Agent
+	// other fields are not decoded on purpose: they are handled by ff and may
+	// use formats yaml package does not understand, e.g "100MB" sizes
+	var a struct {
+		Targets       []config.Target       `yaml:"targets"`
+		ScrapeTargets []config.ScrapeTarget `yaml:"scrape-targets"`
+	}
 	if err = yaml.Unmarshal(b, &a); err != nil {
 		return err
 	}
 	c.Targets = a.Targets
+	c.ScrapeTargets = a.ScrapeTargets
 	return nil
 }
 
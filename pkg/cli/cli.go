@@ -17,6 +17,8 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/convert"
 	"github.com/pyroscope-io/pyroscope/pkg/dbmanager"
 	"github.com/pyroscope-io/pyroscope/pkg/exec"
+	"github.com/pyroscope-io/pyroscope/pkg/query"
+	"github.com/pyroscope-io/pyroscope/pkg/server"
 )
 
 func generateRootCmd(cfg *config.Config) *ffcli.Command {
@@ -41,15 +43,17 @@ func generateRootCmd(cfg *config.Config) *ffcli.Command {
 		execFlagSet      = flag.NewFlagSet("pyroscope exec", flag.ExitOnError)
 		connectFlagSet   = flag.NewFlagSet("pyroscope connect", flag.ExitOnError)
 		dbmanagerFlagSet = flag.NewFlagSet("pyroscope dbmanager", flag.ExitOnError)
+		queryFlagSet     = flag.NewFlagSet("pyroscope query", flag.ExitOnError)
 		rootFlagSet      = flag.NewFlagSet("pyroscope", flag.ExitOnError)
 	)
 
 	serverSortedFlags := PopulateFlagSet(&cfg.Server, serverFlagSet)
-	agentSortedFlags := PopulateFlagSet(&cfg.Agent, agentFlagSet, WithSkip("targets"))
+	agentSortedFlags := PopulateFlagSet(&cfg.Agent, agentFlagSet, WithSkip("targets", "scrape-targets"))
 	convertSortedFlags := PopulateFlagSet(&cfg.Convert, convertFlagSet)
 	execSortedFlags := PopulateFlagSet(&cfg.Exec, execFlagSet, WithSkip("pid"))
 	connectSortedFlags := PopulateFlagSet(&cfg.Exec, connectFlagSet, WithSkip("group-name", "user-name", "no-root-drop"))
 	dbmanagerSortedFlags := PopulateFlagSet(&cfg.DbManager, dbmanagerFlagSet)
+	querySortedFlags := PopulateFlagSet(&cfg.Query, queryFlagSet)
 	rootSortedFlags := PopulateFlagSet(cfg, rootFlagSet)
 
 	options := []ff.Option{
@@ -63,7 +67,7 @@ func generateRootCmd(cfg *config.Config) *ffcli.Command {
 		UsageFunc:  serverSortedFlags.printUsage,
 		Options:    options,
 		Name:       "server",
-		ShortUsage: "pyroscope server [flags]",
+		ShortUsage: "pyroscope server [flags] [api-key list|create <name> <role>|revoke <name>]",
 		ShortHelp:  "starts pyroscope server. This is the database + web-based user interface",
 		FlagSet:    serverFlagSet,
 	}
@@ -113,7 +117,22 @@ func generateRootCmd(cfg *config.Config) *ffcli.Command {
 		FlagSet:    dbmanagerFlagSet,
 	}
 
+	queryCmd := &ffcli.Command{
+		UsageFunc:  querySortedFlags.printUsage,
+		Options:    options,
+		Name:       "query",
+		ShortUsage: "pyroscope query [flags] <query>",
+		ShortHelp:  "reads profiling data of an application from pyroscope server",
+		FlagSet:    queryFlagSet,
+	}
+
 	serverCmd.Exec = func(ctx context.Context, args []string) error {
+		if len(args) > 0 && args[0] == "api-key" {
+			return server.APIKeyCli(&cfg.Server, args[1:])
+		}
+		if err := loadRetentionRules(&cfg.Server); err != nil {
+			return fmt.Errorf("loading retention rules: %w", err)
+		}
 		return startServer(&cfg.Server)
 	}
 
@@ -174,6 +193,15 @@ func generateRootCmd(cfg *config.Config) *ffcli.Command {
 		return dbmanager.Cli(&cfg.DbManager, &cfg.Server, args)
 	}
 
+	queryCmd.Exec = func(ctx context.Context, args []string) error {
+		if len(args) > 0 && args[0] == "help" {
+			fmt.Println(gradientBanner())
+			fmt.Println(DefaultUsageFunc(querySortedFlags, queryCmd, []string{}))
+			return nil
+		}
+		return query.Cli(&cfg.Query, args)
+	}
+
 	rootCmd := &ffcli.Command{
 		UsageFunc:  rootSortedFlags.printUsage,
 		Options:    options,
@@ -186,6 +214,7 @@ func generateRootCmd(cfg *config.Config) *ffcli.Command {
 			execCmd,
 			connectCmd,
 			dbmanagerCmd,
+			queryCmd,
 		},
 	}
 
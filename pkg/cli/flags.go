@@ -2,7 +2,9 @@ package cli
 
 import (
 	"flag"
+	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -31,6 +33,35 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
+type mapFlags map[string]string
+
+func (m *mapFlags) String() string {
+	if len(*m) == 0 {
+		return "{}"
+	}
+	keys := make([]string, 0, len(*m))
+	for k := range *m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + (*m)[k]
+	}
+	return "{" + strings.Join(keys, ", ") + "}"
+}
+
+func (m *mapFlags) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 {
+		return fmt.Errorf("invalid value %q: expected key=value", value)
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[value[:i]] = value[i+1:]
+	return nil
+}
+
 type timeFlag time.Time
 
 func (tf *timeFlag) String() string {
@@ -162,6 +193,10 @@ func PopulateFlagSet(obj interface{}, flagSet *flag.FlagSet, opts ...FlagOption)
 			val := fieldV.Addr().Interface().(*[]string)
 			val2 := (*arrayFlags)(val)
 			flagSet.Var(val2, nameVal, descVal)
+		case reflect.TypeOf(map[string]string{}):
+			val := fieldV.Addr().Interface().(*map[string]string)
+			val2 := (*mapFlags)(val)
+			flagSet.Var(val2, nameVal, descVal)
 		case reflect.TypeOf(""):
 			val := fieldV.Addr().Interface().(*string)
 			for old, n := range o.replacements {
@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"flag"
+	"io/ioutil"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -23,6 +24,7 @@ type FlagsStruct struct {
 	FooBar   string
 	FooFoo   float64
 	FooBytes bytesize.ByteSize
+	FooTags  map[string]string
 }
 
 var _ = Describe("flags", func() {
@@ -49,6 +51,8 @@ var _ = Describe("flags", func() {
 					"-foo-bar", "test-val-4",
 					"-foo-foo", "10.23",
 					"-foo-bytes", "100MB",
+					"-foo-tags", "env=prod",
+					"-foo-tags", "query=a=b",
 				})
 
 				Expect(err).ToNot(HaveOccurred())
@@ -59,6 +63,16 @@ var _ = Describe("flags", func() {
 				Expect(cfg.FooBar).To(Equal("test-val-4"))
 				Expect(cfg.FooFoo).To(Equal(10.23))
 				Expect(cfg.FooBytes).To(Equal(100 * bytesize.MB))
+				Expect(cfg.FooTags).To(Equal(map[string]string{"env": "prod", "query": "a=b"}))
+			})
+
+			It("rejects malformed key=value arguments", func() {
+				exampleFlagSet := flag.NewFlagSet("example flag set", flag.ContinueOnError)
+				exampleFlagSet.SetOutput(ioutil.Discard)
+				cfg := FlagsStruct{}
+				PopulateFlagSet(&cfg, exampleFlagSet)
+				Expect(exampleFlagSet.Parse([]string{"-foo-tags", "=prod"})).To(HaveOccurred())
+				Expect(exampleFlagSet.Parse([]string{"-foo-tags", "env"})).To(HaveOccurred())
 			})
 		})
 
@@ -148,6 +162,11 @@ var _ = Describe("flags", func() {
 					AuthToken:              "",
 					UpstreamThreads:        4,
 					UpstreamRequestTimeout: 10 * time.Second,
+					UpstreamMaxRetries:     5,
+					UpstreamRetryBackoff:   time.Second,
+					UpstreamCompression:    "none",
+					UploadRate:             10 * time.Second,
+					SpoolMaxSize:           50 * bytesize.MB,
 				}))
 
 				Expect(loadTargets(&cfg)).ToNot(HaveOccurred())
@@ -160,6 +179,56 @@ var _ = Describe("flags", func() {
 						DetectSubprocesses: false,
 						PyspyBlocking:      false,
 						RbspyBlocking:      false,
+						Tags: map[string]string{
+							"env":  "prod",
+							"host": "$HOSTNAME",
+						},
+					},
+				}))
+				Expect(cfg.ScrapeTargets).To(Equal([]config.ScrapeTarget{
+					{
+						ApplicationName: "bar.app",
+						URL:             "http://localhost:6060",
+						ScrapeInterval:  15 * time.Second,
+						ProfileTypes:    []string{"cpu"},
+					},
+				}))
+			})
+
+			It("server configuration", func() {
+				exampleFlagSet := flag.NewFlagSet("example flag set", flag.ExitOnError)
+				var cfg config.Server
+				PopulateFlagSet(&cfg, exampleFlagSet)
+
+				exampleCommand := &ffcli.Command{
+					FlagSet: exampleFlagSet,
+					Options: []ff.Option{
+						ff.WithConfigFileParser(parser),
+						ff.WithConfigFileFlag("config"),
+					},
+					Exec: func(_ context.Context, args []string) error {
+						return nil
+					},
+				}
+
+				err := exampleCommand.ParseAndRun(context.Background(), []string{
+					"-config", "testdata/server.yml",
+				})
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.LogLevel).To(Equal("debug"))
+				Expect(cfg.Retention).To(Equal(30 * 24 * time.Hour))
+				Expect(cfg.RetentionLevel0).To(Equal(7 * 24 * time.Hour))
+
+				Expect(loadRetentionRules(&cfg)).ToNot(HaveOccurred())
+				Expect(cfg.RetentionRules).To(Equal([]config.RetentionRule{
+					{
+						ApplicationName: "debug.*",
+						Retention:       24 * time.Hour,
+					},
+					{
+						Selector:  `{env="prod"}`,
+						Retention: 90 * 24 * time.Hour,
 					},
 				}))
 			})
@@ -3,10 +3,13 @@ package cli
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
 
 	"github.com/pyroscope-io/pyroscope/pkg/agent"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/types"
@@ -130,3 +133,26 @@ func (svc *serverService) stop() {
 		svc.logger.WithError(err).Error("controller stop")
 	}
 }
+
+// loadRetentionRules reads retention rules from the server config file.
+// Similarly to agent targets, those can't be set with flags.
+func loadRetentionRules(c *config.Server) error {
+	b, err := ioutil.ReadFile(c.Config)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return nil
+	default:
+		return err
+	}
+	// other fields are not decoded on purpose: they are handled by ff and may
+	// use formats yaml package does not understand, e.g "30d" durations
+	var s struct {
+		RetentionRules []config.RetentionRule `yaml:"retention-rules"`
+	}
+	if err = yaml.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	c.RetentionRules = s.RetentionRules
+	return nil
+}
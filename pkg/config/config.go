@@ -1,9 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+	"github.com/pyroscope-io/pyroscope/pkg/util/duration"
 )
 
 type Config struct {
@@ -14,6 +16,7 @@ type Config struct {
 	Convert   Convert   `skip:"true"`
 	Exec      Exec      `skip:"true"`
 	DbManager DbManager `skip:"true"`
+	Query     Query     `skip:"true"`
 }
 
 type Agent struct {
@@ -27,24 +30,48 @@ type Agent struct {
 	AuthToken              string        `def:"" desc:"authorization token used to upload profiling data"`
 	UpstreamThreads        int           `def:"4" desc:"number of upload threads"`
 	UpstreamRequestTimeout time.Duration `def:"10s" desc:"profile upload timeout"`
+	UpstreamMaxRetries     int           `def:"5" desc:"number of times a failed profile upload is retried"`
+	UpstreamRetryBackoff   time.Duration `def:"1s" desc:"delay before the first profile upload retry, doubled after every attempt"`
+	UpstreamCompression    string        `def:"none" desc:"compression used for profile uploads: none|gzip|zstd"`
+	UploadRate             time.Duration `def:"10s" desc:"how often profiling data is uploaded. It's also the finest resolution the data is stored at, e.g 60s uploads make profiles of new applications take up to 6 times less space"`
 
-	Targets []Target `desc:"list of targets to be profiled"`
+	SpoolPath    string            `def:"" desc:"directory where profiles that failed to upload are stored until the server is reachable again. Disabled by default"`
+	SpoolMaxSize bytesize.ByteSize `def:"100MB" desc:"maximum amount of disk space used by the spool, the oldest profiles are dropped when exceeded"`
+
+	Targets       []Target       `desc:"list of targets to be profiled"`
+	ScrapeTargets []ScrapeTarget `name:"scrape-targets" desc:"list of pprof HTTP endpoints to be scraped"`
 }
 
 type Target struct {
-	ServiceName string `yaml:"service-name" desc:"name of the system service to be profiled"`
+	// Only one of the fields identifying processes to be profiled can be set.
+	ServiceName  string `yaml:"service-name" desc:"name of the system service to be profiled"`
+	PidFile      string `yaml:"pid-file" desc:"path to the file containing PID of the process to be profiled"`
+	ProcessName  string `yaml:"process-name" desc:"executable name of the processes to be profiled"`
+	CmdlineRegex string `yaml:"cmdline-regex" desc:"regular expression matching command lines of the processes to be profiled. Supported on linux only"`
 
 	SpyName            string `yaml:"spy-name" def:"" desc:"name of the profiler you want to use. Supported ones are: <supportedProfilers>"`
 	ApplicationName    string `yaml:"application-name" def:"" desc:"application name used when uploading profiling data"`
 	SampleRate         uint   `yaml:"sample-rate" def:"100" desc:"sample rate for the profiler in Hz. 100 means reading 100 times per second"`
 	DetectSubprocesses bool   `yaml:"detect-subprocesses" def:"true" desc:"makes pyroscope keep track of and profile subprocesses of the main process"`
 
+	Tags map[string]string `yaml:"tags" desc:"tags added to the application name, e.g {env: prod, host: $HOSTNAME}. Environment variables in values are expanded"`
+
 	// Spy-specific settings.
 
 	PyspyBlocking bool `yaml:"pyspy-blocking" def:"false" desc:"enables blocking mode for pyspy"`
 	RbspyBlocking bool `yaml:"rbspy-blocking" def:"false" desc:"enables blocking mode for rbspy"`
 }
 
+// ScrapeTarget is a go application exposing net/http/pprof endpoints.
+// Profiles are fetched from it periodically instead of being pushed.
+type ScrapeTarget struct {
+	ApplicationName string            `yaml:"application-name" desc:"application name used when uploading profiling data"`
+	URL             string            `yaml:"url" desc:"address of the application, e.g http://localhost:6060. Profiles are fetched from /debug/pprof/profile and /debug/pprof/heap"`
+	ScrapeInterval  time.Duration     `yaml:"scrape-interval" desc:"how often profiles are fetched, CPU profiles cover the whole interval. Must be a whole number of seconds, 10s by default"`
+	ProfileTypes    []string          `yaml:"profile-types" desc:"profiles to fetch: cpu|heap. All by default"`
+	Tags            map[string]string `yaml:"tags" desc:"tags added to the application name, e.g {env: prod}. Environment variables in values are expanded"`
+}
+
 type Server struct {
 	AnalyticsOptOut bool `def:"false" desc:"disables analytics"`
 
@@ -55,10 +82,16 @@ type Server struct {
 	StoragePath string `def:"<installPrefix>/var/lib/pyroscope" desc:"directory where pyroscope stores profiling data"`
 	APIBindAddr string `def:":4040" desc:"port for the HTTP server used for data ingestion and web UI"`
 	BaseURL     string `def:"" desc:"base URL for when the server is behind a reverse proxy with a different path"`
+	EnableAuth  bool   `def:"false" desc:"requires API keys for ingestion, reading profiling data and administration. Keys are managed with /api/keys, or with pyroscope server api-key command while the server is stopped"`
 
 	CacheEvictThreshold float64 `def:"0.25" desc:"percentage of memory at which cache evictions start"`
 	CacheEvictVolume    float64 `def:"0.33" desc:"percentage of cache that is evicted per eviction run"`
 
+	EnableWAL     bool   `name:"enable-wal" def:"false" desc:"enables write-ahead log, so that ingested data is not lost if pyroscope crashes before writing it to disk"`
+	WALSyncPolicy string `name:"wal-sync-policy" def:"interval" desc:"controls when the write-ahead log is fsynced: always|interval|never. interval means once a second"`
+
+	WALCheckpointInterval time.Duration `name:"wal-checkpoint-interval" def:"1m" desc:"how often data is saved to disk and the write-ahead log is emptied when it's enabled. Ingestion is paused while it happens"`
+
 	// TODO: I don't think a lot of people will change these values.
 	//   I think these should just be constants.
 	BadgerNoTruncate     bool `def:"false" desc:"indicates whether value log files should be truncated to delete corrupt data, if any"`
@@ -67,10 +100,17 @@ type Server struct {
 	MaxNodesSerialization int `def:"2048" desc:"max number of nodes used when saving profiles to disk"`
 	MaxNodesRender        int `def:"8192" desc:"max number of nodes used to display data on the frontend"`
 
+	MaxIngestBodySize bytesize.ByteSize `def:"64MB" desc:"max size of a decompressed /ingest request body. 0 means no limit"`
+
 	// currently only used in our demo app
 	HideApplications []string `def:"" desc:"please don't use, this will soon be deprecated"`
 
-	Retention time.Duration `def:"" desc:"sets the maximum amount of time the profiling data is stored for. Data before this threshold is deleted. Disabled by default"`
+	Retention       time.Duration     `def:"" desc:"sets the maximum amount of time the profiling data is stored for. Data before this threshold is deleted. Disabled by default"`
+	RetentionLevel0 time.Duration     `name:"retention-level-0" def:"" desc:"sets the maximum amount of time the finest resolution data (10s by default) is stored for, older data is kept at coarser resolution only. Disabled by default"`
+	RetentionLevel1 time.Duration     `name:"retention-level-1" def:"" desc:"sets the maximum amount of time 10 times coarser data (100s by default) is stored for. Disabled by default"`
+	RetentionLevel2 time.Duration     `name:"retention-level-2" def:"" desc:"sets the maximum amount of time 100 times coarser data (1000s by default) is stored for. Disabled by default"`
+	MaxStorageSize  bytesize.ByteSize `def:"" desc:"sets the maximum amount of disk space used for profiling data. When exceeded, the oldest data is deleted. Disabled by default"`
+	RetentionRules  []RetentionRule   `yaml:"retention-rules" desc:"list of per-application retention rules, the first matching rule overrides the global retention setting"`
 
 	// Deprecated fields. They can be set (for backwards compatibility) but have no effect
 	// TODO: we should print some warning messages when people try to use these
@@ -82,6 +122,35 @@ type Server struct {
 	CacheTreeSize       int               `deprecated:"true"`
 }
 
+// RetentionRule overrides the global retention setting for applications
+// matching both the name glob pattern and the label selector (if set).
+type RetentionRule struct {
+	ApplicationName string        `yaml:"application-name" desc:"application name glob pattern, e.g. debug.*"`
+	Selector        string        `yaml:"selector" desc:"label selector, e.g. {env=~\"dev|staging\"}"`
+	Retention       time.Duration `yaml:"retention" desc:"maximum amount of time the profiling data is stored for. 0 means forever"`
+}
+
+func (r *RetentionRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		ApplicationName string `yaml:"application-name"`
+		Selector        string `yaml:"selector"`
+		Retention       string `yaml:"retention"`
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	r.ApplicationName = raw.ApplicationName
+	r.Selector = raw.Selector
+	if raw.Retention != "" {
+		d, err := duration.ParseDuration(raw.Retention)
+		if err != nil {
+			return fmt.Errorf("retention rule %q: %w", raw.ApplicationName, err)
+		}
+		r.Retention = d
+	}
+	return nil
+}
+
 type Convert struct {
 	Format string `def:"tree"`
 }
@@ -94,9 +163,26 @@ type DbManager struct {
 	SrcStartTime    time.Time
 	ApplicationName string
 
+	From  time.Time `desc:"beginning of the time range exported, all data by default"`
+	Until time.Time `desc:"end of the time range exported, now by default"`
+
+	Repair bool `def:"false" desc:"makes fsck remove or rebuild the inconsistent entries it finds"`
+
 	EnableProfiling bool `def:"false" desc:"enables profiling of dbmanager"`
 }
 
+type Query struct {
+	ServerAddress  string        `def:"http://localhost:4040" desc:"address of the pyroscope server"`
+	AuthToken      string        `def:"" desc:"authorization token used to query profiling data"`
+	From           string        `def:"now-1h" desc:"beginning of the time range, e.g now-1h or a unix timestamp"`
+	Until          string        `def:"now" desc:"end of the time range, e.g now or a unix timestamp"`
+	Format         string        `def:"top" desc:"output format: top|json|collapsed|pprof. top prints a table of functions with the highest self and total values"`
+	Output         string        `def:"" desc:"file the result is written to. Standard output by default"`
+	Top            int           `def:"20" desc:"number of functions printed in top format"`
+	SortBy         string        `def:"self" desc:"column top format is sorted by: self|total"`
+	RequestTimeout time.Duration `def:"30s" desc:"query request timeout"`
+}
+
 type Exec struct {
 	SpyName                string        `def:"auto" desc:"name of the profiler you want to use. Supported ones are: <supportedProfilers>"`
 	ApplicationName        string        `def:"" desc:"application name used when uploading profiling data"`
@@ -107,6 +193,10 @@ type Exec struct {
 	AuthToken              string        `def:"" desc:"authorization token used to upload profiling data"`
 	UpstreamThreads        int           `def:"4" desc:"number of upload threads"`
 	UpstreamRequestTimeout time.Duration `def:"10s" desc:"profile upload timeout"`
+	UpstreamMaxRetries     int           `def:"5" desc:"number of times a failed profile upload is retried"`
+	UpstreamRetryBackoff   time.Duration `def:"1s" desc:"delay before the first profile upload retry, doubled after every attempt"`
+	UpstreamCompression    string        `def:"none" desc:"compression used for profile uploads: none|gzip|zstd"`
+	UploadRate             time.Duration `def:"10s" desc:"how often profiling data is uploaded. It's also the finest resolution the data is stored at, e.g 60s uploads make profiles of new applications take up to 6 times less space"`
 	NoLogging              bool          `def:"false" desc:"disables logging from pyroscope"`
 	NoRootDrop             bool          `def:"false" desc:"disables permissions drop when ran under root. use this one if you want to run your command as root"`
 	Pid                    int           `def:"0" desc:"PID of the process you want to profile. Pass -1 to profile the whole system (only supported by ebpfspy)"`
@@ -114,4 +204,6 @@ type Exec struct {
 	GroupName              string        `def:"" desc:"starts process under specified group name"`
 	PyspyBlocking          bool          `def:"false" desc:"enables blocking mode for pyspy"`
 	RbspyBlocking          bool          `def:"false" desc:"enables blocking mode for rbspy"`
+
+	Tags map[string]string `name:"tag" def:"" desc:"tag in key=value form added to the application name. The flag may be specified multiple times"`
 }
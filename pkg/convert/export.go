@@ -0,0 +1,125 @@
+package convert
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+type PprofMetadata struct {
+	// Units of tree values, e.g. "samples", "objects" or "bytes"
+	Units      string
+	SampleRate uint32
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+// sampleType returns pprof sample type and units for pyroscope units
+func (m *PprofMetadata) sampleType() (string, string) {
+	switch m.Units {
+	case "objects":
+		return "objects", "count"
+	case "bytes":
+		return "space", "bytes"
+	case "samples", "":
+		return "samples", "count"
+	case "goroutines":
+		return "goroutine", "count"
+	case "lock_samples":
+		return "contentions", "count"
+	case "lock_nanoseconds":
+		return "delay", "nanoseconds"
+	}
+	return m.Units, m.Units
+}
+
+type pprofBuilder struct {
+	profile   *Profile
+	strings   map[string]int64
+	functions map[string]uint64
+}
+
+func (b *pprofBuilder) string(s string) int64 {
+	i, ok := b.strings[s]
+	if !ok {
+		i = int64(len(b.profile.StringTable))
+		b.strings[s] = i
+		b.profile.StringTable = append(b.profile.StringTable, s)
+	}
+	return i
+}
+
+// location returns location id for the function name. Each function
+// gets exactly one location as line numbers are not stored in trees.
+func (b *pprofBuilder) location(name string) uint64 {
+	id, ok := b.functions[name]
+	if !ok {
+		id = uint64(len(b.profile.Function) + 1)
+		b.functions[name] = id
+		b.profile.Function = append(b.profile.Function, &Function{
+			Id:   id,
+			Name: b.string(name),
+		})
+		b.profile.Location = append(b.profile.Location, &Location{
+			Id:   id,
+			Line: []*Line{{FunctionId: id}},
+		})
+	}
+	return id
+}
+
+// TreeToPprof converts a tree into a pprof profile with a single sample type
+func TreeToPprof(t *tree.Tree, m PprofMetadata) *Profile {
+	b := &pprofBuilder{
+		profile:   &Profile{StringTable: []string{""}},
+		strings:   map[string]int64{"": 0},
+		functions: make(map[string]uint64),
+	}
+	p := b.profile
+
+	sampleType, unit := m.sampleType()
+	p.SampleType = []*ValueType{{Type: b.string(sampleType), Unit: b.string(unit)}}
+	if !m.StartTime.IsZero() {
+		p.TimeNanos = m.StartTime.UnixNano()
+		if m.EndTime.After(m.StartTime) {
+			p.DurationNanos = int64(m.EndTime.Sub(m.StartTime))
+		}
+	}
+	if sampleType == "samples" && m.SampleRate > 0 {
+		p.PeriodType = &ValueType{Type: b.string("cpu"), Unit: b.string("nanoseconds")}
+		p.Period = int64(time.Second) / int64(m.SampleRate)
+	}
+
+	t.Iterate(func(key []byte, val uint64) {
+		frames := bytes.Split(key, []byte(";"))
+		// pprof stacks start with the leaf frame
+		locations := make([]uint64, len(frames))
+		for i, f := range frames {
+			locations[len(frames)-1-i] = b.location(string(f))
+		}
+		p.Sample = append(p.Sample, &Sample{
+			LocationId: locations,
+			Value:      []int64{int64(val)},
+		})
+	})
+
+	return p
+}
+
+// WriteCompressed writes gzipped protobuf, the way pprof tools expect it
+func (profile *Profile) WriteCompressed(w io.Writer) error {
+	b, err := proto.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	g := gzip.NewWriter(w)
+	if _, err = g.Write(b); err != nil {
+		return err
+	}
+	return g.Close()
+}
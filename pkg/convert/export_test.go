@@ -0,0 +1,55 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+var _ = Describe("TreeToPprof", func() {
+	It("produces a profile that can be parsed back", func() {
+		t := tree.New()
+		t.Insert([]byte("foo;bar"), 2)
+		t.Insert([]byte("foo;baz"), 3)
+		t.Insert([]byte("foo"), 1)
+
+		st := time.Unix(1600000000, 0)
+		p := TreeToPprof(t, PprofMetadata{
+			Units:      "samples",
+			SampleRate: 100,
+			StartTime:  st,
+			EndTime:    st.Add(10 * time.Second),
+		})
+		Expect(p.Period).To(Equal(int64(10 * time.Millisecond)))
+		Expect(p.TimeNanos).To(Equal(st.UnixNano()))
+		Expect(p.DurationNanos).To(Equal(int64(10 * time.Second)))
+		Expect(p.Function).To(HaveLen(3))
+
+		var buf bytes.Buffer
+		Expect(p.WriteCompressed(&buf)).ToNot(HaveOccurred())
+		parsed, err := ParsePprof(&buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.SampleTypes()).To(Equal([]string{"samples"}))
+		Expect(parsed.SampleUnit("samples")).To(Equal("count"))
+
+		result := []string{}
+		parsed.Get("samples", func(name []byte, val int) {
+			result = append(result, fmt.Sprintf("%s %d", name, val))
+		})
+		Expect(result).To(ConsistOf("foo 1", "foo;bar 2", "foo;baz 3"))
+	})
+
+	It("maps bytes to space sample type", func() {
+		t := tree.New()
+		t.Insert([]byte("foo"), 1024)
+		p := TreeToPprof(t, PprofMetadata{Units: "bytes"})
+		Expect(p.StringTable[p.SampleType[0].Type]).To(Equal("space"))
+		Expect(p.StringTable[p.SampleType[0].Unit]).To(Equal("bytes"))
+		Expect(p.PeriodType).To(BeNil())
+	})
+})
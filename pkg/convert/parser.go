@@ -3,6 +3,7 @@ package convert
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"io"
 	"io/ioutil"
 	"strconv"
@@ -24,11 +25,21 @@ func ParseTrie(r io.Reader, cb func(name []byte, val int)) error {
 }
 
 // format is pprof. See https://github.com/google/pprof/blob/master/proto/profile.proto
+// Both plain and gzipped protobufs are accepted.
 func ParsePprof(r io.Reader) (*Profile, error) {
 	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
+	if isGzipped(b) {
+		g, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		if b, err = ioutil.ReadAll(g); err != nil {
+			return nil, err
+		}
+	}
 	profile := &Profile{}
 	if err := proto.Unmarshal(b, profile); err != nil {
 		return nil, err
@@ -36,6 +47,11 @@ func ParsePprof(r io.Reader) (*Profile, error) {
 	return profile, nil
 }
 
+// a valid protobuf message can't start with gzip magic bytes because 0x1f would mean a field with wire type 7, which doesn't exist
+func isGzipped(b []byte) bool {
+	return len(b) > 1 && b[0] == 0x1f && b[1] == 0x8b
+}
+
 // format:
 // stack-trace-foo 1
 // stack-trace-bar 2
@@ -37,7 +37,79 @@ var _ = Describe("convert", func() {
 			p.Get("samples", func(name []byte, val int) {
 				result = append(result, fmt.Sprintf("%s %d", name, val))
 			})
-			Expect(result).To(ContainElement("runtime.main;main.work 1"))
+			// main.main and main.slowFunction are inlined
+			Expect(result).To(ContainElement("runtime.main;main.main;main.slowFunction;main.work 1"))
+		})
+
+		It("decompresses gzipped data", func() {
+			b, err := ioutil.ReadFile("fixtures/cpu.pprof")
+			Expect(err).ToNot(HaveOccurred())
+			p, err := ParsePprof(bytes.NewReader(b))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.SampleTypes()).To(Equal([]string{"samples", "cpu"}))
+			Expect(p.SampleUnit("cpu")).To(Equal("nanoseconds"))
+			Expect(p.SampleUnit("foo")).To(BeEmpty())
+		})
+
+		It("reads sample labels", func() {
+			p := &Profile{
+				StringTable: []string{"", "samples", "count", "foo", "bar", "endpoint", "/checkout", "tenant", "requests"},
+				SampleType:  []*ValueType{{Type: 1, Unit: 2}},
+				Function:    []*Function{{Id: 1, Name: 3}, {Id: 2, Name: 4}},
+				Location:    []*Location{{Id: 1, Line: []*Line{{FunctionId: 1}}}, {Id: 2, Line: []*Line{{FunctionId: 2}}}},
+				Sample: []*Sample{
+					{LocationId: []uint64{2, 1}, Value: []int64{1}},
+					{LocationId: []uint64{2, 1}, Value: []int64{2}, Label: []*Label{{Key: 5, Str: 6}, {Key: 7, Num: 1, NumUnit: 8}}},
+				},
+			}
+			result := []string{}
+			p.GetWithLabels("samples", func(labels map[string]string, name []byte, val int) {
+				result = append(result, fmt.Sprintf("%v %s %d", labels, name, val))
+			})
+			Expect(result).To(ConsistOf("map[] foo;bar 1", "map[endpoint:/checkout] foo;bar 2"))
+		})
+
+		Context("frame formats", func() {
+			// main.handle is inlined into main.serve, main.serve is called by libc.so.6!start
+			p := &Profile{
+				StringTable: []string{"", "samples", "count", "main.serve", "main.handle", "start", "/src/main.go", "/usr/bin/app", "/lib/libc.so.6"},
+				SampleType:  []*ValueType{{Type: 1, Unit: 2}},
+				Mapping:     []*Mapping{{Id: 1, Filename: 7}, {Id: 2, Filename: 8}},
+				Function:    []*Function{{Id: 1, Name: 3, Filename: 6}, {Id: 2, Name: 4, Filename: 6}, {Id: 3, Name: 5}},
+				Location: []*Location{
+					{Id: 1, MappingId: 1, Line: []*Line{{FunctionId: 2, Line: 12}, {FunctionId: 1, Line: 20}}},
+					{Id: 2, MappingId: 2, Line: []*Line{{FunctionId: 3}}},
+				},
+				Sample: []*Sample{{LocationId: []uint64{1, 2}, Value: []int64{3}}},
+			}
+
+			get := func(ff FrameFormat) []string {
+				result := []string{}
+				err := p.GetWithFormat("samples", ff, func(_ map[string]string, name []byte, val int) {
+					result = append(result, fmt.Sprintf("%s %d", name, val))
+				})
+				Expect(err).ToNot(HaveOccurred())
+				return result
+			}
+
+			It("expands inlined functions", func() {
+				Expect(get(FrameFunction)).To(ConsistOf("start;main.serve;main.handle 3"))
+			})
+
+			It("adds file names and lines", func() {
+				Expect(get(FrameLine)).To(ConsistOf("start;main.serve /src/main.go:20;main.handle /src/main.go:12 3"))
+			})
+
+			It("adds module names", func() {
+				Expect(get(FrameModule)).To(ConsistOf("libc.so.6!start;app!main.serve;app!main.handle 3"))
+			})
+
+			It("rejects unknown formats", func() {
+				Expect(p.GetWithFormat("samples", "foo", func(map[string]string, []byte, int) {})).To(HaveOccurred())
+				_, err := ParseFrameFormat("foo")
+				Expect(err).To(HaveOccurred())
+				Expect(ParseFrameFormat("")).To(Equal(FrameFunction))
+			})
 		})
 	})
 
@@ -2,9 +2,80 @@ package convert
 
 // These functions are kept separately as profile.pb.go is a generated file
 
-import "strings"
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FrameFormat specifies how stack frames are named when pprof profiles are converted.
+type FrameFormat string
+
+const (
+	// FrameFunction names frames after functions, e.g "main.work".
+	FrameFunction FrameFormat = "function"
+	// FrameLine adds the source file and line number, e.g "main.work /src/app/main.go:42".
+	FrameLine FrameFormat = "line"
+	// FrameModule prefixes function names with the name of the binary
+	// or the shared library they belong to, e.g "libc.so.6!malloc".
+	FrameModule FrameFormat = "module"
+)
+
+// ParseFrameFormat returns FrameFunction for an empty string.
+func ParseFrameFormat(s string) (FrameFormat, error) {
+	switch ff := FrameFormat(s); ff {
+	case "":
+		return FrameFunction, nil
+	case FrameFunction, FrameLine, FrameModule:
+		return ff, nil
+	default:
+		return "", fmt.Errorf("unknown frame format %q", s)
+	}
+}
+
+// SampleTypes returns names of all sample types present in the profile,
+// in the order they appear in sample values.
+func (profile *Profile) SampleTypes() []string {
+	res := make([]string, 0, len(profile.SampleType))
+	for _, v := range profile.SampleType {
+		res = append(res, profile.StringTable[v.Type])
+	}
+	return res
+}
+
+// SampleUnit returns units of the given sample type, e.g. "count" or "bytes".
+// Empty string is returned if the profile has no such sample type.
+func (profile *Profile) SampleUnit(sampleType string) string {
+	for _, v := range profile.SampleType {
+		if profile.StringTable[v.Type] == sampleType {
+			return profile.StringTable[v.Unit]
+		}
+	}
+	return ""
+}
 
 func (profile *Profile) Get(sampleType string, cb func(name []byte, val int)) error {
+	return profile.GetWithLabels(sampleType, func(_ map[string]string, name []byte, val int) {
+		cb(name, val)
+	})
+}
+
+// GetWithLabels is like Get, but it also passes string labels of every sample to the callback,
+// e.g the ones set with pprof.Do in go programs. Labels are nil for samples without labels.
+func (profile *Profile) GetWithLabels(sampleType string, cb func(labels map[string]string, name []byte, val int)) error {
+	return profile.GetWithFormat(sampleType, FrameFunction, cb)
+}
+
+// GetWithFormat is like GetWithLabels, but frames are named according to the frame format.
+// Inlined functions are reported as separate frames.
+func (profile *Profile) GetWithFormat(sampleType string, ff FrameFormat, cb func(labels map[string]string, name []byte, val int)) error {
+	switch ff {
+	case FrameFunction, FrameLine, FrameModule:
+	default:
+		return fmt.Errorf("unknown frame format %q", ff)
+	}
+
 	valueIndex := 0
 	if sampleType != "" {
 		for i, v := range profile.SampleType {
@@ -15,26 +86,72 @@ func (profile *Profile) Get(sampleType string, cb func(name []byte, val int)) er
 		}
 	}
 
-	locations := make(map[uint64]*Location, len(profile.Location))
-	for _, l := range profile.Location {
-		locations[l.Id] = l
-	}
-
+	// frames of every location, from the innermost inlined function to the caller
+	frames := make(map[uint64][]string, len(profile.Location))
 	functions := make(map[uint64]*Function, len(profile.Function))
 	for _, f := range profile.Function {
 		functions[f.Id] = f
 	}
+	mappings := make(map[uint64]*Mapping, len(profile.Mapping))
+	for _, m := range profile.Mapping {
+		mappings[m.Id] = m
+	}
+	for _, l := range profile.Location {
+		lf := make([]string, 0, len(l.Line))
+		for _, line := range l.Line {
+			lf = append(lf, profile.frameName(ff, functions[line.FunctionId], line.Line, mappings[l.MappingId]))
+		}
+		frames[l.Id] = lf
+	}
 
+	var stack []string
 	for _, s := range profile.Sample {
-		stack := []string{}
+		// locations are ordered from the leaf to the root, the other way around in the result
+		stack = stack[:0]
 		for _, lID := range s.LocationId {
-			l := locations[lID]
-			fID := l.Line[0].FunctionId
-			f := functions[fID]
-			stack = append([]string{profile.StringTable[f.Name]}, stack...)
+			stack = append(stack, frames[lID]...)
+		}
+		for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+			stack[i], stack[j] = stack[j], stack[i]
 		}
 		name := strings.Join(stack, ";")
-		cb([]byte(name), int(s.Value[valueIndex]))
+		cb(profile.labels(s), []byte(name), int(s.Value[valueIndex]))
 	}
 	return nil
 }
+
+func (profile *Profile) frameName(ff FrameFormat, f *Function, line int64, m *Mapping) string {
+	if f == nil {
+		return ""
+	}
+	name := profile.StringTable[f.Name]
+	switch ff {
+	case FrameLine:
+		if filename := profile.StringTable[f.Filename]; filename != "" {
+			name += " " + filename
+			if line > 0 {
+				name += ":" + strconv.FormatInt(line, 10)
+			}
+		}
+	case FrameModule:
+		if m != nil && profile.StringTable[m.Filename] != "" {
+			name = filepath.Base(profile.StringTable[m.Filename]) + "!" + name
+		}
+	}
+	return name
+}
+
+func (profile *Profile) labels(s *Sample) map[string]string {
+	var labels map[string]string
+	for _, l := range s.Label {
+		// numeric labels are not supported
+		if l.Str == 0 {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string, len(s.Label))
+		}
+		labels[profile.StringTable[l.Key]] = profile.StringTable[l.Str]
+	}
+	return labels
+}
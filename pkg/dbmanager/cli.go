@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
@@ -15,6 +16,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream/direct"
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 )
 
 func Cli(dbCfg *config.DbManager, srvCfg *config.Server, args []string) error {
@@ -22,15 +24,45 @@ func Cli(dbCfg *config.DbManager, srvCfg *config.Server, args []string) error {
 		return fmt.Errorf("please provide a command")
 	}
 
+	// TODO: this is meh, I think config.Config should be separate from storage config
+	srvCfg.StoragePath = dbCfg.StoragePath
+	srvCfg.LogLevel = "error"
+
 	switch args[0] {
 	case "copy":
-		// TODO: this is meh, I think config.Config should be separate from storage config
-		srvCfg.StoragePath = dbCfg.StoragePath
-		srvCfg.LogLevel = "error"
 		err := copyData(dbCfg, srvCfg)
 		if err != nil {
 			return err
 		}
+	case "export":
+		if len(args) != 2 {
+			return fmt.Errorf("please provide the archive path: dbmanager export <file>")
+		}
+		return exportData(dbCfg, srvCfg, args[1])
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("please provide the archive path: dbmanager import <file>")
+		}
+		return importData(srvCfg, args[1])
+	case "fsck":
+		return checkData(dbCfg, srvCfg)
+	case "apps":
+		return withStorage(srvCfg, listApps)
+	case "inspect":
+		if len(args) != 2 {
+			return fmt.Errorf("please provide the application name: dbmanager inspect <app>")
+		}
+		return withStorage(srvCfg, func(s *storage.Storage) error { return inspectApp(s, args[1]) })
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("please provide the application name or a selector: dbmanager delete 'app.cpu{env=\"staging\"}'")
+		}
+		return withStorage(srvCfg, func(s *storage.Storage) error { return deleteSeries(s, args[1]) })
+	case "rename":
+		if len(args) != 3 {
+			return fmt.Errorf("please provide the application names: dbmanager rename <app> <new app>")
+		}
+		return withStorage(srvCfg, func(s *storage.Storage) error { return renameApp(s, args[1], args[2]) })
 	default:
 		return fmt.Errorf("unknown command %q", args[0])
 	}
@@ -38,6 +70,157 @@ func Cli(dbCfg *config.DbManager, srvCfg *config.Server, args []string) error {
 	return nil
 }
 
+// exportData writes profiling data of all the applications (or the one specified
+// with application name) in the time range to the archive at path
+func exportData(dbCfg *config.DbManager, srvCfg *config.Server, path string) error {
+	s, err := storage.New(srvCfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := s.Export(f, &storage.ExportInput{
+		StartTime: dbCfg.From,
+		EndTime:   dbCfg.Until,
+		AppName:   dbCfg.ApplicationName,
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d segments to %s\n", n, path)
+	return nil
+}
+
+// importData puts profiling data from the archive created by dbmanager export
+func importData(srvCfg *config.Server, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s, err := storage.New(srvCfg)
+	if err != nil {
+		return err
+	}
+	n, err := s.Import(f)
+	if err != nil {
+		s.Close()
+		return err
+	}
+	fmt.Printf("imported %d segments from %s\n", n, path)
+	return s.Close()
+}
+
+// checkData reports storage inconsistencies and repairs them if requested.
+// It fails if there are issues left, so that it can be used in scripts
+func checkData(dbCfg *config.DbManager, srvCfg *config.Server) error {
+	s, err := storage.New(srvCfg)
+	if err != nil {
+		return err
+	}
+	issues, err := s.Check(dbCfg.Repair)
+	var unrepaired int
+	for _, issue := range issues {
+		if issue.Repaired {
+			fmt.Printf("%s (repaired)\n", issue)
+		} else {
+			fmt.Println(issue)
+			unrepaired++
+		}
+	}
+	if err != nil {
+		s.Close()
+		return err
+	}
+	if err = s.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("found %d issues, %d repaired\n", len(issues), len(issues)-unrepaired)
+	if unrepaired > 0 {
+		return fmt.Errorf("%d issues left, run fsck with --repair to fix them", unrepaired)
+	}
+	return nil
+}
+
+func withStorage(srvCfg *config.Server, cb func(*storage.Storage) error) error {
+	s, err := storage.New(srvCfg)
+	if err != nil {
+		return err
+	}
+	if err = cb(s); err != nil {
+		s.Close()
+		return err
+	}
+	return s.Close()
+}
+
+func listApps(s *storage.Storage) error {
+	apps, err := s.Apps()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSPY\tUNITS\tSAMPLE RATE\tSERIES\tFIRST SEEN\tLAST SEEN\tDISK SIZE")
+	for _, app := range apps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			app.Name, app.SpyName, app.Units, app.SampleRate, len(app.Series),
+			app.FirstSeen.Format(time.RFC3339), app.LastSeen.Format(time.RFC3339),
+			bytesize.ByteSize(app.DiskSize))
+	}
+	return tw.Flush()
+}
+
+func inspectApp(s *storage.Storage, name string) error {
+	app, err := s.App(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("name:        %s\n", app.Name)
+	fmt.Printf("spy name:    %s\n", app.SpyName)
+	fmt.Printf("units:       %s\n", app.Units)
+	fmt.Printf("sample rate: %d\n", app.SampleRate)
+	fmt.Printf("first seen:  %s\n", app.FirstSeen.Format(time.RFC3339))
+	fmt.Printf("last seen:   %s\n", app.LastSeen.Format(time.RFC3339))
+	fmt.Printf("disk size:   %s\n", bytesize.ByteSize(app.DiskSize))
+	fmt.Printf("series:\n")
+	for _, series := range app.Series {
+		fmt.Printf("  %s\n", series)
+	}
+	return nil
+}
+
+// deleteSeries removes the application or the series matching the selector
+func deleteSeries(s *storage.Storage, selector string) error {
+	query, err := storage.ParseQuery(selector)
+	if err != nil {
+		return err
+	}
+	n, err := s.DeleteByQuery(query)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted %d series\n", n)
+	return nil
+}
+
+func renameApp(s *storage.Storage, oldName, newName string) error {
+	n, err := s.RenameApp(oldName, newName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("renamed %d series of %s to %s\n", n, oldName, newName)
+	return nil
+}
+
 // TODO: get this from config or something like that
 const resolution = 10 * time.Second
 
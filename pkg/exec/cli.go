@@ -99,6 +99,9 @@ func Cli(cfg *config.Exec, args []string) error {
 		UpstreamAddress:        cfg.ServerAddress,
 		UpstreamThreads:        cfg.UpstreamThreads,
 		UpstreamRequestTimeout: cfg.UpstreamRequestTimeout,
+		UpstreamMaxRetries:     cfg.UpstreamMaxRetries,
+		UpstreamRetryBackoff:   cfg.UpstreamRetryBackoff,
+		UpstreamCompression:    cfg.UpstreamCompression,
 	}
 	u, err := remote.New(rc, logrus.StandardLogger())
 	if err != nil {
@@ -150,10 +153,11 @@ func Cli(cfg *config.Exec, args []string) error {
 	sc := agent.SessionConfig{
 		Upstream:         u,
 		AppName:          cfg.ApplicationName,
+		Tags:             cfg.Tags,
 		ProfilingTypes:   []spy.ProfileType{spy.ProfileCPU},
 		SpyName:          spyName,
 		SampleRate:       uint32(cfg.SampleRate),
-		UploadRate:       10 * time.Second,
+		UploadRate:       cfg.UploadRate,
 		Pid:              pid,
 		WithSubprocesses: cfg.DetectSubprocesses,
 	}
@@ -0,0 +1,221 @@
+// Package query implements pyroscope query command reading profiling data from a pyroscope server.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+const (
+	formatTop       = "top"
+	formatJSON      = "json"
+	formatCollapsed = "collapsed"
+	formatPprof     = "pprof"
+
+	sortBySelf  = "self"
+	sortByTotal = "total"
+)
+
+var errQueryRequired = errors.New("query is required, e.g pyroscope query 'myapp.cpu{env=\"prod\"}'")
+
+// Cli fetches profiling data of the application selected by the query in args
+// from /render endpoint of the server and writes it in the requested format.
+func Cli(cfg *config.Query, args []string) error {
+	if len(args) != 1 {
+		return errQueryRequired
+	}
+	renderFormat := cfg.Format
+	switch cfg.Format {
+	case formatTop:
+		// pprof carries units and the sample rate, so that the table
+		// can show time and sizes rather than raw sample counts
+		renderFormat = formatPprof
+		if cfg.SortBy != sortBySelf && cfg.SortBy != sortByTotal {
+			return fmt.Errorf("unknown sort column %q", cfg.SortBy)
+		}
+	case formatJSON, formatCollapsed, formatPprof:
+	default:
+		return fmt.Errorf("unknown format %q", cfg.Format)
+	}
+
+	body, err := render(cfg, args[0], renderFormat)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	write := func(w io.Writer) error {
+		if cfg.Format == formatTop {
+			return writeTop(w, body, cfg.Top, cfg.SortBy)
+		}
+		_, err := io.Copy(w, body)
+		return err
+	}
+	if cfg.Output == "" {
+		return write(os.Stdout)
+	}
+
+	// the file is created only once the server responded successfully,
+	// so that failed queries don't leave empty files behind
+	f, err := os.Create(cfg.Output)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func render(cfg *config.Query, query, format string) (io.ReadCloser, error) {
+	u, err := url.Parse(strings.TrimSuffix(cfg.ServerAddress, "/") + "/render")
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address: %w", err)
+	}
+	q := u.Query()
+	q.Set("name", query)
+	q.Set("from", cfg.From)
+	q.Set("until", cfg.Until)
+	q.Set("format", format)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.AuthToken)
+	}
+	client := http.Client{Timeout: cfg.RequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("server responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+type function struct {
+	name  string
+	self  uint64
+	total uint64
+}
+
+// topFunctions aggregates stacks of the profile by function. Recursive
+// functions are counted once per stack in the total value.
+func topFunctions(p *convert.Profile, sampleType string) ([]*function, uint64, error) {
+	functions := make(map[string]*function)
+	get := func(name string) *function {
+		f, ok := functions[name]
+		if !ok {
+			f = &function{name: name}
+			functions[name] = f
+		}
+		return f
+	}
+
+	var total uint64
+	err := p.Get(sampleType, func(stack []byte, val int) {
+		v := uint64(val)
+		total += v
+		frames := strings.Split(string(stack), ";")
+		seen := make(map[string]struct{}, len(frames))
+		for _, name := range frames {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			get(name).total += v
+		}
+		get(frames[len(frames)-1]).self += v
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res := make([]*function, 0, len(functions))
+	for _, f := range functions {
+		res = append(res, f)
+	}
+	return res, total, nil
+}
+
+// valueFormatter returns a function formatting values of the sample type:
+// cpu samples are converted to time using the sampling period, while
+// nanoseconds and bytes are printed as durations and sizes.
+func valueFormatter(p *convert.Profile, sampleType string) func(uint64) string {
+	switch p.SampleUnit(sampleType) {
+	case "count":
+		if pt := p.PeriodType; pt != nil && p.Period > 0 && p.StringTable[pt.Unit] == "nanoseconds" {
+			period := p.Period
+			return func(v uint64) string { return time.Duration(int64(v) * period).String() }
+		}
+	case "nanoseconds":
+		return func(v uint64) string { return time.Duration(v).String() }
+	case "bytes":
+		return func(v uint64) string { return bytesize.ByteSize(v).String() }
+	}
+	return func(v uint64) string { return strconv.FormatUint(v, 10) }
+}
+
+func writeTop(w io.Writer, r io.Reader, n int, sortBy string) error {
+	p, err := convert.ParsePprof(r)
+	if err != nil {
+		return fmt.Errorf("unable to parse server response: %w", err)
+	}
+	sampleTypes := p.SampleTypes()
+	if len(sampleTypes) == 0 {
+		return errors.New("server responded with a profile without sample types")
+	}
+	functions, total, err := topFunctions(p, sampleTypes[0])
+	if err != nil {
+		return err
+	}
+	value := func(f *function) uint64 { return f.self }
+	if sortBy == sortByTotal {
+		value = func(f *function) uint64 { return f.total }
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		vi, vj := value(functions[i]), value(functions[j])
+		if vi != vj {
+			return vi > vj
+		}
+		return functions[i].name < functions[j].name
+	})
+	if n > 0 && len(functions) > n {
+		functions = functions[:n]
+	}
+
+	format := valueFormatter(p, sampleTypes[0])
+	percent := func(v uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(v) / float64(total) * 100
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "SELF\tSELF%\tTOTAL\tTOTAL%\t\tNAME")
+	for _, f := range functions {
+		fmt.Fprintf(tw, "%s\t%.2f%%\t%s\t%.2f%%\t\t%s\n", format(f.self), percent(f.self), format(f.total), percent(f.total), f.name)
+	}
+	return tw.Flush()
+}
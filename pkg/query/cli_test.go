@@ -0,0 +1,145 @@
+package query
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("query", func() {
+	var (
+		server  *httptest.Server
+		queries []url.Values
+		status  int
+		units   string
+		cfg     config.Query
+	)
+
+	BeforeEach(func() {
+		queries = nil
+		status = http.StatusOK
+		units = "samples"
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/render"))
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer secret"))
+			queries = append(queries, r.URL.Query())
+			w.WriteHeader(status)
+			if r.URL.Query().Get("format") != "pprof" {
+				w.Write([]byte("main;work;work 6\nmain;work 2\nmain;idle 2\n"))
+				return
+			}
+			t := tree.New()
+			t.Insert([]byte("main;work;work"), 6)
+			t.Insert([]byte("main;work"), 2)
+			t.Insert([]byte("main;idle"), 2)
+			p := convert.TreeToPprof(t, convert.PprofMetadata{Units: units, SampleRate: 100})
+			Expect(p.WriteCompressed(w)).To(Succeed())
+		}))
+		cfg = config.Query{
+			ServerAddress: server.URL + "/",
+			AuthToken:     "secret",
+			From:          "now-1h",
+			Until:         "now",
+			Format:        "top",
+			Top:           20,
+			SortBy:        "self",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	run := func(args ...string) (string, error) {
+		var out []byte
+		var err error
+		testing.TmpDir(func(dir string) {
+			cfg.Output = filepath.Join(dir, "out")
+			if err = Cli(&cfg, args); err != nil {
+				_, statErr := os.Stat(cfg.Output)
+				Expect(os.IsNotExist(statErr)).To(BeTrue())
+				return
+			}
+			out, err = ioutil.ReadFile(cfg.Output)
+		})
+		return string(out), err
+	}
+
+	It("prints top functions", func() {
+		out, err := run(`app.cpu{env="prod"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(queries).To(HaveLen(1))
+		Expect(queries[0].Get("name")).To(Equal(`app.cpu{env="prod"}`))
+		Expect(queries[0].Get("from")).To(Equal("now-1h"))
+		Expect(queries[0].Get("until")).To(Equal("now"))
+		Expect(queries[0].Get("format")).To(Equal("pprof"))
+
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		Expect(lines).To(HaveLen(4))
+		Expect(strings.Fields(lines[0])).To(Equal([]string{"SELF", "SELF%", "TOTAL", "TOTAL%", "NAME"}))
+		Expect(strings.Fields(lines[1])).To(Equal([]string{"80ms", "80.00%", "80ms", "80.00%", "work"}))
+		Expect(strings.Fields(lines[2])).To(Equal([]string{"20ms", "20.00%", "20ms", "20.00%", "idle"}))
+		Expect(strings.Fields(lines[3])).To(Equal([]string{"0s", "0.00%", "100ms", "100.00%", "main"}))
+	})
+
+	It("prints values in profile units", func() {
+		units = "bytes"
+		out, err := run("app.inuse_space")
+		Expect(err).ToNot(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		Expect(lines).To(HaveLen(4))
+		Expect(strings.Fields(lines[1])).To(Equal([]string{"8", "bytes", "80.00%", "8", "bytes", "80.00%", "work"}))
+
+		units = "objects"
+		out, err = run("app.inuse_objects")
+		Expect(err).ToNot(HaveOccurred())
+		lines = strings.Split(strings.TrimSpace(out), "\n")
+		Expect(strings.Fields(lines[3])).To(Equal([]string{"0", "0.00%", "10", "100.00%", "main"}))
+	})
+
+	It("limits and sorts the table", func() {
+		cfg.Top = 1
+		cfg.SortBy = "total"
+		out, err := run("app.cpu")
+		Expect(err).ToNot(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(strings.Fields(lines[1])).To(Equal([]string{"0s", "0.00%", "100ms", "100.00%", "main"}))
+	})
+
+	It("writes server responses in other formats", func() {
+		cfg.Format = "collapsed"
+		out, err := run("app.cpu")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(queries[0].Get("format")).To(Equal("collapsed"))
+		Expect(out).To(Equal("main;work;work 6\nmain;work 2\nmain;idle 2\n"))
+	})
+
+	It("returns errors", func() {
+		_, err := run()
+		Expect(err).To(Equal(errQueryRequired))
+
+		cfg.Format = "svg"
+		_, err = run("app.cpu")
+		Expect(err).To(MatchError(`unknown format "svg"`))
+		Expect(queries).To(BeEmpty())
+
+		cfg.Format = "collapsed"
+		status = http.StatusUnprocessableEntity
+		_, err = run("app.cpu{")
+		Expect(err).To(MatchError(ContainSubstring("server responded with 422")))
+	})
+})
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+type apiKeyJSON struct {
+	Name      string       `json:"name"`
+	Role      storage.Role `json:"role"`
+	CreatedAt int64        `json:"createdAt,omitempty"`
+	// only returned when the key is created
+	Token string `json:"token,omitempty"`
+}
+
+// apiKeysHandler handles /api/keys:
+//
+//	GET lists API keys, tokens are not returned
+//	POST creates an API key, {"name": "<name>", "role": "admin|readonly|ingest"}
+func (ctrl *Controller) apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := ctrl.storage.APIKeys()
+		if err != nil {
+			returnError(w, http.StatusInternalServerError, err, "failed to list api keys")
+			return
+		}
+		res := make([]*apiKeyJSON, 0, len(keys))
+		for _, k := range keys {
+			res = append(res, &apiKeyJSON{Name: k.Name, Role: k.Role, CreatedAt: k.CreatedAt.Unix()})
+		}
+		writeJSON(w, res)
+	case http.MethodPost:
+		var req apiKeyJSON
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			returnError(w, http.StatusBadRequest, err, "failed to decode api key request")
+			return
+		}
+		token, err := ctrl.storage.CreateAPIKey(req.Name, req.Role)
+		switch {
+		case errors.Is(err, storage.ErrAPIKeyExists):
+			returnError(w, http.StatusConflict, err, "failed to create api key")
+		case err != nil:
+			returnError(w, http.StatusUnprocessableEntity, err, "failed to create api key")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(&apiKeyJSON{Name: req.Name, Role: req.Role, Token: token})
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// apiKeyHandler handles DELETE /api/keys/<name> revoking the key
+func (ctrl *Controller) apiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	err := ctrl.storage.RevokeAPIKey(strings.TrimPrefix(r.URL.Path, "/api/keys/"))
+	switch {
+	case errors.Is(err, storage.ErrAPIKeyNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case err != nil:
+		returnError(w, http.StatusInternalServerError, err, "failed to revoke api key")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// APIKeyCli manages API keys of a server that is not running,
+// keys of a running server are managed with /api/keys. Only the main
+// database is opened, which badger locks while the server is running,
+// so the first admin key has to be created with the server stopped.
+func APIKeyCli(cfg *config.Server, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("please provide a command: api-key list|create <name> <role>|revoke <name>")
+	}
+	k, err := storage.OpenAPIKeyStore(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to open the database, make sure the server is stopped: %w", err)
+	}
+	if err = apiKeyCommand(k, args); err != nil {
+		k.Close()
+		return err
+	}
+	return k.Close()
+}
+
+func apiKeyCommand(s *storage.APIKeyStore, args []string) error {
+	switch args[0] {
+	case "list":
+		keys, err := s.APIKeys()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tROLE\tCREATED AT")
+		for _, k := range keys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", k.Name, k.Role, k.CreatedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	case "create":
+		if len(args) != 3 {
+			return fmt.Errorf("please provide the key name and role: api-key create <name> admin|readonly|ingest")
+		}
+		role, err := storage.ParseRole(args[2])
+		if err != nil {
+			return err
+		}
+		token, err := s.CreateAPIKey(args[1], role)
+		if err != nil {
+			return err
+		}
+		fmt.Println(token)
+		return nil
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("please provide the key name: api-key revoke <name>")
+		}
+		return s.RevokeAPIKey(args[1])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+type appJSON struct {
+	Name       string `json:"name"`
+	SpyName    string `json:"spyName"`
+	SampleRate uint32 `json:"sampleRate"`
+	Units      string `json:"units"`
+	// unix timestamps
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen  int64 `json:"lastSeen"`
+	// bytes
+	DiskSize uint64   `json:"diskSize"`
+	Series   []string `json:"series,omitempty"`
+}
+
+func newAppJSON(info *storage.AppInfo) *appJSON {
+	return &appJSON{
+		Name:       info.Name,
+		SpyName:    info.SpyName,
+		SampleRate: info.SampleRate,
+		Units:      info.Units,
+		FirstSeen:  info.FirstSeen.Unix(),
+		LastSeen:   info.LastSeen.Unix(),
+		DiskSize:   info.DiskSize,
+		Series:     info.Series,
+	}
+}
+
+type renameAppJSON struct {
+	Name string `json:"name"`
+}
+
+// appsHandler handles /api/apps:
+//
+//	GET lists applications
+//	DELETE removes the series matching the query parameter, e.g app.cpu{env="staging"}
+func (ctrl *Controller) appsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		apps, err := ctrl.storage.Apps()
+		if err != nil {
+			returnError(w, http.StatusInternalServerError, err, "failed to list applications")
+			return
+		}
+		res := make([]*appJSON, 0, len(apps))
+		for _, info := range apps {
+			app := newAppJSON(info)
+			app.Series = nil
+			res = append(res, app)
+		}
+		writeJSON(w, res)
+	case http.MethodDelete:
+		query, err := storage.ParseQuery(r.URL.Query().Get("query"))
+		if err != nil {
+			returnError(w, http.StatusUnprocessableEntity, err, "error happened while parsing query")
+			return
+		}
+		ctrl.deleteSeries(w, query)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// appHandler handles /api/apps/<name>:
+//
+//	GET returns the application details including its series
+//	DELETE removes the application
+//	PATCH renames the application, the new name is passed as {"name": "<name>"}
+func (ctrl *Controller) appHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/apps/")
+	if name == "" || strings.ContainsAny(name, "/{}") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		info, err := ctrl.storage.App(name)
+		if err != nil {
+			returnError(w, appErrorStatus(err), err, "failed to get application")
+			return
+		}
+		writeJSON(w, newAppJSON(info))
+	case http.MethodDelete:
+		ctrl.deleteSeries(w, &storage.Query{AppName: name})
+	case http.MethodPatch:
+		var req renameAppJSON
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			returnError(w, http.StatusBadRequest, err, "failed to decode rename request")
+			return
+		}
+		n, err := ctrl.storage.RenameApp(name, req.Name)
+		if err != nil {
+			returnError(w, appErrorStatus(err), err, "failed to rename application")
+			return
+		}
+		writeJSON(w, map[string]int{"renamed": n})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (ctrl *Controller) deleteSeries(w http.ResponseWriter, query *storage.Query) {
+	n, err := ctrl.storage.DeleteByQuery(query)
+	if err != nil {
+		returnError(w, http.StatusInternalServerError, err, "failed to delete series")
+		return
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]int{"deleted": n})
+}
+
+func appErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrAppNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrAppExists):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrInvalidAppName):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		returnError(w, http.StatusInternalServerError, err, "failed to marshal json")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
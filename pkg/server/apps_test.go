@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("server", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		Describe("/api/apps", func() {
+			It("manages applications", func() {
+				done := make(chan interface{})
+				go func() {
+					defer GinkgoRecover()
+
+					s, err := storage.New(&(*cfg).Server)
+					Expect(err).ToNot(HaveOccurred())
+					defer s.Close()
+					c, _ := New(&(*cfg).Server, s)
+					httpServer := httptest.NewServer(c.mux())
+					defer httpServer.Close()
+
+					st := testing.ParseTime("2020-01-01-01:01:00")
+					for _, k := range []string{"test.app{env=prod}", "test.app{env=dev}", "other.app{}"} {
+						key, _ := storage.ParseKey(k)
+						t := tree.New()
+						t.Insert([]byte("foo;bar"), 2)
+						t.Insert([]byte("foo;baz"), 5)
+						Expect(s.Put(&storage.PutInput{
+							StartTime:  st,
+							EndTime:    st.Add(10 * time.Second),
+							Key:        key,
+							Val:        t,
+							SpyName:    "gospy",
+							SampleRate: 100,
+							Units:      "samples",
+						})).ToNot(HaveOccurred())
+					}
+
+					request := func(method, path string, body string, v interface{}) int {
+						req, err := http.NewRequest(method, httpServer.URL+path, strings.NewReader(body))
+						Expect(err).ToNot(HaveOccurred())
+						res, err := http.DefaultClient.Do(req)
+						Expect(err).ToNot(HaveOccurred())
+						defer res.Body.Close()
+						if v != nil && res.StatusCode == 200 {
+							Expect(json.NewDecoder(res.Body).Decode(v)).ToNot(HaveOccurred())
+						}
+						return res.StatusCode
+					}
+
+					var apps []*appJSON
+					Expect(request("GET", "/api/apps", "", &apps)).To(Equal(200))
+					Expect(apps).To(HaveLen(2))
+					Expect(*apps[1]).To(Equal(appJSON{
+						Name:       "test.app",
+						SpyName:    "gospy",
+						SampleRate: 100,
+						Units:      "samples",
+						FirstSeen:  st.Unix(),
+						LastSeen:   st.Add(10 * time.Second).Unix(),
+						DiskSize:   apps[1].DiskSize,
+					}))
+
+					var app appJSON
+					Expect(request("GET", "/api/apps/test.app", "", &app)).To(Equal(200))
+					Expect(app.Series).To(ConsistOf("test.app{env=prod}", "test.app{env=dev}"))
+					Expect(request("GET", "/api/apps/unknown.app", "", nil)).To(Equal(404))
+
+					var deleted, renamed, deletedApp map[string]int
+					Expect(request("DELETE", "/api/apps?query="+url.QueryEscape(`test.app{env="dev"}`), "", &deleted)).To(Equal(200))
+					Expect(deleted).To(Equal(map[string]int{"deleted": 1}))
+
+					Expect(request("PATCH", "/api/apps/test.app", `{"name":"other.app"}`, nil)).To(Equal(409))
+					Expect(request("PATCH", "/api/apps/test.app", `{"name":"new.app"}`, &renamed)).To(Equal(200))
+					Expect(renamed).To(Equal(map[string]int{"renamed": 1}))
+					Expect(request("GET", "/api/apps/new.app", "", &app)).To(Equal(200))
+					Expect(app.Series).To(ConsistOf("new.app{env=prod}"))
+
+					Expect(request("DELETE", "/api/apps/new.app", "", &deletedApp)).To(Equal(200))
+					Expect(deletedApp).To(Equal(map[string]int{"deleted": 1}))
+					Expect(request("DELETE", "/api/apps/new.app", "", nil)).To(Equal(404))
+
+					close(done)
+				}()
+				Eventually(done, 2).Should(BeClosed())
+			})
+		})
+	})
+})
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+// authMiddleware rejects requests without an API key of a role allowing the access
+// when authentication is enabled. The token is passed as a bearer token, or as
+// the basic authentication password, so that the web UI can be used from a browser.
+func (ctrl *Controller) authMiddleware(role storage.Role) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if !ctrl.config.EnableAuth {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			token := tokenFromRequest(r)
+			if token == "" {
+				unauthorized(w)
+				return
+			}
+			key, err := ctrl.storage.APIKeyByToken(token)
+			switch {
+			case errors.Is(err, storage.ErrAPIKeyNotFound):
+				unauthorized(w)
+			case err != nil:
+				returnError(w, http.StatusInternalServerError, err, "failed to look up api key")
+			case !key.Role.Allows(role):
+				w.WriteHeader(http.StatusForbidden)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		}
+	}
+}
+
+func tokenFromRequest(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	const prefix = "Bearer "
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, prefix) {
+		return strings.TrimSpace(h[len(prefix):])
+	}
+	return ""
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="pyroscope"`)
+	w.WriteHeader(http.StatusUnauthorized)
+}
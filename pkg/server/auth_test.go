@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("server", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		Describe("authentication", func() {
+			It("requires api keys of the right role", func() {
+				done := make(chan interface{})
+				go func() {
+					defer GinkgoRecover()
+
+					(*cfg).Server.EnableAuth = true
+					s, err := storage.New(&(*cfg).Server)
+					Expect(err).ToNot(HaveOccurred())
+					defer s.Close()
+					c, _ := New(&(*cfg).Server, s)
+					httpServer := httptest.NewServer(c.mux())
+					defer httpServer.Close()
+
+					admin, err := s.CreateAPIKey("admin", storage.RoleAdmin)
+					Expect(err).ToNot(HaveOccurred())
+					ingest, err := s.CreateAPIKey("agents", storage.RoleIngest)
+					Expect(err).ToNot(HaveOccurred())
+
+					request := func(method, path, token, body string) *http.Response {
+						req, err := http.NewRequest(method, httpServer.URL+path, strings.NewReader(body))
+						Expect(err).ToNot(HaveOccurred())
+						if token != "" {
+							req.Header.Set("Authorization", "Bearer "+token)
+						}
+						res, err := http.DefaultClient.Do(req)
+						Expect(err).ToNot(HaveOccurred())
+						return res
+					}
+					status := func(method, path, token string) int {
+						res := request(method, path, token, "")
+						res.Body.Close()
+						return res.StatusCode
+					}
+
+					Expect(status("GET", "/healthz", "")).To(Equal(200))
+					res := request("GET", "/labels", "", "")
+					res.Body.Close()
+					Expect(res.StatusCode).To(Equal(401))
+					Expect(res.Header.Get("WWW-Authenticate")).To(Equal(`Basic realm="pyroscope"`))
+					Expect(status("GET", "/labels", "foo")).To(Equal(401))
+
+					Expect(status("POST", "/ingest?name=test.app&from=1600000000&until=1600000010", ingest)).To(Equal(200))
+					Expect(status("GET", "/labels", ingest)).To(Equal(403))
+					Expect(status("GET", "/labels", admin)).To(Equal(200))
+
+					res = request("POST", "/api/keys", admin, `{"name":"ui","role":"readonly"}`)
+					Expect(res.StatusCode).To(Equal(201))
+					var key apiKeyJSON
+					Expect(json.NewDecoder(res.Body).Decode(&key)).ToNot(HaveOccurred())
+					res.Body.Close()
+					Expect(key.Token).ToNot(BeEmpty())
+					Expect(status("POST", "/api/keys", key.Token)).To(Equal(403))
+
+					// the web UI sends the token as the basic authentication password
+					req, err := http.NewRequest("GET", httpServer.URL+"/label-values?label=__name__", nil)
+					Expect(err).ToNot(HaveOccurred())
+					req.SetBasicAuth("", key.Token)
+					res, err = http.DefaultClient.Do(req)
+					Expect(err).ToNot(HaveOccurred())
+					res.Body.Close()
+					Expect(res.StatusCode).To(Equal(200))
+
+					Expect(status("DELETE", "/api/keys/agents", admin)).To(Equal(204))
+					Expect(status("POST", "/ingest?name=test.app&from=1600000000&until=1600000010", ingest)).To(Equal(401))
+
+					close(done)
+				}()
+				Eventually(done, 2).Should(BeClosed())
+			})
+		})
+	})
+})
@@ -35,6 +35,9 @@ type Controller struct {
 	stats      map[string]int
 
 	appStats *hyperloglog.HyperLogLogPlus
+
+	// dir holds the webapp assets
+	dir http.FileSystem
 }
 
 func New(c *config.Server, s *storage.Storage) (*Controller, error) {
@@ -48,6 +51,7 @@ func New(c *config.Server, s *storage.Storage) (*Controller, error) {
 		storage:  s,
 		stats:    make(map[string]int),
 		appStats: appStats,
+		dir:      assetsDir(),
 	}
 
 	return &ctrl, nil
@@ -58,20 +62,29 @@ func (ctrl *Controller) mux() http.Handler {
 	addRoutes(mux, []route{
 		{"/healthz", ctrl.healthz},
 		{"/metrics", promhttp.Handler().ServeHTTP},
-		{"/config", ctrl.configHandler},
 		{"/build", ctrl.buildHandler},
 	})
+	addRoutes(mux, []route{
+		{"/config", ctrl.configHandler},
+	}, ctrl.authMiddleware(storage.RoleAdmin))
 
 	// drainable routes:
-	routes := []route{
+	addRoutes(mux, []route{
 		{"/", ctrl.indexHandler()},
-		{"/ingest", ctrl.ingestHandler},
 		{"/render", ctrl.renderHandler},
+		{"/render-diff", ctrl.renderDiffHandler},
 		{"/labels", ctrl.labelsHandler},
 		{"/label-values", ctrl.labelValuesHandler},
-	}
-
-	addRoutes(mux, routes, ctrl.drainMiddleware)
+	}, ctrl.drainMiddleware, ctrl.authMiddleware(storage.RoleReadOnly))
+	addRoutes(mux, []route{
+		{"/ingest", ctrl.ingestHandler},
+	}, ctrl.drainMiddleware, ctrl.authMiddleware(storage.RoleIngest))
+	addRoutes(mux, []route{
+		{"/api/apps", ctrl.appsHandler},
+		{"/api/apps/", ctrl.appHandler},
+		{"/api/keys", ctrl.apiKeysHandler},
+		{"/api/keys/", ctrl.apiKeyHandler},
+	}, ctrl.drainMiddleware, ctrl.authMiddleware(storage.RoleAdmin))
 
 	if !ctrl.config.DisablePprofEndpoint {
 		addRoutes(mux, []route{
@@ -80,7 +93,7 @@ func (ctrl *Controller) mux() http.Handler {
 			{"/debug/pprof/profile", pprof.Profile},
 			{"/debug/pprof/symbol", pprof.Symbol},
 			{"/debug/pprof/trace", pprof.Trace},
-		})
+		}, ctrl.authMiddleware(storage.RoleAdmin))
 	}
 	return mux
 }
@@ -145,22 +158,23 @@ type indexPage struct {
 	BaseURL       string
 }
 
-func (ctrl *Controller) indexHandler() http.HandlerFunc {
-	var dir http.FileSystem
+func assetsDir() http.FileSystem {
 	if build.UseEmbeddedAssets {
 		// for this to work you need to run `pkger` first. See Makefile for more information
-		dir = pkger.Dir("/webapp/public")
-	} else {
-		dir = http.Dir("./webapp/public")
+		return pkger.Dir("/webapp/public")
 	}
-	fs := http.FileServer(dir)
+	return http.Dir("./webapp/public")
+}
+
+func (ctrl *Controller) indexHandler() http.HandlerFunc {
+	fs := http.FileServer(ctrl.dir)
 	return func(rw http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
 			ctrl.statsInc("index")
-			ctrl.renderIndexPage(dir, rw, r)
+			ctrl.renderIndexPage(ctrl.dir, rw, r)
 		} else if r.URL.Path == "/comparison" {
 			ctrl.statsInc("index")
-			ctrl.renderIndexPage(dir, rw, r)
+			ctrl.renderIndexPage(ctrl.dir, rw, r)
 		} else {
 			fs.ServeHTTP(rw, r)
 		}
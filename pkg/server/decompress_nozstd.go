@@ -0,0 +1,15 @@
+// +build !cgo
+
+package server
+
+import (
+	"fmt"
+	"io"
+)
+
+// zstd library used here is a cgo wrapper, so servers built without cgo only accept gzip
+const zstdSupported = false
+
+func newZstdReader(_ io.Reader) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("%w: zstd requires cgo", errUnsupportedEncoding)
+}
@@ -0,0 +1,15 @@
+// +build cgo
+
+package server
+
+import (
+	"io"
+
+	"github.com/DataDog/zstd"
+)
+
+const zstdSupported = true
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	return zstd.NewReader(r), nil
+}
@@ -1,27 +1,38 @@
 package server
 
 import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/types"
 	"github.com/pyroscope-io/pyroscope/pkg/convert"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
 	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
+	"github.com/pyroscope-io/pyroscope/pkg/util/slices"
 	"github.com/sirupsen/logrus"
 )
 
 type ingestParams struct {
 	parserFunc      func(io.Reader) (*tree.Tree, error)
+	isPprof         bool
+	sampleType      string
+	frameFormat     convert.FrameFormat
+	hasSampleRate   bool
 	storageKey      *storage.Key
 	spyName         string
 	sampleRate      uint32
 	units           string
 	aggregationType string
 	modifiers       []string
+	resolution      time.Duration
 	from            time.Time
 	until           time.Time
 }
@@ -45,7 +56,12 @@ func ingestParamsFromRequest(r *http.Request) *ingestParams {
 
 	format := q.Get("format")
 
-	if format == "tree" || r.Header.Get("Content-Type") == "binary/octet-stream+tree" {
+	if format == "pprof" || r.Header.Get("Content-Type") == "application/x-protobuf" {
+		ip.isPprof = true
+		ip.sampleType = q.Get("sampleType")
+		// validated by ingestPprof, so that invalid values are rejected
+		ip.frameFormat = convert.FrameFormat(q.Get("frameFormat"))
+	} else if format == "tree" || r.Header.Get("Content-Type") == "binary/octet-stream+tree" {
 		ip.parserFunc = tree.DeserializeNoDict
 	} else if format == "trie" || r.Header.Get("Content-Type") == "binary/octet-stream+trie" {
 		ip.parserFunc = wrapConvertFunction(convert.ParseTrie)
@@ -74,11 +90,21 @@ func ingestParamsFromRequest(r *http.Request) *ingestParams {
 			ip.sampleRate = types.DefaultSampleRate
 		} else {
 			ip.sampleRate = uint32(sampleRate)
+			ip.hasSampleRate = true
 		}
 	} else {
 		ip.sampleRate = types.DefaultSampleRate
 	}
 
+	if r := q.Get("resolution"); r != "" {
+		resolution, err := strconv.Atoi(r)
+		if err != nil || resolution <= 0 {
+			logrus.WithField("err", err).Errorf("invalid resolution: %v", r)
+		} else {
+			ip.resolution = time.Duration(resolution) * time.Second
+		}
+	}
+
 	if sn := q.Get("spyName"); sn != "" {
 		// TODO: error handling
 		ip.spyName = sn
@@ -107,12 +133,104 @@ func ingestParamsFromRequest(r *http.Request) *ingestParams {
 	return ip
 }
 
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// ingestBody is a request body decompressed according to Content-Encoding header.
+// Reading more than maxSize decompressed bytes from it fails, which protects
+// the server from decompression bombs
+type ingestBody struct {
+	r        io.Reader
+	closer   io.Closer
+	maxSize  int64
+	read     int64
+	exceeded bool
+}
+
+func newIngestBody(r *http.Request, maxSize int64) (*ingestBody, error) {
+	b := &ingestBody{maxSize: maxSize}
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+		b.r = r.Body
+	case "gzip":
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		b.r, b.closer = gr, gr
+	case "zstd":
+		zr, err := newZstdReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		b.r, b.closer = zr, zr
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnsupportedEncoding, r.Header.Get("Content-Encoding"))
+	}
+	return b, nil
+}
+
+func (b *ingestBody) Read(p []byte) (int, error) {
+	if b.maxSize <= 0 {
+		return b.r.Read(p)
+	}
+	if b.exceeded {
+		return 0, b.tooLarge()
+	}
+	// one extra byte is allowed to tell a body of exactly maxSize bytes from a bigger one
+	if left := b.maxSize - b.read + 1; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.maxSize {
+		b.exceeded = true
+		return n - int(b.read-b.maxSize), b.tooLarge()
+	}
+	return n, err
+}
+
+func (b *ingestBody) tooLarge() error {
+	return fmt.Errorf("request body is larger than %d bytes", b.maxSize)
+}
+
+func (b *ingestBody) Close() error {
+	if b.closer != nil {
+		return b.closer.Close()
+	}
+	return nil
+}
+
+// parseError responds with 413 if the body was too big to be parsed, or with 422 otherwise
+func (b *ingestBody) parseError(w http.ResponseWriter, err error) {
+	if b.exceeded {
+		returnError(w, http.StatusRequestEntityTooLarge, err, "request body is too large")
+		return
+	}
+	returnError(w, 422, err, "error happened while parsing data")
+}
+
 func (ctrl *Controller) ingestHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := newIngestBody(r, int64(ctrl.config.MaxIngestBodySize))
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		returnError(w, http.StatusUnsupportedMediaType, err, "error happened while reading request body")
+		return
+	case err != nil:
+		returnError(w, 422, err, "error happened while reading request body")
+		return
+	}
+	defer body.Close()
+
 	ip := ingestParamsFromRequest(r)
+	if ip.isPprof {
+		ctrl.ingestPprof(w, body, ip)
+		return
+	}
+
 	var t *tree.Tree
-	t, err := ip.parserFunc(r.Body)
+	t, err = ip.parserFunc(body)
 	if err != nil {
-		returnError(w, 422, err, "error happened while parsing data")
+		body.parseError(w, err)
 		return
 	}
 
@@ -125,11 +243,135 @@ func (ctrl *Controller) ingestHandler(w http.ResponseWriter, r *http.Request) {
 		SampleRate:      ip.sampleRate,
 		Units:           ip.units,
 		AggregationType: ip.aggregationType,
+		Resolution:      ip.resolution,
 	})
 	if err != nil {
-		returnError(w, 503, err, "error happened while inserting data")
+		returnError(w, putErrorStatus(err), err, "error happened while inserting data")
 		return
 	}
+	ctrl.ingestStats(ip)
+}
+
+// ingestPprof handles pprof protobufs (either plain or gzipped). Each sample type
+// is stored as a separate application, e.g "app.cpu", "app.samples", unless
+// a single sample type is requested explicitly with sampleType parameter.
+func (ctrl *Controller) ingestPprof(w http.ResponseWriter, body *ingestBody, ip *ingestParams) {
+	frameFormat, err := convert.ParseFrameFormat(string(ip.frameFormat))
+	if err != nil {
+		returnError(w, 422, err, "error happened while parsing data")
+		return
+	}
+
+	// pprof profiles are usually gzipped on their own, the size limit applies to them as well
+	limited := body
+	br := bufio.NewReader(body)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			body.parseError(w, err)
+			return
+		}
+		limited = &ingestBody{r: gr, closer: gr, maxSize: body.maxSize}
+		defer limited.Close()
+		r = limited
+	}
+
+	profile, err := convert.ParsePprof(r)
+	if err != nil {
+		if body.exceeded {
+			limited = body
+		}
+		limited.parseError(w, err)
+		return
+	}
+
+	sampleTypes := profile.SampleTypes()
+	if ip.sampleType != "" {
+		if !slices.StringContains(sampleTypes, ip.sampleType) {
+			returnError(w, 422, fmt.Errorf("sample type %q not found", ip.sampleType), "error happened while parsing data")
+			return
+		}
+		sampleTypes = []string{ip.sampleType}
+	}
+
+	sampleRate := ip.sampleRate
+	// CPU time of profiles sampled periodically is stored as the number of samples,
+	// unless the sample rate would be below 1Hz, which can't be represented
+	var period int64
+	if profile.Period > 0 && profile.Period <= int64(time.Second) && profile.PeriodType != nil &&
+		profile.StringTable[profile.PeriodType.Unit] == "nanoseconds" {
+		period = profile.Period
+		if !ip.hasSampleRate {
+			sampleRate = uint32(time.Second / time.Duration(period))
+		}
+	}
+
+	for _, sampleType := range sampleTypes {
+		units := pprofUnits(sampleType, profile.SampleUnit(sampleType))
+		divider := 1
+		if units == "nanoseconds" && period > 0 && sampleType == profile.StringTable[profile.PeriodType.Type] {
+			units, divider = "samples", int(period)
+		}
+		t := tree.New()
+		profile.GetWithFormat(sampleType, frameFormat, func(_ map[string]string, name []byte, val int) {
+			if val = (val + divider/2) / divider; val > 0 {
+				t.Insert(name, uint64(val))
+			}
+		})
+
+		key := ip.storageKey
+		if len(sampleTypes) > 1 {
+			key = ip.storageKey.Clone()
+			key.Add("__name__", key.AppName()+"."+sampleType)
+		}
+
+		err = ctrl.storage.Put(&storage.PutInput{
+			StartTime:       ip.from,
+			EndTime:         ip.until,
+			Key:             key,
+			Val:             t,
+			SpyName:         ip.spyName,
+			SampleRate:      sampleRate,
+			Units:           units,
+			AggregationType: spy.ProfileType(sampleType).AggregationType(),
+			Resolution:      ip.resolution,
+		})
+		if err != nil {
+			returnError(w, putErrorStatus(err), err, "error happened while inserting data")
+			return
+		}
+	}
+	ctrl.ingestStats(ip)
+}
+
+// putErrorStatus responds with 422 to data the storage never accepts,
+// so that clients don't retry uploading it
+func putErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrRetention) || errors.Is(err, storage.ErrInvalidResolution) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusServiceUnavailable
+}
+
+// pprofUnits converts pprof sample units to the ones used across pyroscope
+func pprofUnits(sampleType, unit string) string {
+	switch {
+	case unit == "count" && sampleType == "samples":
+		return "samples"
+	case unit == "count" && sampleType == "contentions":
+		return "lock_samples"
+	case unit == "nanoseconds" && sampleType == "delay":
+		return "lock_nanoseconds"
+	case unit == "count":
+		return "objects"
+	case unit == "":
+		return "samples"
+	}
+	return unit
+}
+
+func (ctrl *Controller) ingestStats(ip *ingestParams) {
 	ctrl.statsInc("ingest")
 	ctrl.statsInc("ingest:" + ip.spyName)
 	k := *ip.storageKey
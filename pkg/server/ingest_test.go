@@ -2,20 +2,80 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"google.golang.org/protobuf/proto"
+
 	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/testing"
 )
 
+// pprofFixture returns a pprof profile with "foo;bar 2" and "foo;baz 3" stacks
+// and a value for each of the given sample types. All the functions are in main.go,
+// baz is inlined into foo. "cpu" values are in nanoseconds, sampled every 10ms
+func pprofFixture(sampleTypes ...string) []byte {
+	p := &convert.Profile{
+		StringTable: []string{"", "foo", "bar", "baz", "count", "bytes", "main.go"},
+		Function: []*convert.Function{
+			{Id: 1, Name: 1, Filename: 6},
+			{Id: 2, Name: 2, Filename: 6},
+			{Id: 3, Name: 3, Filename: 6},
+		},
+		Location: []*convert.Location{
+			{Id: 1, Line: []*convert.Line{{FunctionId: 1, Line: 1}}},
+			{Id: 2, Line: []*convert.Line{{FunctionId: 2, Line: 10}}},
+			{Id: 3, Line: []*convert.Line{{FunctionId: 3, Line: 20}}},
+			{Id: 4, Line: []*convert.Line{{FunctionId: 3, Line: 20}, {FunctionId: 1, Line: 2}}},
+		},
+	}
+	barValues := []int64{}
+	bazValues := []int64{}
+	for _, st := range sampleTypes {
+		p.StringTable = append(p.StringTable, st)
+		vt := &convert.ValueType{Type: int64(len(p.StringTable) - 1), Unit: 4}
+		multiplier := int64(1)
+		switch st {
+		case "alloc_space", "inuse_space":
+			vt.Unit = 5
+		case "cpu":
+			p.StringTable = append(p.StringTable, "nanoseconds")
+			vt.Unit = int64(len(p.StringTable) - 1)
+			p.PeriodType = vt
+			p.Period = 1e7
+			multiplier = p.Period
+		}
+		p.SampleType = append(p.SampleType, vt)
+		barValues = append(barValues, 2*multiplier)
+		bazValues = append(bazValues, 3*multiplier)
+	}
+	p.Sample = []*convert.Sample{
+		{LocationId: []uint64{2, 1}, Value: barValues},
+		{LocationId: []uint64{4}, Value: bazValues},
+	}
+	b, err := proto.Marshal(p)
+	Expect(err).ToNot(HaveOccurred())
+	return b
+}
+
+func gzipped(b []byte) []byte {
+	var buf bytes.Buffer
+	g := gzip.NewWriter(&buf)
+	g.Write(b)
+	g.Close()
+	return buf.Bytes()
+}
+
 var _ = Describe("server", func() {
 	testing.WithConfig(func(cfg **config.Config) {
 		BeforeEach(func() {
@@ -26,6 +86,11 @@ var _ = Describe("server", func() {
 			var buf *bytes.Buffer
 			var format string
 			var contentType string
+			var contentEncoding string
+
+			BeforeEach(func() {
+				contentEncoding = ""
+			})
 
 			// this is an example of Shared Example pattern
 			//   see https://onsi.github.io/ginkgo/#shared-example-patterns
@@ -64,6 +129,9 @@ var _ = Describe("server", func() {
 							contentType = "text/plain"
 						}
 						req.Header.Set("Content-Type", contentType)
+						if contentEncoding != "" {
+							req.Header.Set("Content-Encoding", contentEncoding)
+						}
 
 						res, err := http.DefaultClient.Do(req)
 						Expect(err).ToNot(HaveOccurred())
@@ -143,6 +211,250 @@ var _ = Describe("server", func() {
 
 				ItCorrectlyParsesIncomingData()
 			})
+
+			Context("pprof format", func() {
+				BeforeEach(func() {
+					buf = bytes.NewBuffer(pprofFixture("samples"))
+					format = "pprof"
+					contentType = ""
+				})
+
+				ItCorrectlyParsesIncomingData()
+			})
+
+			Context("gzipped pprof format", func() {
+				BeforeEach(func() {
+					buf = bytes.NewBuffer(gzipped(pprofFixture("samples")))
+					format = ""
+					contentType = "application/x-protobuf"
+				})
+
+				ItCorrectlyParsesIncomingData()
+			})
+
+			Context("gzip content encoding", func() {
+				BeforeEach(func() {
+					buf = bytes.NewBuffer(gzipped([]byte("\x00\x00\x01\x06foo;ba\x00\x02\x01r\x02\x00\x01z\x03\x00")))
+					format = ""
+					contentType = "binary/octet-stream+trie"
+					contentEncoding = "gzip"
+				})
+
+				ItCorrectlyParsesIncomingData()
+			})
+
+			if zstdSupported {
+				Context("zstd content encoding", func() {
+					BeforeEach(func() {
+						buf = bytes.NewBuffer(zstded([]byte("foo;bar 2\nfoo;baz 3\n")))
+						format = ""
+						contentType = ""
+						contentEncoding = "zstd"
+					})
+
+					ItCorrectlyParsesIncomingData()
+				})
+			}
+
+			Context("gzip content encoding with gzipped pprof", func() {
+				BeforeEach(func() {
+					buf = bytes.NewBuffer(gzipped(gzipped(pprofFixture("samples"))))
+					format = "pprof"
+					contentType = ""
+					contentEncoding = "gzip"
+				})
+
+				ItCorrectlyParsesIncomingData()
+			})
+
+			Context("request body limits", func() {
+				BeforeEach(func() {
+					(*cfg).Server.MaxIngestBodySize = 1024
+				})
+
+				It("rejects bodies that can't be decoded or are too large", func() {
+					done := make(chan interface{})
+					go func() {
+						defer GinkgoRecover()
+
+						s, err := storage.New(&(*cfg).Server)
+						Expect(err).ToNot(HaveOccurred())
+						c, _ := New(&(*cfg).Server, s)
+						httpServer := httptest.NewServer(c.mux())
+						defer s.Close()
+
+						post := func(body []byte, format, contentEncoding string) int {
+							req, err := http.NewRequest("POST", httpServer.URL+"/ingest?name=test.app&format="+format, bytes.NewReader(body))
+							Expect(err).ToNot(HaveOccurred())
+							req.Header.Set("Content-Encoding", contentEncoding)
+							res, err := http.DefaultClient.Do(req)
+							Expect(err).ToNot(HaveOccurred())
+							return res.StatusCode
+						}
+
+						small := []byte("foo;bar 2\nfoo;baz 3\n")
+						large := bytes.Repeat(small, 1000)
+						Expect(post(gzipped(small), "", "gzip")).To(Equal(200))
+						Expect(post(small, "", "br")).To(Equal(415))
+						Expect(post(small, "", "gzip")).To(Equal(422))
+						Expect(post(gzipped(large), "", "gzip")).To(Equal(413))
+						if zstdSupported {
+							Expect(post(zstded(large), "", "zstd")).To(Equal(413))
+						} else {
+							Expect(post(zstded(small), "", "zstd")).To(Equal(415))
+						}
+						Expect(post(large, "", "")).To(Equal(413))
+
+						By("limiting the size of gzipped pprof profiles")
+						Expect(len(gzipped(pprofFixture("samples")))).To(BeNumerically("<", 1024))
+						Expect(post(gzipped(pprofFixture("samples")), "pprof", "")).To(Equal(200))
+						sampleTypes := make([]string, 100)
+						for i := range sampleTypes {
+							sampleTypes[i] = fmt.Sprintf("type_%d", i)
+						}
+						p := pprofFixture(sampleTypes...)
+						Expect(len(p)).To(BeNumerically(">", 1024))
+						Expect(len(gzipped(p))).To(BeNumerically("<", 1024))
+						Expect(post(gzipped(p), "pprof", "")).To(Equal(413))
+
+						close(done)
+					}()
+					Eventually(done, 2).Should(BeClosed())
+				})
+			})
+
+			Context("data outside of the retention period", func() {
+				BeforeEach(func() {
+					(*cfg).Server.Retention = time.Hour
+				})
+
+				It("is rejected with a client error, so that it's not retried", func() {
+					done := make(chan interface{})
+					go func() {
+						defer GinkgoRecover()
+
+						s, err := storage.New(&(*cfg).Server)
+						Expect(err).ToNot(HaveOccurred())
+						c, _ := New(&(*cfg).Server, s)
+						httpServer := httptest.NewServer(c.mux())
+						defer s.Close()
+
+						res, err := http.Post(httpServer.URL+"/ingest?name=test.app&from=1600000000&until=1600000010", "", bytes.NewBufferString("foo;bar 2\n"))
+						Expect(err).ToNot(HaveOccurred())
+						res.Body.Close()
+						Expect(res.StatusCode).To(Equal(422))
+
+						close(done)
+					}()
+					Eventually(done, 2).Should(BeClosed())
+				})
+			})
+
+			Context("pprof format with multiple sample types", func() {
+				It("stores each sample type as a separate application", func() {
+					done := make(chan interface{})
+					go func() {
+						defer GinkgoRecover()
+
+						s, err := storage.New(&(*cfg).Server)
+						Expect(err).ToNot(HaveOccurred())
+						c, _ := New(&(*cfg).Server, s)
+						httpServer := httptest.NewServer(c.mux())
+						defer s.Close()
+
+						st := testing.ParseTime("2020-01-01-01:01:00")
+						et := testing.ParseTime("2020-01-01-01:01:10")
+
+						u, _ := url.Parse(httpServer.URL + "/ingest")
+						q := u.Query()
+						q.Add("name", "test.app{foo=bar}")
+						q.Add("from", strconv.Itoa(int(st.Unix())))
+						q.Add("until", strconv.Itoa(int(et.Unix())))
+						q.Add("format", "pprof")
+						u.RawQuery = q.Encode()
+
+						body := bytes.NewBuffer(pprofFixture("alloc_objects", "inuse_space"))
+						res, err := http.Post(u.String(), "", body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(res.StatusCode).To(Equal(200))
+
+						sk, _ := storage.ParseKey("test.app.alloc_objects{foo=bar}")
+						gOut, err := s.Get(&storage.GetInput{StartTime: st, EndTime: et, Key: sk})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(gOut.Tree.String()).To(Equal("\"foo;bar\" 2\n\"foo;baz\" 3\n"))
+						Expect(gOut.Units).To(Equal("objects"))
+
+						sk, _ = storage.ParseKey("test.app.inuse_space{foo=bar}")
+						gOut, err = s.Get(&storage.GetInput{StartTime: st, EndTime: et, Key: sk})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(gOut.Tree).ToNot(BeNil())
+						Expect(gOut.Units).To(Equal("bytes"))
+
+						By("naming frames according to the frame format")
+						q.Set("frameFormat", "line")
+						u.RawQuery = q.Encode()
+						body = bytes.NewBuffer(pprofFixture("alloc_objects", "inuse_space"))
+						res, err = http.Post(u.String(), "", body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(res.StatusCode).To(Equal(200))
+						sk, _ = storage.ParseKey("test.app.alloc_objects{foo=bar}")
+						gOut, err = s.Get(&storage.GetInput{StartTime: st, EndTime: et, Key: sk})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(gOut.Tree.String()).To(ContainSubstring("\"foo main.go:1;bar main.go:10\" 2\n"))
+						Expect(gOut.Tree.String()).To(ContainSubstring("\"foo main.go:2;baz main.go:20\" 3\n"))
+
+						q.Set("frameFormat", "unknown")
+						u.RawQuery = q.Encode()
+						body = bytes.NewBuffer(pprofFixture("alloc_objects", "inuse_space"))
+						res, err = http.Post(u.String(), "", body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(res.StatusCode).To(Equal(422))
+
+						q.Del("frameFormat")
+						q.Set("sampleType", "missing")
+						u.RawQuery = q.Encode()
+						body = bytes.NewBuffer(pprofFixture("alloc_objects", "inuse_space"))
+						res, err = http.Post(u.String(), "", body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(res.StatusCode).To(Equal(422))
+
+						By("storing cpu time as the number of samples")
+						q.Del("sampleType")
+						u.RawQuery = q.Encode()
+						body = bytes.NewBuffer(pprofFixture("samples", "cpu"))
+						res, err = http.Post(u.String(), "", body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(res.StatusCode).To(Equal(200))
+						sk, _ = storage.ParseKey("test.app.cpu{foo=bar}")
+						gOut, err = s.Get(&storage.GetInput{StartTime: st, EndTime: et, Key: sk})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(gOut.Tree.String()).To(Equal("\"foo;bar\" 2\n\"foo;baz\" 3\n"))
+						Expect(gOut.Units).To(Equal("samples"))
+						Expect(gOut.SampleRate).To(Equal(uint32(100)))
+
+						By("storing cpu time in nanoseconds if sampled less often than once a second")
+						p, err := convert.ParsePprof(bytes.NewReader(pprofFixture("cpu")))
+						Expect(err).ToNot(HaveOccurred())
+						p.Period = 2e9
+						b, err := proto.Marshal(p)
+						Expect(err).ToNot(HaveOccurred())
+						q.Set("name", "test.slow{foo=bar}")
+						u.RawQuery = q.Encode()
+						res, err = http.Post(u.String(), "", bytes.NewBuffer(b))
+						Expect(err).ToNot(HaveOccurred())
+						Expect(res.StatusCode).To(Equal(200))
+						sk, _ = storage.ParseKey("test.slow{foo=bar}")
+						gOut, err = s.Get(&storage.GetInput{StartTime: st, EndTime: et, Key: sk})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(gOut.Tree.String()).To(Equal("\"foo;bar\" 20000000\n\"foo;baz\" 30000000\n"))
+						Expect(gOut.Units).To(Equal("nanoseconds"))
+						Expect(gOut.SampleRate).To(Equal(uint32(100)))
+
+						close(done)
+					}()
+					Eventually(done, 2).Should(BeClosed())
+				})
+			})
 		})
 	})
 })
@@ -0,0 +1,15 @@
+// +build cgo
+
+package server
+
+import (
+	"github.com/DataDog/zstd"
+
+	. "github.com/onsi/gomega"
+)
+
+func zstded(b []byte) []byte {
+	c, err := zstd.Compress(nil, b)
+	Expect(err).ToNot(HaveOccurred())
+	return c
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 )
 
 func (ctrl *Controller) labelsHandler(w http.ResponseWriter, _ *http.Request) {
@@ -18,13 +20,25 @@ func (ctrl *Controller) labelsHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Write(b)
 }
 
+// labelValuesHandler returns all values of the label. If query parameter
+// is present, only values of the series matching the selector are returned.
 func (ctrl *Controller) labelValuesHandler(w http.ResponseWriter, r *http.Request) {
 	res := []string{}
 	labelName := r.URL.Query().Get("label")
-	ctrl.storage.GetValues(labelName, func(v string) bool {
+	cb := func(v string) bool {
 		res = append(res, v)
 		return true
-	})
+	}
+	if qs := r.URL.Query().Get("query"); qs != "" {
+		query, err := storage.ParseQuery(qs)
+		if err != nil {
+			returnError(w, 422, err, "error happened while parsing query")
+			return
+		}
+		ctrl.storage.GetValuesByQuery(labelName, query, cb)
+	} else {
+		ctrl.storage.GetValues(labelName, cb)
+	}
 	b, err := json.Marshal(res)
 	if err != nil {
 		panic(err) // TODO: handle
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
 	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
+	"github.com/sirupsen/logrus"
 )
 
 type samplesEntry struct {
@@ -21,15 +26,16 @@ func (ctrl *Controller) renderHandler(w http.ResponseWriter, r *http.Request) {
 	startTime := attime.Parse(q.Get("from"))
 	endTime := attime.Parse(q.Get("until"))
 	var err error
-	storageKey, err := storage.ParseKey(q.Get("name"))
+	query, err := storage.ParseQuery(q.Get("name"))
 	if err != nil {
-		panic(err) // TODO: handle
+		returnError(w, 422, err, "error happened while parsing query")
+		return
 	}
 
 	gOut, err := ctrl.storage.Get(&storage.GetInput{
 		StartTime: startTime,
 		EndTime:   endTime,
-		Key:       storageKey,
+		Query:     query,
 	})
 	ctrl.statsInc("render")
 	if err != nil {
@@ -43,20 +49,13 @@ func (ctrl *Controller) renderHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	maxNodes := ctrl.config.MaxNodesRender
-	if mn, err := strconv.Atoi(q.Get("max-nodes")); err == nil && mn > 0 {
-		maxNodes = mn
-	}
+	maxNodes := ctrl.maxNodes(q)
 
 	switch q.Get("format") {
 	case "json":
 		w.Header().Set("Content-Type", "application/json")
 
-		fs := gOut.Tree.FlamebearerStruct(maxNodes)
-		// TODO remove this duplication? We're already adding this to metadata
-		fs.SpyName = gOut.SpyName
-		fs.SampleRate = gOut.SampleRate
-		fs.Units = gOut.Units
+		fs := flamebearer(gOut, maxNodes)
 		res := map[string]interface{}{
 			"timeline":    gOut.Timeline,
 			"flamebearer": fs,
@@ -70,8 +69,68 @@ func (ctrl *Controller) renderHandler(w http.ResponseWriter, r *http.Request) {
 		encoder := json.NewEncoder(w)
 		encoder.Encode(res)
 		return
+	case "pprof":
+		p := convert.TreeToPprof(gOut.Tree, convert.PprofMetadata{
+			Units:      gOut.Units,
+			SampleRate: gOut.SampleRate,
+			StartTime:  startTime,
+			EndTime:    endTime,
+		})
+		w.Header().Set("Content-Type", "application/octet-stream")
+		setAttachment(w, query.AppName, "pb.gz")
+		if err = p.WriteCompressed(w); err != nil {
+			logrus.WithField("err", err).Error("failed to write pprof profile")
+		}
+		return
+	case "collapsed":
+		w.Header().Set("Content-Type", "text/plain")
+		setAttachment(w, query.AppName, "txt")
+		if err = gOut.Tree.WriteCollapsed(w); err != nil {
+			logrus.WithField("err", err).Error("failed to write collapsed profile")
+		}
+		return
+	case "speedscope":
+		w.Header().Set("Content-Type", "application/json")
+		setAttachment(w, query.AppName, "speedscope.json")
+		encoder := json.NewEncoder(w)
+		encoder.Encode(gOut.Tree.Speedscope(q.Get("name"), gOut.Units, gOut.SampleRate))
+		return
+	case "html":
+		fs := flamebearer(gOut, maxNodes)
+		var buf bytes.Buffer
+		err = renderStandalonePage(&buf, ctrl.dir, query.AppName, q.Get("name"), startTime, endTime, fs)
+		if err != nil {
+			returnError(w, http.StatusInternalServerError, err, "failed to render standalone page")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(buf.Bytes())
+		return
 	default:
 		// TODO: add handling for other cases
 		w.WriteHeader(422)
 	}
 }
+
+func flamebearer(gOut *storage.GetOutput, maxNodes int) *tree.Flamebearer {
+	fs := gOut.Tree.FlamebearerStruct(maxNodes)
+	// TODO remove this duplication? We're already adding this to metadata
+	fs.SpyName = gOut.SpyName
+	fs.SampleRate = gOut.SampleRate
+	fs.Units = gOut.Units
+	return fs
+}
+
+func setAttachment(w http.ResponseWriter, appName, ext string) {
+	if appName == "" {
+		appName = "profile"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", appName+"."+ext))
+}
+
+func (ctrl *Controller) maxNodes(q url.Values) int {
+	if mn, err := strconv.Atoi(q.Get("max-nodes")); err == nil && mn > 0 {
+		return mn
+	}
+	return ctrl.config.MaxNodesRender
+}
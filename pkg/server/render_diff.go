@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
+)
+
+// renderDiffHandler renders a diff flamegraph between two queries. Each side is
+// described by leftQuery / leftFrom / leftUntil and rightQuery / rightFrom / rightUntil
+// parameters, missing ones default to name / from / until. This allows comparing
+// both two time ranges of the same application and two different label sets.
+func (ctrl *Controller) renderDiffHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	leftOut, err := ctrl.loadSide(q, "left")
+	if err != nil {
+		returnError(w, 422, err, "error happened while loading left tree")
+		return
+	}
+	rightOut, err := ctrl.loadSide(q, "right")
+	if err != nil {
+		returnError(w, 422, err, "error happened while loading right tree")
+		return
+	}
+	ctrl.statsInc("render-diff")
+
+	fs := tree.CombineToFlamebearerStruct(leftOut.Tree, rightOut.Tree, ctrl.maxNodes(q))
+	fs.SpyName = rightOut.SpyName
+	fs.SampleRate = rightOut.SampleRate
+	fs.Units = rightOut.Units
+	res := map[string]interface{}{
+		"leftTimeline":  leftOut.Timeline,
+		"rightTimeline": rightOut.Timeline,
+		"flamebearer":   fs,
+		"metadata": map[string]interface{}{
+			"spyName":    rightOut.SpyName,
+			"sampleRate": rightOut.SampleRate,
+			"units":      rightOut.Units,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.Encode(res)
+}
+
+func (ctrl *Controller) loadSide(q url.Values, side string) (*storage.GetOutput, error) {
+	query, err := storage.ParseQuery(paramWithFallback(q, side+"Query", "name"))
+	if err != nil {
+		return nil, err
+	}
+	gi := &storage.GetInput{
+		StartTime: attime.Parse(paramWithFallback(q, side+"From", "from")),
+		EndTime:   attime.Parse(paramWithFallback(q, side+"Until", "until")),
+		Query:     query,
+	}
+	gOut, err := ctrl.storage.Get(gi)
+	if err != nil {
+		return nil, err
+	}
+	if gOut == nil {
+		gOut = &storage.GetOutput{
+			Tree: tree.New(),
+		}
+	}
+	return gOut, nil
+}
+
+func paramWithFallback(q url.Values, name, fallback string) string {
+	if v := q.Get(name); v != "" {
+		return v
+	}
+	return q.Get(fallback)
+}
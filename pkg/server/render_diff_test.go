@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("server", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		Describe("/render-diff", func() {
+			It("combines two time ranges into one flamegraph", func() {
+				done := make(chan interface{})
+				go func() {
+					defer GinkgoRecover()
+
+					s, err := storage.New(&(*cfg).Server)
+					Expect(err).ToNot(HaveOccurred())
+					defer s.Close()
+					c, _ := New(&(*cfg).Server, s)
+					httpServer := httptest.NewServer(c.mux())
+					defer httpServer.Close()
+
+					st1 := testing.ParseTime("2020-01-01-01:01:00")
+					et1 := testing.ParseTime("2020-01-01-01:01:10")
+					st2 := testing.ParseTime("2020-01-01-01:02:00")
+					et2 := testing.ParseTime("2020-01-01-01:02:10")
+					key, _ := storage.ParseKey("test.app{}")
+
+					t1 := tree.New()
+					t1.Insert([]byte("foo;bar"), 2)
+					t2 := tree.New()
+					t2.Insert([]byte("foo;bar"), 1)
+					t2.Insert([]byte("foo;baz"), 5)
+					for _, pi := range []*storage.PutInput{
+						{StartTime: st1, EndTime: et1, Key: key, Val: t1, SpyName: "gospy", SampleRate: 100, Units: "samples"},
+						{StartTime: st2, EndTime: et2, Key: key, Val: t2, SpyName: "gospy", SampleRate: 100, Units: "samples"},
+					} {
+						Expect(s.Put(pi)).ToNot(HaveOccurred())
+					}
+
+					u, _ := url.Parse(httpServer.URL + "/render-diff")
+					q := u.Query()
+					q.Add("name", "test.app{}")
+					q.Add("leftFrom", strconv.Itoa(int(st1.Unix())))
+					q.Add("leftUntil", strconv.Itoa(int(et1.Unix())))
+					q.Add("rightFrom", strconv.Itoa(int(st2.Unix())))
+					q.Add("rightUntil", strconv.Itoa(int(et2.Unix())))
+					u.RawQuery = q.Encode()
+
+					res, err := http.Get(u.String())
+					Expect(err).ToNot(HaveOccurred())
+					Expect(res.StatusCode).To(Equal(200))
+
+					var body struct {
+						Flamebearer tree.Flamebearer `json:"flamebearer"`
+					}
+					Expect(json.NewDecoder(res.Body).Decode(&body)).ToNot(HaveOccurred())
+					fb := body.Flamebearer
+					Expect(fb.Format).To(Equal(tree.FormatDouble))
+					Expect(fb.LeftTicks).To(Equal(uint64(2)))
+					Expect(fb.RightTicks).To(Equal(uint64(6)))
+					Expect(fb.Names).To(ConsistOf("total", "foo", "bar", "baz"))
+					Expect(fb.Units).To(Equal("samples"))
+
+					close(done)
+				}()
+				Eventually(done, 2).Should(BeClosed())
+			})
+		})
+	})
+})
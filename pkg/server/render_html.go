@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// standaloneData is passed to the standalone webapp bundle,
+// see webapp/javascript/standalone.jsx
+type standaloneData struct {
+	Title       string            `json:"title"`
+	From        string            `json:"from"`
+	Until       string            `json:"until"`
+	Flamebearer *tree.Flamebearer `json:"flamebearer"`
+}
+
+type standalonePage struct {
+	AppName string
+	Title   string
+	Style   template.CSS
+	Script  template.JS
+	Data    standaloneData
+}
+
+// standaloneTemplate is a self-contained page that renders a flamegraph with the
+// webapp flamegraph component. Styles and scripts are inlined, so that the page
+// can be attached to tickets or emails.
+var standaloneTemplate = template.Must(template.New("standalone").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }} | Pyroscope</title>
+<style>{{ .Style }}</style>
+</head>
+<body>
+<div id="root"></div>
+<script type="text/javascript">window.initialState = {"appNames": [{{ .AppName }}]};</script>
+<script type="text/javascript">window.standalone = {{ .Data }};</script>
+<script type="text/javascript">{{ .Script }}</script>
+</body>
+</html>
+`))
+
+func renderStandalonePage(w io.Writer, dir http.FileSystem, appName, title string, startTime, endTime time.Time, fb *tree.Flamebearer) error {
+	style, err := readAsset(dir, "/build/standalone.css")
+	if err != nil {
+		return err
+	}
+	script, err := readAsset(dir, "/build/standalone.js")
+	if err != nil {
+		return err
+	}
+	return standaloneTemplate.Execute(w, standalonePage{
+		AppName: appName,
+		Title:   title,
+		Style:   template.CSS(style),
+		Script:  template.JS(script),
+		Data: standaloneData{
+			Title:       title,
+			From:        startTime.UTC().Format(time.RFC3339),
+			Until:       endTime.UTC().Format(time.RFC3339),
+			Flamebearer: fb,
+		},
+	})
+}
+
+func readAsset(dir http.FileSystem, name string) ([]byte, error) {
+	f, err := dir.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not find file %s: %w", name, err)
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("server", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		Describe("/render", func() {
+			var format string
+			var checkResponse func(*http.Response)
+			var assets http.FileSystem
+
+			// puts a single profile and requests it back in the given format
+			JustBeforeEach(func() {
+				done := make(chan interface{})
+				go func() {
+					defer GinkgoRecover()
+
+					s, err := storage.New(&(*cfg).Server)
+					Expect(err).ToNot(HaveOccurred())
+					defer s.Close()
+					c, _ := New(&(*cfg).Server, s)
+					if assets != nil {
+						c.dir = assets
+					}
+					httpServer := httptest.NewServer(c.mux())
+					defer httpServer.Close()
+
+					st := testing.ParseTime("2020-01-01-01:01:00")
+					et := testing.ParseTime("2020-01-01-01:01:10")
+					key, _ := storage.ParseKey("test.app{foo=bar}")
+
+					t := tree.New()
+					t.Insert([]byte("foo;bar"), 2)
+					t.Insert([]byte("foo;baz"), 5)
+					Expect(s.Put(&storage.PutInput{
+						StartTime:  st,
+						EndTime:    et,
+						Key:        key,
+						Val:        t,
+						SpyName:    "gospy",
+						SampleRate: 100,
+						Units:      "samples",
+					})).ToNot(HaveOccurred())
+
+					u, _ := url.Parse(httpServer.URL + "/render")
+					q := u.Query()
+					q.Add("name", `test.app{foo="bar"}`)
+					q.Add("from", strconv.Itoa(int(st.Unix())))
+					q.Add("until", strconv.Itoa(int(et.Unix())))
+					q.Add("format", format)
+					u.RawQuery = q.Encode()
+
+					res, err := http.Get(u.String())
+					Expect(err).ToNot(HaveOccurred())
+					defer res.Body.Close()
+					checkResponse(res)
+
+					close(done)
+				}()
+				Eventually(done, 2).Should(BeClosed())
+			})
+
+			Context("format=pprof", func() {
+				BeforeEach(func() {
+					format = "pprof"
+					checkResponse = func(res *http.Response) {
+						Expect(res.StatusCode).To(Equal(200))
+						p, err := convert.ParsePprof(res.Body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(p.SampleTypes()).To(Equal([]string{"samples"}))
+						Expect(p.Period).To(Equal(int64(10000000)))
+
+						result := []string{}
+						p.Get("samples", func(name []byte, val int) {
+							result = append(result, fmt.Sprintf("%s %d", name, val))
+						})
+						Expect(result).To(ConsistOf("foo;bar 2", "foo;baz 5"))
+					}
+				})
+				It("returns gzipped pprof profile", func() {})
+			})
+
+			Context("format=collapsed", func() {
+				BeforeEach(func() {
+					format = "collapsed"
+					checkResponse = func(res *http.Response) {
+						Expect(res.StatusCode).To(Equal(200))
+						Expect(res.Header.Get("Content-Disposition")).To(ContainSubstring(`"test.app.txt"`))
+						b, err := ioutil.ReadAll(res.Body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(string(b)).To(Equal("foo;bar 2\nfoo;baz 5\n"))
+					}
+				})
+				It("returns folded stacks", func() {})
+			})
+
+			Context("format=speedscope", func() {
+				BeforeEach(func() {
+					format = "speedscope"
+					checkResponse = func(res *http.Response) {
+						Expect(res.StatusCode).To(Equal(200))
+						var f tree.SpeedscopeFile
+						Expect(json.NewDecoder(res.Body).Decode(&f)).ToNot(HaveOccurred())
+						Expect(f.Shared.Frames).To(HaveLen(3))
+						Expect(f.Profiles).To(HaveLen(1))
+						Expect(f.Profiles[0].Unit).To(Equal("seconds"))
+						Expect(f.Profiles[0].Weights).To(Equal([]float64{0.02, 0.05}))
+					}
+				})
+				It("returns speedscope json", func() {})
+			})
+
+			Context("format=html", func() {
+				BeforeEach(func() {
+					format = "html"
+					assets = staticFS{
+						"/build/standalone.js":  "renderFlamegraph();",
+						"/build/standalone.css": ".flamegraph-canvas{}",
+					}
+					checkResponse = func(res *http.Response) {
+						Expect(res.StatusCode).To(Equal(200))
+						Expect(res.Header.Get("Content-Type")).To(Equal("text/html"))
+						b, err := ioutil.ReadAll(res.Body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(string(b)).To(ContainSubstring(`"names":["total","foo","baz","bar"]`))
+						Expect(string(b)).To(ContainSubstring(`<script type="text/javascript">renderFlamegraph();</script>`))
+						Expect(string(b)).To(ContainSubstring(`<style>.flamegraph-canvas{}</style>`))
+					}
+				})
+				AfterEach(func() {
+					assets = nil
+				})
+				It("returns a standalone page with the webapp flamegraph", func() {})
+
+				Context("when webapp assets are not built", func() {
+					BeforeEach(func() {
+						assets = staticFS{}
+						checkResponse = func(res *http.Response) {
+							Expect(res.StatusCode).To(Equal(500))
+						}
+					})
+					It("returns an error", func() {})
+				})
+			})
+
+			Context("unknown format", func() {
+				BeforeEach(func() {
+					format = "foo"
+					checkResponse = func(res *http.Response) {
+						Expect(res.StatusCode).To(Equal(422))
+					}
+				})
+				It("returns an error", func() {})
+			})
+		})
+	})
+})
+
+// staticFS is an in-memory file system with webapp assets
+type staticFS map[string]string
+
+func (fs staticFS) Open(name string) (http.File, error) {
+	content, ok := fs[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return httpFile{strings.NewReader(content)}, nil
+}
+
+type httpFile struct{ *strings.Reader }
+
+func (httpFile) Close() error                       { return nil }
+func (httpFile) Readdir(int) ([]os.FileInfo, error) { return nil, nil }
+func (httpFile) Stat() (os.FileInfo, error)         { return nil, os.ErrInvalid }
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+// Role defines what an API key gives access to
+type Role string
+
+const (
+	// RoleAdmin allows everything, including management of applications and API keys
+	RoleAdmin Role = "admin"
+	// RoleReadOnly allows reading profiling data and using the web UI
+	RoleReadOnly Role = "readonly"
+	// RoleIngest allows uploading profiling data only
+	RoleIngest Role = "ingest"
+)
+
+// ParseRole returns the role with the given name
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleAdmin, RoleReadOnly, RoleIngest:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown role %q, expected one of: admin, readonly, ingest", s)
+	}
+}
+
+// Allows reports whether the role gives the access the required role gives
+func (r Role) Allows(required Role) bool {
+	return r == RoleAdmin || r == required
+}
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyExists   = errors.New("api key already exists")
+
+	errInvalidAPIKeyName = errors.New("api key name is empty")
+)
+
+// API keys are stored in the main database under hashes of their tokens,
+// tokens themselves are only returned once, when a key is created.
+const apiKeyPrefix = "k:"
+
+type APIKey struct {
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// APIKeyStore manages API keys kept in the main database of the storage.
+// Storage embeds it, OpenAPIKeyStore opens the database on its own.
+type APIKeyStore struct {
+	db *badger.DB
+	// createMutex serializes key creation: badger transactions do not
+	// conflict when another transaction adds a key to the range they scan
+	createMutex sync.Mutex
+}
+
+// OpenAPIKeyStore opens the main database only, so that API keys can be
+// managed without loading the rest of the storage. Badger locks the database
+// directory, which means the server using the storage path must be stopped.
+func OpenAPIKeyStore(c *config.Server) (*APIKeyStore, error) {
+	db, err := openBadger(c, "main")
+	if err != nil {
+		return nil, err
+	}
+	return &APIKeyStore{db: db}, nil
+}
+
+// Close closes the database opened with OpenAPIKeyStore
+func (k *APIKeyStore) Close() error {
+	return k.db.Close()
+}
+
+// CreateAPIKey creates a new API key and returns its token
+func (k *APIKeyStore) CreateAPIKey(name string, role Role) (string, error) {
+	if name == "" {
+		return "", errInvalidAPIKeyName
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	v, err := json.Marshal(APIKey{
+		Name:      name,
+		Role:      role,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	k.createMutex.Lock()
+	defer k.createMutex.Unlock()
+	err = k.db.Update(func(txn *badger.Txn) error {
+		if _, _, err := findAPIKey(txn, name); err == nil {
+			return ErrAPIKeyExists
+		} else if !errors.Is(err, ErrAPIKeyNotFound) {
+			return err
+		}
+		return txn.Set(apiKeyDBKey(token), v)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeAPIKey removes the API key, requests with its token are rejected afterwards
+func (k *APIKeyStore) RevokeAPIKey(name string) error {
+	return k.db.Update(func(txn *badger.Txn) error {
+		dbKey, _, err := findAPIKey(txn, name)
+		if err != nil {
+			return err
+		}
+		return txn.Delete(dbKey)
+	})
+}
+
+// APIKeys returns all the API keys sorted by name
+func (k *APIKeyStore) APIKeys() ([]*APIKey, error) {
+	var keys []*APIKey
+	err := k.db.View(func(txn *badger.Txn) error {
+		return iterateAPIKeys(txn, func(_ []byte, key *APIKey) bool {
+			keys = append(keys, key)
+			return true
+		})
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+	return keys, err
+}
+
+// APIKeyByToken returns the API key the token belongs to
+func (k *APIKeyStore) APIKeyByToken(token string) (*APIKey, error) {
+	var key APIKey
+	err := k.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(apiKeyDBKey(token))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrAPIKeyNotFound
+			}
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &key)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func findAPIKey(txn *badger.Txn, name string) ([]byte, *APIKey, error) {
+	var k []byte
+	var res *APIKey
+	err := iterateAPIKeys(txn, func(dbKey []byte, key *APIKey) bool {
+		if key.Name != name {
+			return true
+		}
+		k, res = dbKey, key
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if res == nil {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+	return k, res, nil
+}
+
+func iterateAPIKeys(txn *badger.Txn, cb func(dbKey []byte, key *APIKey) bool) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(apiKeyPrefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		var key APIKey
+		if err := item.Value(func(v []byte) error { return json.Unmarshal(v, &key) }); err != nil {
+			return fmt.Errorf("api key %s: %v", item.Key(), err)
+		}
+		if !cb(item.KeyCopy(nil), &key) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func apiKeyDBKey(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return []byte(apiKeyPrefix + hex.EncodeToString(h[:]))
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("api keys", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		BeforeEach(func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("creates, finds and revokes keys", func() {
+			token, err := s.CreateAPIKey("agents", RoleIngest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(token).To(HaveLen(64))
+			_, err = s.CreateAPIKey("admin", RoleAdmin)
+			Expect(err).ToNot(HaveOccurred())
+
+			key, err := s.APIKeyByToken(token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key.Name).To(Equal("agents"))
+			Expect(key.Role).To(Equal(RoleIngest))
+			_, err = s.APIKeyByToken("foo")
+			Expect(err).To(MatchError(ErrAPIKeyNotFound))
+
+			_, err = s.CreateAPIKey("agents", RoleReadOnly)
+			Expect(err).To(MatchError(ErrAPIKeyExists))
+			_, err = s.CreateAPIKey("ui", Role("viewer"))
+			Expect(err).To(HaveOccurred())
+
+			keys, err := s.APIKeys()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(HaveLen(2))
+			Expect(keys[0].Name).To(Equal("admin"))
+			Expect(keys[1].Name).To(Equal("agents"))
+
+			Expect(s.RevokeAPIKey("agents")).To(Succeed())
+			Expect(s.RevokeAPIKey("agents")).To(MatchError(ErrAPIKeyNotFound))
+			_, err = s.APIKeyByToken(token)
+			Expect(err).To(MatchError(ErrAPIKeyNotFound))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("creates keys with unique names concurrently", func() {
+			var wg sync.WaitGroup
+			var created int32
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := s.CreateAPIKey("agents", RoleIngest)
+					if err == nil {
+						atomic.AddInt32(&created, 1)
+						return
+					}
+					Expect(err).To(MatchError(ErrAPIKeyExists))
+				}()
+			}
+			wg.Wait()
+			Expect(created).To(Equal(int32(1)))
+			keys, err := s.APIKeys()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(HaveLen(1))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("manages keys without the rest of the storage", func() {
+			Expect(s.Close()).ToNot(HaveOccurred())
+			k, err := OpenAPIKeyStore(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			token, err := k.CreateAPIKey("admin", RoleAdmin)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k.Close()).ToNot(HaveOccurred())
+
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			key, err := s.APIKeyByToken(token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key.Name).To(Equal("admin"))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("checks roles", func() {
+			Expect(RoleAdmin.Allows(RoleIngest)).To(BeTrue())
+			Expect(RoleReadOnly.Allows(RoleReadOnly)).To(BeTrue())
+			Expect(RoleReadOnly.Allows(RoleIngest)).To(BeFalse())
+			Expect(RoleIngest.Allows(RoleAdmin)).To(BeFalse())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
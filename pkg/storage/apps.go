@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dict"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+var (
+	ErrAppNotFound    = errors.New("application not found")
+	ErrAppExists      = errors.New("application already exists")
+	ErrInvalidAppName = errors.New("invalid application name")
+)
+
+// AppInfo describes profiling data stored for an application
+type AppInfo struct {
+	Name       string
+	SpyName    string
+	SampleRate uint32
+	Units      string
+	// FirstSeen and LastSeen are the boundaries of the time range there is data for
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// DiskSize is an estimated size of the data on disk, data that has not been
+	// written back yet is not accounted
+	DiskSize uint64
+	// Series are the segment keys of the application, e.g app.cpu{env=prod}
+	Series []string
+}
+
+// Apps returns information about all the applications sorted by name
+func (s *Storage) Apps() ([]*AppInfo, error) {
+	var names []string
+	s.labels.GetValues("__name__", func(v string) bool {
+		names = append(names, v)
+		return true
+	})
+	sort.Strings(names)
+
+	res := make([]*AppInfo, 0, len(names))
+	for _, name := range names {
+		info, err := s.App(name)
+		switch {
+		case errors.Is(err, ErrAppNotFound):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		res = append(res, info)
+	}
+	return res, nil
+}
+
+// App returns information about the application, ErrAppNotFound is returned
+// if there is no data for it
+func (s *Storage) App(name string) (*AppInfo, error) {
+	segmentKeys := s.appSegmentKeys(name)
+	if len(segmentKeys) == 0 {
+		return nil, ErrAppNotFound
+	}
+
+	// keys of all the series of the application start with the name followed
+	// by labels, summing them up avoids a lookup for every stored tree
+	info := AppInfo{
+		Name: name,
+		DiskSize: s.dicts.DiskSize(name) +
+			s.segments.PrefixDiskSize(name+"{") +
+			s.trees.PrefixDiskSize(name+"{"),
+	}
+	for _, sk := range segmentKeys {
+		key, err := ParseKey(string(sk))
+		if err != nil {
+			return nil, err
+		}
+		stInt, err := s.segments.Get(key.SegmentKey())
+		if err != nil {
+			return nil, fmt.Errorf("segments cache for %v: %v", key.SegmentKey(), err)
+		}
+		st := stInt.(*segment.Segment)
+		if info.SpyName == "" {
+			info.SpyName = st.SpyName()
+			info.SampleRate = st.SampleRate()
+			info.Units = st.Units()
+		}
+		st.Walk(zeroTime, maxTime, func(depth int, _, _ uint64, t time.Time, _ *big.Rat) {
+			start, end := nodeTimeRange(st, depth, t, zeroTime, maxTime)
+			if info.FirstSeen.IsZero() || start.Before(info.FirstSeen) {
+				info.FirstSeen = start
+			}
+			if end.After(info.LastSeen) {
+				info.LastSeen = end
+			}
+		})
+		info.Series = append(info.Series, key.SegmentKey())
+	}
+	return &info, nil
+}
+
+func (s *Storage) appSegmentKeys(name string) []dimension.Key {
+	d, ok := s.lookupDimensionKV("__name__", name)
+	if !ok {
+		return nil
+	}
+	return dimension.Union(d)
+}
+
+// DeleteByQuery removes all the data of the series matching the query,
+// e.g app.cpu{} removes the whole application. It returns the number of
+// deleted series.
+func (s *Storage) DeleteByQuery(q *Query) (int, error) {
+	var n int
+	for _, sk := range s.execQuery(q) {
+		key, err := ParseKey(string(sk))
+		if err != nil {
+			return n, err
+		}
+		if err = s.deleteSegment(key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, s.checkpointIfEnabled()
+}
+
+// RenameApp moves all the data of the application to the new name.
+// It returns the number of renamed series. The data put to the application
+// while it is being renamed may stay under the old name.
+func (s *Storage) RenameApp(oldName, newName string) (int, error) {
+	if k, err := ParseKey(newName); err != nil || k.AppName() != newName {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAppName, newName)
+	}
+	s.renameMutex.Lock()
+	defer s.renameMutex.Unlock()
+	segmentKeys := s.appSegmentKeys(oldName)
+	if len(segmentKeys) == 0 {
+		return 0, ErrAppNotFound
+	}
+	if len(s.appSegmentKeys(newName)) > 0 {
+		return 0, ErrAppExists
+	}
+
+	// trees are serialized with the dictionary of the application,
+	// it has to be stored under the new name before trees are moved.
+	// A copy is stored, the object must not be shared by both names
+	dInt, err := s.dicts.Get(oldName)
+	if err != nil {
+		return 0, fmt.Errorf("dicts cache for %v: %v", oldName, err)
+	}
+	b, err := dInt.(*dict.Dict).Bytes()
+	if err != nil {
+		return 0, fmt.Errorf("dicts cache for %v: %v", oldName, err)
+	}
+	d, err := dict.FromBytes(b)
+	if err != nil {
+		return 0, fmt.Errorf("dicts cache for %v: %v", oldName, err)
+	}
+	if err = s.dicts.Save(newName, d); err != nil {
+		return 0, fmt.Errorf("dicts cache for %v: %v", newName, err)
+	}
+
+	var n int
+	for _, sk := range segmentKeys {
+		key, err := ParseKey(string(sk))
+		if err != nil {
+			return n, err
+		}
+		if err = s.renameSegment(key, newName); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, s.checkpointIfEnabled()
+}
+
+// renameSegment writes the segment and its trees under the new name
+// before the old ones are deleted, so that a failure in between never
+// leaves the data on disk under neither of the names.
+func (s *Storage) renameSegment(oldKey *Key, newName string) error {
+	newKey := oldKey.Clone()
+	newKey.Add("__name__", newName)
+	oldSk, newSk := oldKey.SegmentKey(), newKey.SegmentKey()
+	// data may be put under the new name while the application is renamed
+	s.segmentLocks.LockMany(oldSk, newSk)
+	defer s.segmentLocks.UnlockMany(oldSk, newSk)
+
+	newStInt, err := s.segments.Get(newSk)
+	if err != nil {
+		return fmt.Errorf("segments cache for %v: %v", newSk, err)
+	}
+	if !newStInt.(*segment.Segment).IsEmpty() {
+		return fmt.Errorf("%w: %v", ErrAppExists, newSk)
+	}
+	stInt, err := s.segments.Get(oldSk)
+	if err != nil {
+		return fmt.Errorf("segments cache for %v: %v", oldSk, err)
+	}
+	st := stInt.(*segment.Segment)
+	var oldTreeKeys []string
+	st.WalkPresent(func(depth int, t time.Time) {
+		if err != nil {
+			return
+		}
+		oldTk := oldKey.TreeKey(depth, t)
+		oldTreeKeys = append(oldTreeKeys, oldTk)
+		var res interface{}
+		if res, err = s.trees.Get(oldTk); err != nil {
+			err = fmt.Errorf("trees cache for %v: %v", oldTk, err)
+			return
+		}
+		// trees that can't be read (e.g their dictionary is missing) are dropped
+		if res != nil {
+			newTk := newKey.TreeKey(depth, t)
+			if err = s.trees.Save(newTk, res.(*tree.Tree)); err != nil {
+				err = fmt.Errorf("trees cache for %v: %v", newTk, err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// the segment object stays cached under the old key until it is
+	// deleted, the new key gets a copy
+	b, err := st.Bytes()
+	if err != nil {
+		return fmt.Errorf("segments cache for %v: %v", oldSk, err)
+	}
+	newSt, err := segment.FromBytes(b)
+	if err != nil {
+		return fmt.Errorf("segments cache for %v: %v", oldSk, err)
+	}
+	if err = s.segments.Save(newSk, newSt); err != nil {
+		return fmt.Errorf("segments cache for %v: %v", newSk, err)
+	}
+	for k, v := range newKey.labels {
+		s.labels.Put(k, v)
+		res, err := s.dimensions.Get(k + ":" + v)
+		if err != nil {
+			return fmt.Errorf("dimensions cache for %v: %v", k+":"+v, err)
+		}
+		res.(*dimension.Dimension).Insert(dimension.Key(newSk))
+		if err = s.dimensions.Save(k+":"+v, res); err != nil {
+			return fmt.Errorf("dimensions cache for %v: %v", k+":"+v, err)
+		}
+	}
+
+	for _, oldTk := range oldTreeKeys {
+		if err = s.trees.Delete(oldTk); err != nil {
+			return err
+		}
+	}
+	return s.deleteSegmentAndRelatedData(oldKey)
+}
+
+// checkpointIfEnabled makes sure data removed from the storage
+// is not restored when the write-ahead log is replayed
+func (s *Storage) checkpointIfEnabled() error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.checkpoint()
+}
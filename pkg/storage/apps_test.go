@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("applications", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		st := testing.SimpleUTime(1600000000)
+
+		put := func(key string, st time.Time) {
+			k, err := ParseKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), 1)
+			t.Insert([]byte("a;c"), 2)
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+				Units:      "samples",
+			})).ToNot(HaveOccurred())
+		}
+
+		get := func(key string) *GetOutput {
+			k, err := ParseKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			gOut, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(time.Hour), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			return gOut
+		}
+
+		values := func(label string) []string {
+			var res []string
+			s.GetValues(label, func(v string) bool {
+				res = append(res, v)
+				return true
+			})
+			return res
+		}
+
+		BeforeEach(func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			put("app.cpu{env=prod}", st)
+			put("app.cpu{env=prod}", st.Add(20*time.Second))
+			put("app.cpu{env=dev}", st.Add(time.Minute))
+			put("other.cpu{}", st)
+		})
+
+		It("lists applications", func() {
+			apps, err := s.Apps()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(apps).To(HaveLen(2))
+			Expect(apps[0].Name).To(Equal("app.cpu"))
+			Expect(apps[0].SpyName).To(Equal("testspy"))
+			Expect(apps[0].SampleRate).To(Equal(uint32(100)))
+			Expect(apps[0].Units).To(Equal("samples"))
+			Expect(apps[0].FirstSeen).To(Equal(st))
+			Expect(apps[0].LastSeen).To(Equal(st.Add(70 * time.Second)))
+			Expect(apps[0].Series).To(ConsistOf("app.cpu{env=prod}", "app.cpu{env=dev}"))
+			Expect(apps[1].Name).To(Equal("other.cpu"))
+
+			_, err = s.App("unknown.cpu")
+			Expect(err).To(MatchError(ErrAppNotFound))
+
+			By("estimating the size of data written to disk")
+			Expect(s.Close()).ToNot(HaveOccurred())
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			app, err := s.App("app.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			other, err := s.App("other.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(other.DiskSize).ToNot(BeZero())
+			Expect(app.DiskSize).To(BeNumerically(">", other.DiskSize))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("deletes series matching a selector", func() {
+			q, err := ParseQuery(`app.cpu{env="dev"}`)
+			Expect(err).ToNot(HaveOccurred())
+			n, err := s.DeleteByQuery(q)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(1))
+			Expect(get("app.cpu{env=dev}")).To(BeNil())
+			Expect(get("app.cpu{env=prod}").Tree.String()).To(Equal("\"a;b\" 2\n\"a;c\" 4\n"))
+			Expect(values("env")).To(ConsistOf("prod"))
+
+			q, err = ParseQuery("app.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			n, err = s.DeleteByQuery(q)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(1))
+			Expect(get("app.cpu{}")).To(BeNil())
+			Expect(values("__name__")).To(ConsistOf("other.cpu"))
+			Expect(values("env")).To(BeEmpty())
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Check(false)).To(BeEmpty())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("renames applications", func() {
+			n, err := s.RenameApp("app.cpu", "new.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(2))
+			Expect(get("app.cpu{}")).To(BeNil())
+			Expect(get("new.cpu{env=prod}").Tree.String()).To(Equal("\"a;b\" 2\n\"a;c\" 4\n"))
+			Expect(values("__name__")).To(ConsistOf("new.cpu", "other.cpu"))
+
+			_, err = s.RenameApp("new.cpu", "other.cpu")
+			Expect(err).To(MatchError(ErrAppExists))
+			_, err = s.RenameApp("app.cpu", "foo.cpu")
+			Expect(err).To(MatchError(ErrAppNotFound))
+			_, err = s.RenameApp("new.cpu", "foo.cpu{env=prod}")
+			Expect(err).To(MatchError(ContainSubstring(ErrInvalidAppName.Error())))
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Check(false)).To(BeEmpty())
+			Expect(get("new.cpu{}").Tree.String()).To(Equal("\"a;b\" 3\n\"a;c\" 6\n"))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("writes renamed trees to disk", func() {
+			_, err := s.RenameApp("app.cpu", "new.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			for _, sk := range s.appSegmentKeys("new.cpu") {
+				key, err := ParseKey(string(sk))
+				Expect(err).ToNot(HaveOccurred())
+				stInt, err := s.segments.Get(key.SegmentKey())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s.segments.DiskSize(key.SegmentKey())).ToNot(BeZero())
+				stInt.(*segment.Segment).WalkPresent(func(depth int, t time.Time) {
+					Expect(s.trees.DiskSize(key.TreeKey(depth, t))).ToNot(BeZero())
+				})
+			}
+			Expect(s.dicts.DiskSize("new.cpu")).ToNot(BeZero())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("does not overwrite data put under the new name during a rename", func() {
+			// data put under the new name after the check for the application existence
+			st, err := s.segments.Get("app.cpu{env=prod}")
+			Expect(err).ToNot(HaveOccurred())
+			s.segments.Put("new.cpu{env=prod}", st)
+			_, err = s.RenameApp("app.cpu", "new.cpu")
+			Expect(err).To(MatchError(ErrAppExists))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("renames only one application to the same name", func() {
+			var wg sync.WaitGroup
+			errs := make([]error, 2)
+			for i, name := range []string{"app.cpu", "other.cpu"} {
+				wg.Add(1)
+				go func(i int, name string) {
+					defer wg.Done()
+					_, errs[i] = s.RenameApp(name, "new.cpu")
+				}(i, name)
+			}
+			wg.Wait()
+			Expect(errs).To(ContainElement(BeNil()))
+			Expect(errs).To(ContainElement(MatchError(ErrAppExists)))
+			Expect(values("__name__")).To(HaveLen(2))
+			Expect(values("__name__")).To(ContainElement("new.cpu"))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/dgrijalva/lfu-go"
+	"github.com/pyroscope-io/pyroscope/pkg/util/keylock"
 	"github.com/pyroscope-io/pyroscope/pkg/util/metrics"
 	"github.com/sirupsen/logrus"
 
@@ -20,6 +21,12 @@ type Cache struct {
 	alwaysSave    bool
 	evictionsDone chan struct{}
 	flushOnce     sync.Once
+	// loadLocks prevent concurrent cache misses for the same key
+	// from loading or creating more than one object
+	loadLocks *keylock.KeyLock
+
+	dirtyMutex sync.Mutex
+	dirty      map[string]interface{}
 
 	hitCounter          string
 	missCounter         string
@@ -32,8 +39,13 @@ type Cache struct {
 	FromBytes func(k string, v []byte) (interface{}, error)
 	// New creates a new object when there's no object in cache or storage. Optional
 	New func(k string) interface{}
+	// TrackDirty makes the cache remember objects passed to Put until they are saved with Sync,
+	// evictions don't write to disk then. Optional
+	TrackDirty bool
 }
 
+const loadLocksCount = 256
+
 func New(db *badger.DB, prefix, humanReadableName string) *Cache {
 	evictionChannel := make(chan lfu.Eviction)
 	writeBackChannel := make(chan lfu.Eviction)
@@ -54,6 +66,7 @@ func New(db *badger.DB, prefix, humanReadableName string) *Cache {
 
 		prefix:        prefix,
 		evictionsDone: make(chan struct{}),
+		loadLocks:     keylock.New(loadLocksCount),
 
 		hitCounter:          "cache_" + humanReadableName + "_hit",
 		missCounter:         "cache_" + humanReadableName + "_miss",
@@ -69,7 +82,11 @@ func New(db *badger.DB, prefix, humanReadableName string) *Cache {
 			if !ok {
 				break
 			}
-			cache.saveToDisk(e.Key, e.Value)
+			// objects of caches tracking dirty objects are only saved by Sync,
+			// evicted objects that are not saved yet are kept until then
+			if !cache.TrackDirty {
+				cache.saveToDisk(e.Key, e.Value)
+			}
 		}
 		cache.evictionsDone <- struct{}{}
 	}()
@@ -92,7 +109,60 @@ func (cache *Cache) Put(key string, val interface{}) {
 	cache.lfu.Set(key, val)
 	if cache.alwaysSave {
 		cache.saveToDisk(key, val)
+	} else if cache.TrackDirty {
+		cache.dirtyMutex.Lock()
+		cache.markDirty(key, val)
+		cache.dirtyMutex.Unlock()
+	}
+}
+
+// Save puts the object and writes it to disk synchronously
+func (cache *Cache) Save(key string, val interface{}) error {
+	cache.lfu.Set(key, val)
+	if cache.TrackDirty {
+		cache.dirtyMutex.Lock()
+		delete(cache.dirty, key)
+		cache.dirtyMutex.Unlock()
+	}
+	return cache.saveToDisk(key, val)
+}
+
+func (cache *Cache) markDirty(key string, val interface{}) {
+	if cache.dirty == nil {
+		cache.dirty = make(map[string]interface{})
+	}
+	cache.dirty[key] = val
+}
+
+func (cache *Cache) dirtyValue(key string) interface{} {
+	cache.dirtyMutex.Lock()
+	defer cache.dirtyMutex.Unlock()
+	return cache.dirty[key]
+}
+
+// Sync synchronously saves all objects passed to Put since the previous call,
+// see TrackDirty. Unlike WriteBack it never skips objects.
+func (cache *Cache) Sync() error {
+	cache.dirtyMutex.Lock()
+	dirty := cache.dirty
+	cache.dirty = nil
+	cache.dirtyMutex.Unlock()
+
+	var firstErr error
+	for k, v := range dirty {
+		if err := cache.saveToDisk(k, v); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			cache.dirtyMutex.Lock()
+			// the object could have been put again in the meantime
+			if _, ok := cache.dirty[k]; !ok {
+				cache.markDirty(k, v)
+			}
+			cache.dirtyMutex.Unlock()
+		}
 	}
+	return firstErr
 }
 
 func (cache *Cache) saveToDisk(key string, val interface{}) error {
@@ -139,6 +209,9 @@ func (cache *Cache) WriteBack() {
 
 func (cache *Cache) Delete(key string) error {
 	cache.lfu.Delete(key)
+	cache.dirtyMutex.Lock()
+	delete(cache.dirty, key)
+	cache.dirtyMutex.Unlock()
 
 	err := cache.db.Update(func(txn *badger.Txn) error {
 		return txn.Delete([]byte(cache.prefix + key))
@@ -154,9 +227,23 @@ func (cache *Cache) Get(key string) (interface{}, error) {
 		metrics.Count(cache.hitCounter, 1)
 		return val, nil
 	}
+
+	cache.loadLocks.Lock(key)
+	defer cache.loadLocks.Unlock(key)
+	// the object could have been loaded while we were waiting for the lock
+	if val = cache.lfu.Get(key); val != nil {
+		metrics.Count(cache.hitCounter, 1)
+		return val, nil
+	}
 	logrus.WithField("key", key).Debug("lfu miss")
 	metrics.Count(cache.missCounter, 1)
 
+	// the object could have been evicted before it was synced
+	if val = cache.dirtyValue(key); val != nil {
+		cache.lfu.Set(key, val)
+		return val, nil
+	}
+
 	var copied []byte
 	// read the value from badger
 	if err := cache.db.View(func(txn *badger.Txn) error {
@@ -209,6 +296,39 @@ func (cache *Cache) Get(key string) (interface{}, error) {
 	return val, nil
 }
 
+// DiskSize returns an estimated size of the value stored on disk for the key.
+// Zero is returned if the value has not been saved yet.
+func (cache *Cache) DiskSize(key string) uint64 {
+	var size uint64
+	cache.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(cache.prefix + key))
+		if err != nil {
+			return err
+		}
+		size = uint64(item.EstimatedSize())
+		return nil
+	})
+	return size
+}
+
+// PrefixDiskSize returns an estimated size on disk of all the values with
+// keys starting with the prefix. Only keys are iterated, values are not read.
+func (cache *Cache) PrefixDiskSize(prefix string) uint64 {
+	var size uint64
+	cache.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(cache.prefix + prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			size += uint64(it.Item().EstimatedSize())
+		}
+		return nil
+	})
+	return size
+}
+
 func (cache *Cache) Size() uint64 {
 	return uint64(cache.lfu.Len())
 }
@@ -61,4 +61,39 @@ var _ = Describe("cache", func() {
 
 		close(done)
 	}, 3)
+
+	It("syncs dirty objects", func() {
+		tdir := testing.TmpDirSync()
+		defer tdir.Close()
+		db, err := badger.Open(badger.DefaultOptions(tdir.Path).WithLogger(nil))
+		Expect(err).ToNot(HaveOccurred())
+		defer db.Close()
+
+		cache := New(db, "prefix:", "test_cache")
+		cache.TrackDirty = true
+		cache.Bytes = func(k string, v interface{}) ([]byte, error) {
+			return []byte(v.(string)), nil
+		}
+		cache.FromBytes = func(k string, v []byte) (interface{}, error) {
+			return string(v), nil
+		}
+
+		cache.Put("foo", "bar")
+		Expect(cache.DiskSize("foo")).To(BeZero())
+		Expect(cache.Sync()).ToNot(HaveOccurred())
+		Expect(cache.DiskSize("foo")).ToNot(BeZero())
+
+		By("summing up sizes of keys with a prefix")
+		Expect(cache.Save("food", "bar")).ToNot(HaveOccurred())
+		Expect(cache.Save("bar", "baz")).ToNot(HaveOccurred())
+		Expect(cache.PrefixDiskSize("foo")).To(Equal(cache.DiskSize("foo") + cache.DiskSize("food")))
+		Expect(cache.PrefixDiskSize("qux")).To(BeZero())
+
+		By("serving objects evicted before they were synced")
+		cache.Put("foo", "baz")
+		cache.lfu.Delete("foo")
+		v, err := cache.Get("foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v).To(Equal("baz"))
+	})
 })
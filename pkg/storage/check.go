@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dict"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+type IssueKind string
+
+const (
+	// IssueUnparsable means the key or the value can't be deserialized
+	IssueUnparsable IssueKind = "unparsable"
+	// IssueDangling means the entry references data that does not exist
+	IssueDangling IssueKind = "dangling"
+	// IssueOrphaned means the entry is not referenced by any segment
+	IssueOrphaned IssueKind = "orphaned"
+	// IssueMissing means an index entry of an existing segment is missing
+	IssueMissing IssueKind = "missing"
+)
+
+// Issue is an inconsistency found by Check. Key is the badger key
+// including the prefix, e.g "t:app.cpu{}:0:1600000000"
+type Issue struct {
+	Kind        IssueKind
+	Key         string
+	Description string
+	Repaired    bool
+}
+
+func (i *Issue) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Kind, i.Key, i.Description)
+}
+
+// Check walks segments, dimensions, labels, dicts and trees and reports the entries
+// Get would skip or fail on. With repair, unparsable, dangling and orphaned entries
+// are removed, and missing dimensions and labels of segments are rebuilt. Segment nodes
+// whose trees can't be read are removed from the segment, as retention does.
+// Check is meant to be used when the storage is not receiving data.
+func (s *Storage) Check(repair bool) ([]*Issue, error) {
+	// data replayed from the write-ahead log is only in the caches, Check reads from disk
+	if err := s.checkpointIfEnabled(); err != nil {
+		return nil, err
+	}
+	c := checker{s: s}
+	for _, step := range []func() error{
+		c.checkSegments,
+		c.checkDimensions,
+		c.checkSegmentIndexes,
+		c.checkLabels,
+		c.checkDicts,
+		c.checkTrees,
+		c.checkSegmentTrees,
+	} {
+		if err := step(); err != nil {
+			return c.issues, err
+		}
+		// repairs are applied once the data is read, so that iterations are not affected
+		if !repair {
+			c.repairs = nil
+			continue
+		}
+		if err := c.applyRepairs(); err != nil {
+			return c.issues, err
+		}
+	}
+	return c.issues, nil
+}
+
+type checker struct {
+	s       *Storage
+	issues  []*Issue
+	repairs []func() error
+
+	// segments that can be read, by segment key
+	segments     map[string]*Key
+	segmentNodes map[string]*segment.Segment
+	// dimensions that can be read, by label key and value
+	dimensions map[string]*dimension.Dimension
+	// segment keys of the dimensions, by label key and value
+	dimensionKeys map[string]map[string]struct{}
+	// dicts that can be read, by dict key
+	dicts map[string]*dict.Dict
+	// trees that can be read, by tree key
+	trees map[string]struct{}
+}
+
+func (c *checker) report(kind IssueKind, key, description string, repair func() error) {
+	issue := &Issue{Kind: kind, Key: key, Description: description}
+	c.issues = append(c.issues, issue)
+	c.repairs = append(c.repairs, func() error {
+		if err := repair(); err != nil {
+			return fmt.Errorf("repair %s: %w", key, err)
+		}
+		issue.Repaired = true
+		return nil
+	})
+}
+
+func (c *checker) applyRepairs() error {
+	repairs := c.repairs
+	c.repairs = nil
+	for _, repair := range repairs {
+		if err := repair(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkSegments() error {
+	c.segments = make(map[string]*Key)
+	c.segmentNodes = make(map[string]*segment.Segment)
+	return iteratePrefix(c.s.dbSegments, "s:", func(k string, v []byte) error {
+		key, err := ParseKey(k)
+		if err == nil && key.SegmentKey() != k {
+			err = fmt.Errorf("key is not normalized")
+		}
+		var st *segment.Segment
+		if err == nil {
+			st, err = segment.FromBytes(v)
+		}
+		if err != nil {
+			c.report(IssueUnparsable, "s:"+k, err.Error(), func() error {
+				return c.s.segments.Delete(k)
+			})
+			return nil
+		}
+		c.segments[k] = key
+		c.segmentNodes[k] = st
+		return nil
+	})
+}
+
+func (c *checker) checkDimensions() error {
+	c.dimensions = make(map[string]*dimension.Dimension)
+	c.dimensionKeys = make(map[string]map[string]struct{})
+	return iteratePrefix(c.s.dbDimensions, "i:", func(name string, v []byte) error {
+		d, err := dimension.FromBytes(v)
+		if err != nil {
+			c.report(IssueUnparsable, "i:"+name, err.Error(), func() error {
+				return c.s.dimensions.Delete(name)
+			})
+			return nil
+		}
+		c.dimensions[name] = d
+		keys := make(map[string]struct{})
+		c.dimensionKeys[name] = keys
+		labelKey, labelValue := splitLabel(name)
+		for _, sk := range dimension.Union(d) {
+			keys[string(sk)] = struct{}{}
+			if key, ok := c.segments[string(sk)]; ok && key.labels[labelKey] == labelValue {
+				continue
+			}
+			sk := sk
+			c.report(IssueDangling, "i:"+name, fmt.Sprintf("references segment %q that does not exist", sk), func() error {
+				d.Delete(sk)
+				if len(dimension.Union(d)) > 0 {
+					return c.s.dimensions.Save(name, d)
+				}
+				delete(c.dimensions, name)
+				return c.s.dimensions.Delete(name)
+			})
+		}
+		return nil
+	})
+}
+
+// checkSegmentIndexes makes sure every segment can be found by each of its labels
+func (c *checker) checkSegmentIndexes() error {
+	labels := make(map[string]struct{})
+	err := iteratePrefix(c.s.db, "v:", func(k string, _ []byte) error {
+		labels[k] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for sk, key := range c.segments {
+		for k, v := range key.labels {
+			name, sk, k, v := k+":"+v, sk, k, v
+			if _, ok := c.dimensionKeys[name][sk]; !ok {
+				c.report(IssueMissing, "i:"+name, fmt.Sprintf("segment %q is not indexed", sk), func() error {
+					d, ok := c.dimensions[name]
+					if !ok {
+						d = dimension.New()
+						c.dimensions[name] = d
+					}
+					d.Insert(dimension.Key(sk))
+					return c.s.dimensions.Save(name, d)
+				})
+			}
+			if _, ok := labels[name]; !ok {
+				// reported once per label
+				labels[name] = struct{}{}
+				c.report(IssueMissing, "v:"+name, fmt.Sprintf("label of segment %q is not indexed", sk), func() error {
+					c.s.labels.Put(k, v)
+					return nil
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// checkLabels finds label values no segment has
+func (c *checker) checkLabels() error {
+	used := make(map[string]struct{})
+	for _, key := range c.segments {
+		for k, v := range key.labels {
+			used[k+":"+v] = struct{}{}
+		}
+	}
+	return iteratePrefix(c.s.db, "v:", func(name string, _ []byte) error {
+		if _, ok := used[name]; !ok {
+			k, v := splitLabel(name)
+			c.report(IssueDangling, "v:"+name, "no segment has the label", func() error {
+				return c.s.labels.Delete(k, v)
+			})
+		}
+		return nil
+	})
+}
+
+func (c *checker) checkDicts() error {
+	// dictionaries are keyed by application name, older ones by segment key
+	used := make(map[string]struct{})
+	for sk, key := range c.segments {
+		used[key.AppName()] = struct{}{}
+		used[sk] = struct{}{}
+	}
+	c.dicts = make(map[string]*dict.Dict)
+	return iteratePrefix(c.s.dbDicts, "d:", func(k string, v []byte) error {
+		deleteDict := func() error { return c.s.dicts.Delete(k) }
+		d, err := dict.FromBytes(v)
+		if err != nil {
+			c.report(IssueUnparsable, "d:"+k, err.Error(), deleteDict)
+		} else if _, ok := used[k]; !ok {
+			c.report(IssueOrphaned, "d:"+k, "no segment uses the dictionary", deleteDict)
+		} else {
+			c.dicts[k] = d
+		}
+		return nil
+	})
+}
+
+func (c *checker) checkTrees() error {
+	c.trees = make(map[string]struct{})
+	return iteratePrefix(c.s.dbTrees, "t:", func(k string, v []byte) error {
+		deleteTree := func() error { return c.s.trees.Delete(k) }
+		sk, ok := segmentKeyFromTreeKey(k)
+		if !ok {
+			c.report(IssueUnparsable, "t:"+k, "invalid tree key", deleteTree)
+			return nil
+		}
+		if _, ok = c.segments[sk]; !ok {
+			c.report(IssueOrphaned, "t:"+k, fmt.Sprintf("segment %q does not exist", sk), deleteTree)
+			return nil
+		}
+		d, ok := c.dicts[FromTreeToDictKey(k)]
+		if !ok {
+			d, ok = c.dicts[sk]
+		}
+		if !ok {
+			c.report(IssueDangling, "t:"+k, "dictionary does not exist", deleteTree)
+		} else if _, err := tree.FromBytes(d, v); err != nil {
+			c.report(IssueUnparsable, "t:"+k, err.Error(), deleteTree)
+		} else {
+			c.trees[k] = struct{}{}
+		}
+		return nil
+	})
+}
+
+// checkSegmentTrees finds segment nodes whose trees are missing or can't be read
+func (c *checker) checkSegmentTrees() error {
+	for sk, st := range c.segmentNodes {
+		sk, st, key := sk, st, c.segments[sk]
+		st.WalkPresent(func(depth int, t time.Time) {
+			tk := key.TreeKey(depth, t)
+			if _, ok := c.trees[tk]; ok {
+				return
+			}
+			c.report(IssueDangling, "s:"+sk, fmt.Sprintf("tree %q does not exist or can't be read", tk), func() error {
+				if !st.DeleteNode(depth, t) {
+					return c.s.segments.Save(sk, st)
+				}
+				return c.deleteSegment(sk, key)
+			})
+		})
+	}
+	return nil
+}
+
+// deleteSegment is like Storage.deleteSegmentAndRelatedData,
+// but all the changes are written to disk right away
+func (c *checker) deleteSegment(sk string, key *Key) error {
+	if err := c.s.segments.Delete(sk); err != nil {
+		return err
+	}
+	if err := c.s.dicts.Delete(key.DictKey()); err != nil {
+		return err
+	}
+	for k, v := range key.labels {
+		name := k + ":" + v
+		d, ok := c.dimensions[name]
+		if !ok {
+			continue
+		}
+		d.Delete(dimension.Key(sk))
+		if len(dimension.Union(d)) > 0 {
+			if err := c.s.dimensions.Save(name, d); err != nil {
+				return err
+			}
+			continue
+		}
+		// the last segment with the label is gone
+		delete(c.dimensions, name)
+		if err := c.s.dimensions.Delete(name); err != nil {
+			return err
+		}
+		if err := c.s.labels.Delete(k, v); err != nil {
+			return err
+		}
+		if k == "__name__" {
+			if err := c.s.dicts.Delete(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// iteratePrefix calls cb for all the keys with the prefix, the prefix is trimmed.
+// The value is only valid until cb returns
+func iteratePrefix(db *badger.DB, prefix string, cb func(k string, v []byte) error) error {
+	return db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			k := string(item.Key()[len(prefix):])
+			if err := item.Value(func(v []byte) error { return cb(k, v) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// segmentKeyFromTreeKey is like FromTreeToMainKey, but it validates the tree key
+func segmentKeyFromTreeKey(k string) (string, bool) {
+	i := strings.LastIndex(k, ":")
+	if i < 0 {
+		return "", false
+	}
+	j := strings.LastIndex(k[:i], ":")
+	if j < 0 || !strings.Contains(k[:j], "{") {
+		return "", false
+	}
+	if _, err := strconv.Atoi(k[j+1 : i]); err != nil {
+		return "", false
+	}
+	if _, err := strconv.ParseInt(k[i+1:], 10, 64); err != nil {
+		return "", false
+	}
+	return k[:j], true
+}
+
+func splitLabel(name string) (string, string) {
+	i := strings.Index(name, ":")
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], name[i+1:]
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("storage check", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		st := testing.SimpleUTime(1600000000)
+
+		put := func(key string, st time.Time) {
+			k, err := ParseKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), 1)
+			t.Insert([]byte("a;c"), 2)
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).ToNot(HaveOccurred())
+		}
+
+		update := func(db *badger.DB, cb func(txn *badger.Txn) error) {
+			Expect(db.Update(cb)).ToNot(HaveOccurred())
+		}
+
+		check := func(repair bool) map[string]IssueKind {
+			issues, err := s.Check(repair)
+			Expect(err).ToNot(HaveOccurred())
+			res := make(map[string]IssueKind)
+			for _, issue := range issues {
+				Expect(issue.Repaired).To(Equal(repair))
+				res[issue.Key] = issue.Kind
+			}
+			return res
+		}
+
+		BeforeEach(func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			put("app.cpu{env=prod}", st)
+			put("app.cpu{env=prod}", st.Add(20*time.Second))
+			put("app.cpu{env=dev}", st)
+			// the check reads data from disk
+			Expect(s.Close()).ToNot(HaveOccurred())
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("finds no issues in consistent storage", func() {
+			Expect(check(false)).To(BeEmpty())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("reports and repairs inconsistencies", func() {
+			prod, _ := ParseKey("app.cpu{env=prod}")
+			dev, _ := ParseKey("app.cpu{env=dev}")
+			update(s.dbSegments, func(txn *badger.Txn) error {
+				return txn.Delete([]byte("s:" + dev.SegmentKey()))
+			})
+			update(s.dbTrees, func(txn *badger.Txn) error {
+				return txn.Set([]byte("t:"+prod.TreeKey(0, st)), []byte("garbage"))
+			})
+			update(s.dbTrees, func(txn *badger.Txn) error {
+				return txn.Delete([]byte("t:" + prod.TreeKey(0, st.Add(20*time.Second))))
+			})
+			update(s.db, func(txn *badger.Txn) error {
+				return txn.Delete([]byte("v:env:prod"))
+			})
+
+			issues := map[string]IssueKind{
+				"i:__name__:app.cpu":       IssueDangling,
+				"i:env:dev":                IssueDangling,
+				"v:env:dev":                IssueDangling,
+				"v:env:prod":               IssueMissing,
+				"t:" + dev.TreeKey(0, st):  IssueOrphaned,
+				"t:" + prod.TreeKey(0, st): IssueUnparsable,
+				"s:" + prod.SegmentKey():   IssueDangling,
+			}
+			Expect(check(false)).To(Equal(issues))
+			Expect(check(true)).To(Equal(issues))
+			Expect(check(false)).To(BeEmpty())
+
+			// the data is still available at the coarser level
+			gOut, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(time.Minute), Key: prod})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut.Tree.String()).To(Equal("\"a;b\" 1\n\"a;c\" 2\n"))
+			gOut, err = s.Get(&GetInput{StartTime: st, EndTime: st.Add(time.Minute), Key: dev})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut).To(BeNil())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("removes segments without trees", func() {
+			prod, _ := ParseKey("app.cpu{env=prod}")
+			dev, _ := ParseKey("app.cpu{env=dev}")
+			update(s.dbTrees, func(txn *badger.Txn) error {
+				return txn.Delete([]byte("t:" + dev.TreeKey(0, st)))
+			})
+
+			issues := map[string]IssueKind{
+				"s:" + dev.SegmentKey(): IssueDangling,
+			}
+			Expect(check(false)).To(Equal(issues))
+			Expect(check(true)).To(Equal(issues))
+			Expect(check(false)).To(BeEmpty())
+
+			var values []string
+			s.GetValues("env", func(v string) bool {
+				values = append(values, v)
+				return true
+			})
+			Expect(values).To(Equal([]string{"prod"}))
+			gOut, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(time.Minute), Key: dev})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut).To(BeNil())
+			gOut, err = s.Get(&GetInput{StartTime: st, EndTime: st.Add(time.Minute), Key: prod})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut.Tree.String()).To(Equal("\"a;b\" 2\n\"a;c\" 4\n"))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -173,6 +173,7 @@ func Union(input ...*Dimension) []Key {
 	isExists := map[string]bool{}
 
 	for _, v := range input {
+		v.m.RLock()
 		for _, k := range v.keys {
 			if !isExists[string(k)] {
 				result = append(result, k)
@@ -180,7 +181,46 @@ func Union(input ...*Dimension) []Key {
 
 			isExists[string(k)] = true
 		}
+		v.m.RUnlock()
 	}
 
+	// keeping the result sorted allows to turn it into a dimension, see FromKeys
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i], result[j]) < 0
+	})
+
 	return result
 }
+
+// finds keys that are present in dimension a but not in dimension b
+func AndNot(a, b *Dimension) []Key {
+	a.m.RLock()
+	defer a.m.RUnlock()
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	result := []Key{}
+	j := 0
+	for _, k := range a.keys {
+		for j < len(b.keys) && bytes.Compare(b.keys[j], k) < 0 {
+			j++
+		}
+		if j < len(b.keys) && bytes.Equal(b.keys[j], k) {
+			continue
+		}
+		result = append(result, k)
+	}
+	return result
+}
+
+// FromKeys creates a new dimension from a list of keys, e.g a result of Union or Intersection
+func FromKeys(keys []Key) *Dimension {
+	d := &Dimension{
+		keys: make([]Key, len(keys)),
+	}
+	copy(d.keys, keys)
+	sort.Slice(d.keys, func(i, j int) bool {
+		return bytes.Compare(d.keys[i], d.keys[j]) < 0
+	})
+	return d
+}
@@ -100,4 +100,50 @@ var _ = Describe("dimension", func() {
 			}))
 		})
 	})
+
+	Context("AndNot", func() {
+		It("works", func() {
+			d1 := New()
+			d1.Insert(Key("bar"))
+			d1.Insert(Key("baz"))
+			d1.Insert(Key("foo"))
+
+			d2 := New()
+			d2.Insert(Key("baz"))
+			d2.Insert(Key("qux"))
+
+			Expect(AndNot(d1, d2)).To(Equal([]Key{
+				Key("bar"),
+				Key("foo"),
+			}))
+			Expect(AndNot(d2, d1)).To(Equal([]Key{
+				Key("qux"),
+			}))
+			Expect(AndNot(d1, New())).To(Equal([]Key{
+				Key("bar"),
+				Key("baz"),
+				Key("foo"),
+			}))
+		})
+	})
+
+	Context("FromKeys", func() {
+		It("works with results of Union", func() {
+			d1 := New()
+			d1.Insert(Key("foo"))
+
+			d2 := New()
+			d2.Insert(Key("bar"))
+
+			d3 := New()
+			d3.Insert(Key("bar"))
+			d3.Insert(Key("foo"))
+			d3.Insert(Key("qux"))
+
+			Expect(Intersection(FromKeys(Union(d1, d2)), d3)).To(Equal([]Key{
+				Key("bar"),
+				Key("foo"),
+			}))
+		})
+	})
 })
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/util/serialization"
+	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
+)
+
+// Export archives are gzipped streams of segments. Every segment is its metadata
+// followed by the trees it is made of, serialized with tree.SerializeNoDict
+// along with the time range they cover. Trees of the coarser levels are exported
+// only for the time ranges the finer levels don't have the data for. Import puts
+// the trees for the same time ranges, so they are stored at the same levels, except
+// for the nodes only partially within the exported time range. Trees of applications
+// with average aggregation are exported averaged, their timelines show the averages after import.
+const (
+	exportMagic   = "pyroscope-export"
+	exportVersion = 1
+)
+
+var errInvalidArchive = errors.New("invalid export archive")
+
+type ExportInput struct {
+	StartTime time.Time
+	EndTime   time.Time
+	// AppName limits the data exported to a single application, all applications by default
+	AppName string
+}
+
+// Export writes profiling data of all the applications and label sets in the time range to w.
+// It returns the number of exported segments.
+func (s *Storage) Export(w io.Writer, ei *ExportInput) (int, error) {
+	gw := gzip.NewWriter(w)
+	bw := bufio.NewWriter(gw)
+	bw.WriteString(exportMagic)
+	varint.Write(bw, exportVersion)
+
+	et := ei.EndTime
+	if et.IsZero() {
+		et = time.Now()
+	}
+	var n int
+	err := s.iterateOverAllSegments(func(sk *Key, st *segment.Segment) error {
+		if ei.AppName != "" && sk.AppName() != ei.AppName {
+			return nil
+		}
+		var b bytes.Buffer
+		var count int
+		var err error
+		st.Walk(ei.StartTime, et, func(depth int, _, writes uint64, t time.Time, r *big.Rat) {
+			if err != nil {
+				return
+			}
+			tk := sk.TreeKey(depth, t)
+			var res interface{}
+			if res, err = s.trees.Get(tk); err != nil {
+				err = fmt.Errorf("trees cache for %v: %v", tk, err)
+				return
+			}
+			if res == nil {
+				return
+			}
+			if st.AggregationType() == "average" && writes > 0 {
+				r = new(big.Rat).Mul(r, big.NewRat(1, int64(writes)))
+			}
+			start, end := nodeTimeRange(st, depth, t, ei.StartTime, et)
+			vw := varint.NewWriter()
+			vw.Write(&b, uint64(start.Unix()))
+			vw.Write(&b, uint64(end.Unix()))
+			var tb bytes.Buffer
+			if err = res.(*tree.Tree).Clone(r).SerializeNoDict(s.config.MaxNodesSerialization, &tb); err != nil {
+				return
+			}
+			vw.Write(&b, uint64(tb.Len()))
+			b.Write(tb.Bytes())
+			count++
+		})
+		if err != nil || count == 0 {
+			return err
+		}
+
+		varint.Write(bw, 1)
+		serialization.WriteMetadata(bw, map[string]interface{}{
+			"key":             sk.Normalized(),
+			"spyName":         st.SpyName(),
+			"sampleRate":      st.SampleRate(),
+			"units":           st.Units(),
+			"aggregationType": st.AggregationType(),
+			"resolution":      int64(st.Resolution() / time.Second),
+		})
+		varint.Write(bw, uint64(count))
+		b.WriteTo(bw)
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	varint.Write(bw, 0)
+	if err = bw.Flush(); err != nil {
+		return n, err
+	}
+	return n, gw.Close()
+}
+
+// nodeTimeRange returns the part of the segment tree node time range within st and et,
+// aligned to the segment resolution the same way segment.Get does it
+func nodeTimeRange(s *segment.Segment, depth int, t, st, et time.Time) (time.Time, time.Time) {
+	resolution := s.Resolution()
+	d := resolution
+	for i := 0; i < depth; i++ {
+		d *= time.Duration(s.Multiplier())
+	}
+	start, end := t, t.Add(d)
+	if st = st.Truncate(resolution); st.After(start) {
+		start = st
+	}
+	if et2 := et.Truncate(resolution); et2.Before(et) {
+		et = et2.Add(resolution)
+	}
+	if et.Before(end) {
+		end = et
+	}
+	return start, end
+}
+
+// Import puts the profiling data from an archive created with Export.
+// It returns the number of imported segments. Data older than the retention
+// period is skipped.
+func (s *Storage) Import(r io.Reader) (int, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	defer gr.Close()
+	br := bufio.NewReader(gr)
+
+	magic := make([]byte, len(exportMagic))
+	if _, err = io.ReadFull(br, magic); err != nil || string(magic) != exportMagic {
+		return 0, errInvalidArchive
+	}
+	version, err := varint.Read(br)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	if version != exportVersion {
+		return 0, fmt.Errorf("%w: unknown version %d", errInvalidArchive, version)
+	}
+
+	var n int
+	for {
+		next, err := varint.Read(br)
+		if err != nil {
+			return n, fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		if next == 0 {
+			return n, nil
+		}
+		if err = s.importSegment(br); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func (s *Storage) importSegment(br *bufio.Reader) error {
+	metadata, err := serialization.ReadMetadata(br)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	k, _ := metadata["key"].(string)
+	key, err := ParseKey(k)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	po := PutInput{Key: key}
+	po.SpyName, _ = metadata["spyName"].(string)
+	po.Units, _ = metadata["units"].(string)
+	po.AggregationType, _ = metadata["aggregationType"].(string)
+	if v, ok := metadata["sampleRate"].(float64); ok {
+		po.SampleRate = uint32(v)
+	}
+	if v, ok := metadata["resolution"].(float64); ok {
+		po.Resolution = time.Duration(v) * time.Second
+	}
+
+	count, err := varint.Read(br)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	var skipped int
+	for i := uint64(0); i < count; i++ {
+		var v [3]uint64
+		for j := range v {
+			if v[j], err = varint.Read(br); err != nil {
+				return fmt.Errorf("%w: %v", errInvalidArchive, err)
+			}
+		}
+		po.StartTime = time.Unix(int64(v[0]), 0)
+		po.EndTime = time.Unix(int64(v[1]), 0)
+		// the length is not trusted, the buffer only grows as the data is read
+		if int64(v[2]) < 0 {
+			return fmt.Errorf("%w: invalid tree size %d", errInvalidArchive, v[2])
+		}
+		var b bytes.Buffer
+		if _, err = io.CopyN(&b, br, int64(v[2])); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		if po.Val, err = tree.DeserializeNoDict(&b); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		// downsampled data is older than the per-level retention thresholds
+		switch err = s.putBefore(&po, time.Time{}); {
+		case errors.Is(err, ErrRetention):
+			skipped++
+		case err != nil:
+			return err
+		}
+	}
+	if skipped > 0 {
+		logrus.WithFields(logrus.Fields{
+			"key":     k,
+			"skipped": skipped,
+		}).Warn("skipped data older than the retention period")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+	"github.com/pyroscope-io/pyroscope/pkg/util/serialization"
+	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
+)
+
+var _ = Describe("export", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		var dst *Storage
+
+		put := func(key string, st time.Time, aggregationType string, v uint64) {
+			k, err := ParseKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), v)
+			t.Insert([]byte("a;c"), 2*v)
+			Expect(s.Put(&PutInput{
+				StartTime:       st,
+				EndTime:         st.Add(10 * time.Second),
+				Key:             k,
+				Val:             t,
+				SpyName:         "testspy",
+				SampleRate:      100,
+				Units:           "samples",
+				AggregationType: aggregationType,
+			})).ToNot(HaveOccurred())
+		}
+
+		get := func(s *Storage, key string, st, et time.Time) *GetOutput {
+			k, err := ParseKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			gOut, err := s.Get(&GetInput{StartTime: st, EndTime: et, Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			return gOut
+		}
+
+		BeforeEach(func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("exports data to another storage", func() {
+			st := testing.SimpleTime(0)
+			put("app.cpu{env=prod}", st, "sum", 1)
+			put("app.cpu{env=prod}", st.Add(10*time.Second), "sum", 2)
+			put("app.cpu{env=dev}", st.Add(10*time.Second), "sum", 3)
+			put("app.goroutines{}", st, "average", 1)
+			put("app.goroutines{}", st, "average", 3)
+			put("other.cpu{}", st.Add(time.Hour), "sum", 1)
+
+			var buf bytes.Buffer
+			n, err := s.Export(&buf, &ExportInput{EndTime: st.Add(time.Minute)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+
+			testing.TmpDir(func(path string) {
+				dstCfg := (*cfg).Server
+				dstCfg.StoragePath = path
+				dst, err = New(&dstCfg)
+				Expect(err).ToNot(HaveOccurred())
+				n, err = dst.Import(&buf)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(3))
+
+				for _, key := range []string{"app.cpu{env=prod}", "app.cpu{env=dev}", "app.goroutines{}"} {
+					et := st.Add(time.Minute)
+					expected := get(s, key, st, et)
+					actual := get(dst, key, st, et)
+					Expect(actual.Tree.String()).To(Equal(expected.Tree.String()))
+					Expect(actual.SpyName).To(Equal("testspy"))
+					Expect(actual.SampleRate).To(Equal(uint32(100)))
+					Expect(actual.Units).To(Equal("samples"))
+				}
+				for _, key := range []string{"app.cpu{env=prod}", "app.cpu{env=dev}"} {
+					et := st.Add(time.Minute)
+					Expect(get(dst, key, st, et).Timeline.Samples).To(Equal(get(s, key, st, et).Timeline.Samples))
+				}
+				Expect(get(dst, "app.goroutines{}", st, st.Add(time.Minute)).Tree.String()).To(Equal("\"a;b\" 2\n\"a;c\" 4\n"))
+				Expect(get(dst, "other.cpu{}", st, st.Add(2*time.Hour))).To(BeNil())
+				Expect(dst.Close()).ToNot(HaveOccurred())
+			})
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("imports downsampled data at the coarser level", func() {
+			st := time.Now().Add(-48 * time.Hour).Truncate(100 * time.Second)
+			put("app.cpu{}", st, "sum", 1)
+			put("app.cpu{}", st.Add(10*time.Second), "sum", 2)
+			(*cfg).Server.RetentionLevel0 = 24 * time.Hour
+			Expect(s.deleteDataByRetention()).ToNot(HaveOccurred())
+
+			var buf bytes.Buffer
+			n, err := s.Export(&buf, &ExportInput{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(1))
+
+			testing.TmpDir(func(path string) {
+				dstCfg := (*cfg).Server
+				dstCfg.StoragePath = path
+				dst, err = New(&dstCfg)
+				Expect(err).ToNot(HaveOccurred())
+				n, err = dst.Import(&buf)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(1))
+
+				key, _ := ParseKey("app.cpu{}")
+				stInt, err := dst.segments.Get(key.SegmentKey())
+				Expect(err).ToNot(HaveOccurred())
+				depths := []int{}
+				stInt.(*segment.Segment).Get(st, st.Add(10*time.Second), func(depth int, _, _ uint64, _ time.Time, _ *big.Rat) {
+					depths = append(depths, depth)
+				})
+				Expect(depths).To(Equal([]int{1}))
+				et := st.Add(100 * time.Second)
+				Expect(get(dst, "app.cpu{}", st, et).Tree.String()).To(Equal(get(s, "app.cpu{}", st, et).Tree.String()))
+				Expect(dst.Close()).ToNot(HaveOccurred())
+			})
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("exports a single application", func() {
+			st := testing.SimpleTime(0)
+			put("app.cpu{}", st, "sum", 1)
+			put("other.cpu{}", st, "sum", 1)
+			var buf bytes.Buffer
+			n, err := s.Export(&buf, &ExportInput{StartTime: st, EndTime: st.Add(time.Minute), AppName: "other.cpu"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(1))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("rejects invalid archives", func() {
+			_, err := s.Import(bytes.NewReader([]byte("foo")))
+			Expect(err).To(MatchError(errInvalidArchive))
+
+			By("not trusting tree sizes")
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			gw.Write([]byte(exportMagic))
+			varint.Write(gw, exportVersion)
+			varint.Write(gw, 1)
+			serialization.WriteMetadata(gw, map[string]interface{}{"key": "app.cpu{}"})
+			for _, v := range []uint64{1, 1600000000, 1600000010, 1 << 62} {
+				varint.Write(gw, v)
+			}
+			Expect(gw.Close()).ToNot(HaveOccurred())
+			_, err = s.Import(&buf)
+			Expect(err).To(MatchError(errInvalidArchive))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
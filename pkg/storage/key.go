@@ -95,6 +95,20 @@ func (p *parser) tagValueParserCase(r int32, k *Key) {
 	}
 }
 
+// Add sets the label key to the given value, overriding the existing one.
+func (k *Key) Add(key, value string) {
+	k.labels[key] = value
+}
+
+// Clone returns a deep copy of the key.
+func (k *Key) Clone() *Key {
+	newMap := make(map[string]string, len(k.labels))
+	for k, v := range k.labels {
+		newMap[k] = v
+	}
+	return &Key{labels: newMap}
+}
+
 func (k *Key) SegmentKey() string {
 	return k.Normalized()
 }
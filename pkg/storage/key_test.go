@@ -46,5 +46,17 @@ var _ = Describe("storage package", func() {
 				Expect(k.Normalized()).To(Equal("foo{bar=2,baz=1}"))
 			})
 		})
+
+		Context("Clone", func() {
+			It("doesn't share labels with the original key", func() {
+				k, err := ParseKey("foo{bar=1}")
+				Expect(err).ToNot(HaveOccurred())
+				k2 := k.Clone()
+				k2.Add("__name__", "foo.cpu")
+				k2.Add("baz", "2")
+				Expect(k.Normalized()).To(Equal("foo{bar=1}"))
+				Expect(k2.Normalized()).To(Equal("foo.cpu{bar=1,baz=2}"))
+			})
+		})
 	})
 })
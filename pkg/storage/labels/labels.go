@@ -44,6 +44,27 @@ func (ll *Labels) Put(key, val string) {
 	// }
 }
 
+// Delete removes the label value, the label key is removed
+// together with the last value of it
+func (ll *Labels) Delete(key, val string) error {
+	return ll.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete([]byte("v:" + key + ":" + val)); err != nil {
+			return err
+		}
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("v:" + key + ":")
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		it.Rewind()
+		hasValues := it.Valid()
+		it.Close()
+		if hasValues {
+			return nil
+		}
+		return txn.Delete([]byte("l:" + key))
+	})
+}
+
 func (ll *Labels) GetKeys(cb func(k string) bool) {
 	err := ll.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
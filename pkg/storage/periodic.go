@@ -77,12 +77,41 @@ func (s *Storage) writeBackTask() {
 	})
 }
 
+// checkpointTask replaces writeBackTask when write-ahead log is enabled.
+// Puts wait for checkpoints, so they run much less often than write-backs
+func (s *Storage) checkpointTask() {
+	metrics.Timing("wal_checkpoint_timer", func() {
+		metrics.Count("wal_checkpoint_count", 1)
+		if err := s.checkpoint(); err != nil {
+			logrus.WithError(err).Error("write-ahead log checkpoint failed")
+		}
+	})
+}
+
+func (s *Storage) walSyncTask() {
+	if err := s.wal.Sync(); err != nil {
+		logrus.WithError(err).Error("write-ahead log sync failed")
+	}
+}
+
 func (s *Storage) retentionTask() {
 	logrus.Debug("starting retention task")
 	metrics.Timing("retention_timer", func() {
 		metrics.Count("retention_count", 1)
-		if err := s.DeleteDataBefore(s.lifetimeBasedRetentionThreshold()); err != nil {
+		if err := s.deleteDataByRetention(); err != nil {
 			logrus.WithError(err).Warn("retention task failed")
 		}
 	})
 }
+
+func (s *Storage) sizeRetentionTask() {
+	logrus.Debug("starting size-based retention task")
+	metrics.Timing("retention_size_timer", func() {
+		metrics.Count("retention_size_count", 1)
+		reclaimed, err := s.enforceSizeLimit()
+		metrics.Count("retention_size_reclaimed_bytes", reclaimed)
+		if err != nil {
+			logrus.WithError(err).Warn("size-based retention task failed")
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
+)
+
+// MatchOp is a label matching operator, the semantics follow PromQL
+type MatchOp int
+
+const (
+	OpEqual         MatchOp = iota // =
+	OpNotEqual                     // !=
+	OpEqualRegex                   // =~
+	OpNotEqualRegex                // !~
+)
+
+var matchOpStrings = map[MatchOp]string{
+	OpEqual:         "=",
+	OpNotEqual:      "!=",
+	OpEqualRegex:    "=~",
+	OpNotEqualRegex: "!~",
+}
+
+func (op MatchOp) String() string {
+	return matchOpStrings[op]
+}
+
+type TagMatcher struct {
+	Key   string
+	Value string
+	Op    MatchOp
+
+	r *regexp.Regexp
+}
+
+// Match reports whether label value v satisfies the matcher
+func (m *TagMatcher) Match(v string) bool {
+	switch m.Op {
+	case OpEqual:
+		return v == m.Value
+	case OpNotEqual:
+		return v != m.Value
+	case OpEqualRegex:
+		return m.r.MatchString(v)
+	case OpNotEqualRegex:
+		return !m.r.MatchString(v)
+	}
+	return false
+}
+
+// Query is an application name along with a list of label matchers, e.g:
+//
+//	app.cpu{region=~"us-.*",host!="canary"}
+type Query struct {
+	AppName  string
+	Matchers []*TagMatcher
+}
+
+var (
+	errEmptyAppName    = errors.New("application name is empty")
+	errUnclosedBracket = errors.New("missing closing bracket")
+)
+
+// ParseQuery parses application name and label matchers. Values can be
+// either quoted or not, regular expressions are fully anchored.
+func ParseQuery(s string) (*Query, error) {
+	s = strings.TrimSpace(s)
+	q := &Query{}
+	i := strings.IndexByte(s, '{')
+	if i == -1 {
+		q.AppName = s
+	} else {
+		q.AppName = strings.TrimSpace(s[:i])
+		if !strings.HasSuffix(s, "}") {
+			return nil, errUnclosedBracket
+		}
+		var err error
+		if q.Matchers, err = parseMatchers(s[i+1 : len(s)-1]); err != nil {
+			return nil, err
+		}
+	}
+	if q.AppName == "" {
+		return nil, errEmptyAppName
+	}
+	return q, nil
+}
+
+func parseMatchers(s string) ([]*TagMatcher, error) {
+	var matchers []*TagMatcher
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return matchers, nil
+		}
+
+		i := strings.IndexAny(s, "=!")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid matcher %q", s)
+		}
+		m := &TagMatcher{Key: strings.TrimSpace(s[:i])}
+		if m.Key == "" {
+			return nil, fmt.Errorf("empty label name in %q", s)
+		}
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "=~"):
+			m.Op = OpEqualRegex
+		case strings.HasPrefix(s, "!~"):
+			m.Op = OpNotEqualRegex
+		case strings.HasPrefix(s, "!="):
+			m.Op = OpNotEqual
+		case strings.HasPrefix(s, "="):
+			m.Op = OpEqual
+		default:
+			return nil, fmt.Errorf("invalid operator in %q", s)
+		}
+		s = strings.TrimSpace(s[len(m.Op.String()):])
+
+		var err error
+		if m.Value, s, err = parseValue(s); err != nil {
+			return nil, err
+		}
+		if m.Op == OpEqualRegex || m.Op == OpNotEqualRegex {
+			if m.r, err = regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+				return nil, fmt.Errorf("invalid regular expression for %q: %v", m.Key, err)
+			}
+		}
+		matchers = append(matchers, m)
+
+		s = strings.TrimSpace(s)
+		if s != "" {
+			if s[0] != ',' {
+				return nil, fmt.Errorf("expected comma before %q", s)
+			}
+			s = s[1:]
+		}
+	}
+}
+
+// parseValue returns matcher value and the rest of the input
+func parseValue(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		i := strings.IndexByte(s, ',')
+		if i == -1 {
+			i = len(s)
+		}
+		return strings.TrimSpace(s[:i]), s[i:], nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid value %s: %v", s[:i+1], err)
+			}
+			return v, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted value %s", s)
+}
+
+// execQuery resolves the query against the label index and returns matching segment keys
+func (s *Storage) execQuery(q *Query) []dimension.Key {
+	app, ok := s.lookupDimensionKV("__name__", q.AppName)
+	if !ok {
+		return nil
+	}
+
+	candidates := []*dimension.Dimension{app}
+	var exclusions []*dimension.Dimension
+	for _, m := range q.Matchers {
+		switch m.Op {
+		case OpEqual:
+			d, ok := s.lookupDimensionKV(m.Key, m.Value)
+			if !ok {
+				return nil
+			}
+			candidates = append(candidates, d)
+		case OpNotEqual:
+			if d, ok := s.lookupDimensionKV(m.Key, m.Value); ok {
+				exclusions = append(exclusions, d)
+			}
+		case OpEqualRegex:
+			candidates = append(candidates, s.lookupDimensionByRegex(m))
+		case OpNotEqualRegex:
+			exclusions = append(exclusions, s.lookupDimensionByRegex(m))
+		}
+	}
+
+	result := dimension.Intersection(candidates...)
+	if len(exclusions) > 0 {
+		result = dimension.AndNot(
+			dimension.FromKeys(result),
+			dimension.FromKeys(dimension.Union(exclusions...)))
+	}
+	return result
+}
+
+func (s *Storage) lookupDimensionKV(k, v string) (*dimension.Dimension, bool) {
+	key := k + ":" + v
+	res, err := s.dimensions.Get(key)
+	if err != nil {
+		logrus.Errorf("dimensions cache for %v: %v", key, err)
+		return nil, false
+	}
+	if res == nil {
+		return nil, false
+	}
+	return res.(*dimension.Dimension), true
+}
+
+// lookupDimensionByRegex returns a union of dimensions for all values of the
+// label matching the regular expression
+func (s *Storage) lookupDimensionByRegex(m *TagMatcher) *dimension.Dimension {
+	var values []string
+	s.labels.GetValues(m.Key, func(v string) bool {
+		if m.r.MatchString(v) {
+			values = append(values, v)
+		}
+		return true
+	})
+
+	var dimensions []*dimension.Dimension
+	for _, v := range values {
+		if d, ok := s.lookupDimensionKV(m.Key, v); ok {
+			dimensions = append(dimensions, d)
+		}
+	}
+	return dimension.FromKeys(dimension.Union(dimensions...))
+}
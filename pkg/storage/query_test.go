@@ -0,0 +1,131 @@
+package storage
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("ParseQuery", func() {
+	It("parses app name without matchers", func() {
+		q, err := ParseQuery(" app.cpu ")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.AppName).To(Equal("app.cpu"))
+		Expect(q.Matchers).To(BeEmpty())
+
+		q, err = ParseQuery("app.cpu{}")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.AppName).To(Equal("app.cpu"))
+		Expect(q.Matchers).To(BeEmpty())
+	})
+
+	It("parses all kinds of matchers", func() {
+		q, err := ParseQuery(`app.cpu{region=~"us-.*", host!="canary",env=prod, dc !~ "eu\"1"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.AppName).To(Equal("app.cpu"))
+		Expect(q.Matchers).To(HaveLen(4))
+
+		Expect(q.Matchers[0].Key).To(Equal("region"))
+		Expect(q.Matchers[0].Op).To(Equal(OpEqualRegex))
+		Expect(q.Matchers[0].Value).To(Equal("us-.*"))
+		Expect(q.Matchers[1].Key).To(Equal("host"))
+		Expect(q.Matchers[1].Op).To(Equal(OpNotEqual))
+		Expect(q.Matchers[1].Value).To(Equal("canary"))
+		Expect(q.Matchers[2].Key).To(Equal("env"))
+		Expect(q.Matchers[2].Op).To(Equal(OpEqual))
+		Expect(q.Matchers[2].Value).To(Equal("prod"))
+		Expect(q.Matchers[3].Key).To(Equal("dc"))
+		Expect(q.Matchers[3].Op).To(Equal(OpNotEqualRegex))
+		Expect(q.Matchers[3].Value).To(Equal(`eu"1`))
+	})
+
+	It("anchors regular expressions", func() {
+		q, err := ParseQuery(`app{region=~"us-.*"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.Matchers[0].Match("us-east")).To(BeTrue())
+		Expect(q.Matchers[0].Match("eu-us-east")).To(BeFalse())
+	})
+
+	It("returns errors for invalid queries", func() {
+		for _, s := range []string{
+			"",
+			"{foo=bar}",
+			"app{foo=bar",
+			"app{foo}",
+			"app{=bar}",
+			`app{foo="bar}`,
+			`app{foo="bar" baz="qux"}`,
+			`app{foo=~"("}`,
+		} {
+			_, err := ParseQuery(s)
+			Expect(err).To(HaveOccurred(), s)
+		}
+	})
+})
+
+var _ = Describe("querying", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("resolves label matchers", func() {
+			st := testing.SimpleTime(10)
+			et := testing.SimpleTime(19)
+			for _, k := range []string{
+				"app.cpu{region=us-east,host=a}",
+				"app.cpu{region=us-west,host=canary}",
+				"app.cpu{region=eu-west,host=b}",
+				"app.cpu{region=us-west}",
+				"other.cpu{region=us-east,host=a}",
+			} {
+				key, _ := ParseKey(k)
+				t := tree.New()
+				t.Insert([]byte("a;b"), uint64(1))
+				Expect(s.Put(&PutInput{
+					StartTime:  st,
+					EndTime:    et,
+					Key:        key,
+					Val:        t,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})).ToNot(HaveOccurred())
+			}
+
+			samples := func(query string) uint64 {
+				q, err := ParseQuery(query)
+				Expect(err).ToNot(HaveOccurred())
+				gOut, err := s.Get(&GetInput{StartTime: st, EndTime: et, Query: q})
+				Expect(err).ToNot(HaveOccurred())
+				if gOut == nil {
+					return 0
+				}
+				return gOut.Tree.Samples()
+			}
+
+			Expect(samples("app.cpu")).To(Equal(uint64(4)))
+			Expect(samples("app.cpu{region=us-west}")).To(Equal(uint64(2)))
+			Expect(samples(`app.cpu{region=~"us-.*"}`)).To(Equal(uint64(3)))
+			Expect(samples(`app.cpu{region=~"us-.*",host!="canary"}`)).To(Equal(uint64(2)))
+			Expect(samples(`app.cpu{region!~"us-.*"}`)).To(Equal(uint64(1)))
+			Expect(samples(`app.cpu{region=~"ap-.*"}`)).To(Equal(uint64(0)))
+			Expect(samples(`app.cpu{region="nowhere"}`)).To(Equal(uint64(0)))
+			Expect(samples(`missing.cpu`)).To(Equal(uint64(0)))
+
+			q, _ := ParseQuery(`app.cpu{region=~"us-.*"}`)
+			var hosts []string
+			s.GetValuesByQuery("host", q, func(v string) bool {
+				hosts = append(hosts, v)
+				return true
+			})
+			Expect(hosts).To(ConsistOf("a", "canary"))
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("ingest resolution", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		put := func(key *Key, st time.Time, d, resolution time.Duration) error {
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			t.Insert([]byte("a;c"), uint64(2))
+			return s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(d),
+				Key:        key,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+				Resolution: resolution,
+			})
+		}
+
+		It("creates new segments with the requested resolution", func() {
+			st := testing.SimpleTime(0)
+			key, _ := ParseKey("app.cpu")
+			Expect(put(key, st, time.Minute, time.Minute)).ToNot(HaveOccurred())
+
+			By("ignoring the resolution of writes to existing segments")
+			Expect(put(key, st.Add(time.Minute), 10*time.Second, 10*time.Second)).ToNot(HaveOccurred())
+
+			stInt, err := s.segments.Get(key.SegmentKey())
+			Expect(err).ToNot(HaveOccurred())
+			seg := stInt.(*segment.Segment)
+			Expect(seg.Resolution()).To(Equal(time.Minute))
+			depths := []int{}
+			seg.Get(st, st.Add(2*time.Minute), func(depth int, _, _ uint64, _ time.Time, _ *big.Rat) {
+				depths = append(depths, depth)
+			})
+			Expect(depths).To(Equal([]int{0, 0}))
+
+			gOut, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(2 * time.Minute), Key: key})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut.Tree.Samples()).To(Equal(uint64(6)))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("rejects resolutions that are not a whole number of seconds", func() {
+			key, _ := ParseKey("app.cpu")
+			err := put(key, testing.SimpleTime(0), 10*time.Second, 1500*time.Millisecond)
+			Expect(err).To(Equal(ErrInvalidResolution))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
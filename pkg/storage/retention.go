@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+	"github.com/pyroscope-io/pyroscope/pkg/util/metrics"
+)
+
+type retentionRule struct {
+	appNameGlob string
+	matchers    []*TagMatcher
+	period      time.Duration
+}
+
+func newRetentionRules(rules []config.RetentionRule) ([]*retentionRule, error) {
+	res := make([]*retentionRule, 0, len(rules))
+	for i, r := range rules {
+		if r.ApplicationName == "" && r.Selector == "" {
+			return nil, fmt.Errorf("retention rule #%d: either application name or selector must be set", i)
+		}
+		if _, err := path.Match(r.ApplicationName, ""); err != nil {
+			return nil, fmt.Errorf("retention rule #%d: invalid application name pattern: %v", i, err)
+		}
+		rr := &retentionRule{
+			appNameGlob: r.ApplicationName,
+			period:      r.Retention,
+		}
+		if r.Selector != "" {
+			selector := strings.TrimSpace(r.Selector)
+			selector = strings.TrimPrefix(selector, "{")
+			selector = strings.TrimSuffix(selector, "}")
+			var err error
+			if rr.matchers, err = parseMatchers(selector); err != nil {
+				return nil, fmt.Errorf("retention rule #%d: invalid selector: %v", i, err)
+			}
+		}
+		res = append(res, rr)
+	}
+	return res, nil
+}
+
+func (rr *retentionRule) matches(k *Key) bool {
+	if rr.appNameGlob != "" {
+		if ok, _ := path.Match(rr.appNameGlob, k.AppName()); !ok {
+			return false
+		}
+	}
+	for _, m := range rr.matchers {
+		// missing labels are treated as empty ones, same as in PromQL
+		if !m.Match(k.labels[m.Key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// retentionPeriod returns the retention period of the first matching rule,
+// or the global one if there are no matching rules
+func (s *Storage) retentionPeriod(k *Key) time.Duration {
+	for _, rr := range s.retentionRules {
+		if rr.matches(k) {
+			return rr.period
+		}
+	}
+	return s.config.Retention
+}
+
+// retentionThreshold returns zero time if the data should be stored forever
+func (s *Storage) retentionThreshold(k *Key) time.Time {
+	var t time.Time
+	if p := s.retentionPeriod(k); p != 0 {
+		t = time.Now().Add(-1 * p)
+	}
+	return t
+}
+
+func (s *Storage) retentionEnabled() bool {
+	return s.config.Retention > 0 || len(s.retentionRules) > 0 || s.levelThresholds() != nil
+}
+
+// levelThresholds returns retention thresholds for segment tree levels
+// (see segment.DeleteLevelsBefore), or nil if tiered retention is disabled
+func (s *Storage) levelThresholds() []time.Time {
+	periods := []time.Duration{
+		s.config.RetentionLevel0,
+		s.config.RetentionLevel1,
+		s.config.RetentionLevel2,
+	}
+	var res []time.Time
+	now := time.Now()
+	for i, p := range periods {
+		if p > 0 {
+			if res == nil {
+				res = make([]time.Time, len(periods))
+			}
+			res[i] = now.Add(-1 * p)
+		}
+	}
+	return res
+}
+
+// levelRetentionThreshold returns the time before which data is only stored
+// at coarser resolution, and therefore can not be written anymore.
+// Zero time is returned if tiered retention is disabled.
+func (s *Storage) levelRetentionThreshold() time.Time {
+	var t time.Time
+	for _, lt := range s.levelThresholds() {
+		if lt.After(t) {
+			t = lt
+		}
+	}
+	return t
+}
+
+// deleteDataByRetention removes data older than the retention threshold
+// of each segment (see retentionThreshold) and downsamples old data
+// according to the per-level retention settings
+func (s *Storage) deleteDataByRetention() error {
+	levelThresholds := s.levelThresholds()
+	return s.iterateOverAllSegments(func(sk *Key, st *segment.Segment) error {
+		if threshold := s.retentionThreshold(sk); !threshold.IsZero() {
+			if _, err := s.deleteSegmentDataBefore(sk, st, threshold); err != nil {
+				return err
+			}
+		}
+		if levelThresholds != nil {
+			return s.deleteSegmentLevelsBefore(sk, st, levelThresholds)
+		}
+		return nil
+	})
+}
+
+func (s *Storage) deleteSegmentLevelsBefore(sk *Key, st *segment.Segment, thresholds []time.Time) error {
+	var err error
+	var deleted bool
+	st.DeleteLevelsBefore(thresholds, func(depth int, t time.Time) {
+		deleted = true
+		if delErr := s.trees.Delete(sk.TreeKey(depth, t)); delErr != nil {
+			err = delErr
+		}
+	})
+	if deleted {
+		s.segments.Put(sk.SegmentKey(), st)
+	}
+	return err
+}
+
+// SizeRetentionLowWatermark is the share of MaxStorageSize the storage is
+// cleaned down to once the limit is exceeded. It prevents the size-based
+// retention from deleting data on every run when usage hovers around the limit.
+var SizeRetentionLowWatermark = 0.9
+
+// maxSizeRetentionSteps limits the number of passes over all segments per run
+const maxSizeRetentionSteps = 100
+
+func (s *Storage) totalDiskUsage() bytesize.ByteSize {
+	var total bytesize.ByteSize
+	for _, v := range s.DiskUsage() {
+		total += v
+	}
+	return total
+}
+
+// oldestDataTime returns zero time if there is no data
+func (s *Storage) oldestDataTime() (time.Time, error) {
+	var oldest time.Time
+	err := s.iterateOverAllSegments(func(_ *Key, st *segment.Segment) error {
+		t := st.StartTime()
+		if !t.IsZero() && (oldest.IsZero() || t.Before(oldest)) {
+			oldest = t
+		}
+		return nil
+	})
+	return oldest, err
+}
+
+// pendingReclaim is the space deleted by a size-based retention run
+type pendingReclaim struct {
+	size uint64
+	time time.Time
+}
+
+// pendingReclaimedSize returns the space deleted by the previous runs that is
+// still expected to be counted in disk usage. Badger frees space with value log
+// garbage collection and compactions only, deletions are considered reclaimed
+// once garbage collection had a chance to run after them.
+func (s *Storage) pendingReclaimedSize(now time.Time) uint64 {
+	var total uint64
+	pending := s.sizeRetentionPending[:0]
+	for _, p := range s.sizeRetentionPending {
+		if now.Sub(p.time) <= gcInterval {
+			pending = append(pending, p)
+			total += p.size
+		}
+	}
+	s.sizeRetentionPending = pending
+	return total
+}
+
+// enforceSizeLimit deletes the oldest data across all applications until
+// the estimated amount of reclaimed space brings the usage under the low watermark.
+// It returns the estimated number of bytes reclaimed.
+func (s *Storage) enforceSizeLimit() (uint64, error) {
+	now := time.Now()
+	usage := s.totalDiskUsage()
+	metrics.Gauge("retention_size_disk_usage_bytes", int64(usage))
+	if pending := bytesize.ByteSize(s.pendingReclaimedSize(now)); pending < usage {
+		usage -= pending
+	} else {
+		usage = 0
+	}
+	if usage <= s.config.MaxStorageSize {
+		return 0, nil
+	}
+
+	target := uint64(usage) - uint64(float64(s.config.MaxStorageSize)*SizeRetentionLowWatermark)
+	oldest, err := s.oldestDataTime()
+	if err != nil || oldest.IsZero() {
+		return 0, err
+	}
+
+	step := now.Sub(oldest) / maxSizeRetentionSteps
+	if step < time.Hour {
+		step = time.Hour
+	}
+
+	var reclaimed uint64
+	for threshold := oldest.Add(step); reclaimed < target; threshold = threshold.Add(step) {
+		if threshold.After(now) {
+			threshold = now
+		}
+		err = s.iterateOverAllSegments(func(sk *Key, st *segment.Segment) error {
+			size, err := s.deleteSegmentDataBefore(sk, st, threshold)
+			reclaimed += size
+			return err
+		})
+		if err != nil || threshold.Equal(now) {
+			break
+		}
+	}
+
+	if reclaimed > 0 {
+		s.sizeRetentionPending = append(s.sizeRetentionPending, pendingReclaim{size: reclaimed, time: now})
+	}
+	logrus.WithFields(logrus.Fields{
+		"usage":     usage,
+		"reclaimed": bytesize.ByteSize(reclaimed),
+	}).Info("storage size limit exceeded, deleted the oldest data")
+	return reclaimed, err
+}
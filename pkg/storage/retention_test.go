@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+var _ = Describe("retention rules", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		put := func(name string, st time.Time) error {
+			key, _ := ParseKey(name)
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			return s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        key,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})
+		}
+
+		get := func(name string, st time.Time) *GetOutput {
+			key, _ := ParseKey(name)
+			gOut, err := s.Get(&GetInput{
+				StartTime: st,
+				EndTime:   st.Add(10 * time.Second),
+				Key:       key,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return gOut
+		}
+
+		Context("with rules configured", func() {
+			JustBeforeEach(func() {
+				(*cfg).Server.Retention = 24 * time.Hour
+				(*cfg).Server.RetentionRules = []config.RetentionRule{
+					{ApplicationName: "debug.*", Retention: time.Hour},
+					{Selector: `{env="prod"}`, Retention: 0},
+				}
+				var err error
+				s, err = New(&(*cfg).Server)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("rejects writes older than the matching rule", func() {
+				now := time.Now()
+				Expect(put("debug.cpu", now.Add(-2*time.Hour))).To(Equal(ErrRetention))
+				Expect(put("debug.cpu", now.Add(-30*time.Minute))).ToNot(HaveOccurred())
+				Expect(put("app.cpu{env=prod}", now.Add(-48*time.Hour))).ToNot(HaveOccurred())
+				Expect(put("app.cpu{env=staging}", now.Add(-48*time.Hour))).To(Equal(ErrRetention))
+				Expect(put("app.cpu", now.Add(-10*time.Hour))).ToNot(HaveOccurred())
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("periodic retention task", func() {
+			JustBeforeEach(func() {
+				var err error
+				s, err = New(&(*cfg).Server)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("deletes data according to the matching rule", func() {
+				st := time.Now().Add(-2 * time.Hour).Truncate(10 * time.Second)
+				Expect(put("debug.cpu", st)).ToNot(HaveOccurred())
+				Expect(put("app.cpu", st)).ToNot(HaveOccurred())
+
+				var err error
+				s.retentionRules, err = newRetentionRules([]config.RetentionRule{
+					{ApplicationName: "debug.*", Retention: time.Hour},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s.deleteDataByRetention()).ToNot(HaveOccurred())
+
+				Expect(get("debug.cpu", st)).To(BeNil())
+				Expect(get("app.cpu", st)).ToNot(BeNil())
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("invalid rules", func() {
+			It("returns an error", func() {
+				(*cfg).Server.RetentionRules = []config.RetentionRule{{Retention: time.Hour}}
+				_, err := New(&(*cfg).Server)
+				Expect(err).To(HaveOccurred())
+
+				(*cfg).Server.RetentionRules = []config.RetentionRule{{Selector: "{foo=~\"(\"}"}}
+				_, err = New(&(*cfg).Server)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
+
+var _ = Describe("size-based retention", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("deletes the oldest data first", func() {
+			defer func(v float64) { SizeRetentionLowWatermark = v }(SizeRetentionLowWatermark)
+			SizeRetentionLowWatermark = 1
+
+			oldTime := time.Now().Add(-72 * time.Hour).Truncate(10 * time.Second)
+			newTime := time.Now().Add(-1 * time.Hour).Truncate(10 * time.Second)
+			oldKey, _ := ParseKey("old.cpu")
+			newKey, _ := ParseKey("new.cpu")
+			for _, pi := range []struct {
+				key *Key
+				t   time.Time
+			}{{oldKey, oldTime}, {newKey, newTime}} {
+				t := tree.New()
+				t.Insert([]byte("a;b"), uint64(1))
+				Expect(s.Put(&PutInput{
+					StartTime:  pi.t,
+					EndTime:    pi.t.Add(10 * time.Second),
+					Key:        pi.key,
+					Val:        t,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})).ToNot(HaveOccurred())
+			}
+
+			By("making sure trees are written to disk")
+			// write-back is asynchronous and may skip items when busy, hence the retries
+			Eventually(func() uint64 {
+				s.trees.WriteBack()
+				return s.trees.DiskSize(oldKey.TreeKey(0, oldTime))
+			}).Should(BeNumerically(">", 0))
+
+			By("not deleting anything when under the limit")
+			(*cfg).Server.MaxStorageSize = s.totalDiskUsage() * 2
+			reclaimed, err := s.enforceSizeLimit()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reclaimed).To(BeZero())
+
+			By("deleting the oldest data when over the limit")
+			(*cfg).Server.MaxStorageSize = s.totalDiskUsage() - 1
+			reclaimed, err = s.enforceSizeLimit()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reclaimed).To(BeNumerically(">", 0))
+
+			gOut, err := s.Get(&GetInput{StartTime: oldTime, EndTime: oldTime.Add(10 * time.Second), Key: oldKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut).To(BeNil())
+			gOut, err = s.Get(&GetInput{StartTime: newTime, EndTime: newTime.Add(10 * time.Second), Key: newKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut).ToNot(BeNil())
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		Context("when disk usage lags behind deletions", func() {
+			BeforeEach(func() {
+				// write-backs and evictions rewrite cached objects, growing disk usage
+				writeBackInterval = time.Hour
+				(*cfg).Server.CacheEvictThreshold = 1
+			})
+			AfterEach(func() {
+				writeBackInterval = time.Second
+			})
+
+			It("waits for deleted data to be reclaimed before deleting more", func() {
+				defer func(v float64) { SizeRetentionLowWatermark = v }(SizeRetentionLowWatermark)
+				SizeRetentionLowWatermark = 1
+				defer func(v time.Duration) { gcInterval = v }(gcInterval)
+
+				var keys []*Key
+				var times []time.Time
+				for i, d := range []time.Duration{72 * time.Hour, 48 * time.Hour, time.Hour} {
+					key, _ := ParseKey(fmt.Sprintf("app%d.cpu", i))
+					pt := time.Now().Add(-d).Truncate(10 * time.Second)
+					keys = append(keys, key)
+					times = append(times, pt)
+					// trees have to outweigh the tombstones written when they are deleted
+					t := tree.New()
+					for j := 0; j < 200; j++ {
+						t.Insert([]byte(fmt.Sprintf("a;b%d", j)), uint64(1))
+					}
+					Expect(s.Put(&PutInput{
+						StartTime:  pt,
+						EndTime:    pt.Add(10 * time.Second),
+						Key:        key,
+						Val:        t,
+						SpyName:    "testspy",
+						SampleRate: 100,
+					})).ToNot(HaveOccurred())
+				}
+				Eventually(func() bool {
+					s.trees.WriteBack()
+					for i, t := range times {
+						if s.trees.DiskSize(keys[i].TreeKey(0, t)) == 0 {
+							return false
+						}
+					}
+					return true
+				}).Should(BeTrue())
+				// write-backs are asynchronous, waiting for the disk usage to settle
+				var usage bytesize.ByteSize
+				Eventually(func() bool {
+					prev := usage
+					usage = s.totalDiskUsage()
+					return usage == prev
+				}, 5, 0.2).Should(BeTrue())
+				exists := func(i int) bool {
+					gOut, err := s.Get(&GetInput{StartTime: times[i], EndTime: times[i].Add(10 * time.Second), Key: keys[i]})
+					Expect(err).ToNot(HaveOccurred())
+					return gOut != nil
+				}
+
+				(*cfg).Server.MaxStorageSize = usage - 1
+				reclaimed, err := s.enforceSizeLimit()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(reclaimed).To(BeNumerically(">", 0))
+				Expect(exists(0)).To(BeFalse())
+
+				By("not deleting more while disk usage lags behind deletions")
+				Expect(s.totalDiskUsage()).To(BeNumerically(">", (*cfg).Server.MaxStorageSize))
+				reclaimed, err = s.enforceSizeLimit()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(reclaimed).To(BeZero())
+				Expect(exists(1)).To(BeTrue())
+
+				By("deleting more once garbage collection had a chance to reclaim space")
+				gcInterval = 0
+				reclaimed, err = s.enforceSizeLimit()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(reclaimed).To(BeNumerically(">", 0))
+				Expect(exists(1)).To(BeFalse())
+				Expect(exists(2)).To(BeTrue())
+
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
+		})
+	})
+})
+
+var _ = Describe("tiered retention", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("keeps old data at coarser resolution only", func() {
+			st := time.Now().Add(-48 * time.Hour).Truncate(100 * time.Second)
+			key, _ := ParseKey("app.cpu")
+			for i := 0; i < 2; i++ {
+				t := tree.New()
+				t.Insert([]byte("a;b"), uint64(1))
+				pst := st.Add(time.Duration(i) * 10 * time.Second)
+				Expect(s.Put(&PutInput{
+					StartTime:  pst,
+					EndTime:    pst.Add(10 * time.Second),
+					Key:        key,
+					Val:        t,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})).ToNot(HaveOccurred())
+			}
+
+			(*cfg).Server.RetentionLevel0 = 24 * time.Hour
+			Expect(s.deleteDataByRetention()).ToNot(HaveOccurred())
+
+			By("serving data from the coarser level")
+			stInt, err := s.segments.Get(key.SegmentKey())
+			Expect(err).ToNot(HaveOccurred())
+			depths := []int{}
+			stInt.(*segment.Segment).Get(st, st.Add(10*time.Second), func(depth int, _, _ uint64, _ time.Time, _ *big.Rat) {
+				depths = append(depths, depth)
+			})
+			Expect(depths).To(Equal([]int{1}))
+
+			gOut, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(100 * time.Second), Key: key})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut).ToNot(BeNil())
+			Expect(gOut.Tree.Samples()).To(Equal(uint64(2)))
+
+			By("rejecting writes of data available at coarser resolution only")
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			Expect(s.Put(&PutInput{
+				StartTime: st,
+				EndTime:   st.Add(10 * time.Second),
+				Key:       key,
+				Val:       t,
+			})).To(Equal(ErrRetention))
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -2,20 +2,25 @@ package segment
 
 import "time"
 
-// TODO: at some point we should change it so that segments can support different
-// resolution and multiplier values. For now they are constants
+// Default resolution and multiplier values, used when a segment is created
+// without specifying them and for segments serialized before these values were
+// stored in segment metadata
 const (
-	multiplier = 10
-	resolution = 10 * time.Second
+	DefaultMultiplier = 10
+	DefaultResolution = 10 * time.Second
 )
 
-var durations = []time.Duration{}
+// durations for the default resolution and multiplier,
+// they are also used as timeline steps
+var durations = generateDurations(DefaultResolution, DefaultMultiplier)
 
-func init() {
+func generateDurations(resolution time.Duration, multiplier int) []time.Duration {
+	res := []time.Duration{}
 	d := resolution
 	// TODO: better upper boundary, currently 50 is a magic number
 	for i := 0; i < 50; i++ {
-		durations = append(durations, d)
+		res = append(res, d)
 		d *= time.Duration(multiplier)
 	}
+	return res
 }
@@ -36,7 +36,7 @@ func (v *vis) add(n *streeNode, r *big.Rat, used bool) {
 	}
 	v.nodes = append(v.nodes, &visualizeNode2{
 		T1:      n.time.UTC(),
-		T2:      n.time.Add(durations[n.depth]).UTC(),
+		T2:      n.endTime().UTC(),
 		Depth:   n.depth,
 		HasTrie: n.present,
 		Samples: n.samples,
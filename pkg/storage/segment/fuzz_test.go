@@ -30,7 +30,7 @@ func newMock(resolution time.Duration) *storageMock {
 }
 
 func (sm *storageMock) Put(st, et time.Time, samples uint64) {
-	st, et = normalize(st, et)
+	st, et = normalize(st, et, sm.resolution)
 	fullDur := et.Sub(st) / sm.resolution
 	for t := st; t.Before(et); t = t.Add(sm.resolution) {
 		d := datapoint{
@@ -44,7 +44,7 @@ func (sm *storageMock) Put(st, et time.Time, samples uint64) {
 }
 
 func (sm *storageMock) Get(st, et time.Time, cb func(depth int, samples, writes uint64, t time.Time, r *big.Rat)) {
-	st, et = normalize(st, et)
+	st, et = normalize(st, et, sm.resolution)
 	for _, d := range sm.data {
 		if !d.t.Before(st) && !d.t.Add(sm.resolution).After(et) {
 			cb(0, 1, 1, d.t, d.r)
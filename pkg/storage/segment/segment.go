@@ -10,6 +10,7 @@ import (
 )
 
 type streeNode struct {
+	s        *Segment
 	depth    int
 	time     time.Time
 	present  bool
@@ -19,36 +20,36 @@ type streeNode struct {
 }
 
 func (sn *streeNode) replace(child *streeNode) {
-	i := child.time.Sub(sn.time) / durations[child.depth]
+	i := child.time.Sub(sn.time) / sn.s.durations[child.depth]
 	sn.children[i] = child
 }
 
 func (sn *streeNode) relationship(st, et time.Time) rel {
-	t2 := sn.time.Add(durations[sn.depth])
-	return relationship(sn.time, t2, st, et)
+	return relationship(sn.time, sn.endTime(), st, et)
 }
 
 func (sn *streeNode) isBefore(rt time.Time) bool {
-	t2 := sn.time.Add(durations[sn.depth])
-	return !t2.After(rt)
+	return !sn.endTime().After(rt)
 }
 
 func (sn *streeNode) isAfter(rt time.Time) bool {
 	return sn.time.After(rt)
 }
 
+func (sn *streeNode) duration() time.Duration {
+	return sn.s.durations[sn.depth]
+}
+
 func (sn *streeNode) endTime() time.Time {
-	return sn.time.Add(durations[sn.depth])
+	return sn.time.Add(sn.duration())
 }
 
 func (sn *streeNode) overlapRead(st, et time.Time) *big.Rat {
-	t2 := sn.time.Add(durations[sn.depth])
-	return overlapRead(sn.time, t2, st, et, durations[0])
+	return overlapRead(sn.time, sn.endTime(), st, et, sn.s.resolution)
 }
 
 func (sn *streeNode) overlapWrite(st, et time.Time) *big.Rat {
-	t2 := sn.time.Add(durations[sn.depth])
-	return overlapWrite(sn.time, t2, st, et, durations[0])
+	return overlapWrite(sn.time, sn.endTime(), st, et, sn.s.resolution)
 }
 
 func (sn *streeNode) findAddons() []Addon {
@@ -81,11 +82,12 @@ func (sn *streeNode) put(st, et time.Time, samples uint64, cb func(n *streeNode,
 			createNewChildren := rel == inside || rel == overlap
 			for i, v := range sn.children {
 				if createNewChildren && v == nil { // maybe create a new child
-					childT := sn.time.Truncate(durations[sn.depth]).Add(time.Duration(i) * durations[sn.depth-1])
+					childDuration := sn.s.durations[sn.depth-1]
+					childT := sn.time.Truncate(sn.duration()).Add(time.Duration(i) * childDuration)
 
-					rel2 := relationship(childT, childT.Add(durations[sn.depth-1]), st, et)
+					rel2 := relationship(childT, childT.Add(childDuration), st, et)
 					if rel2 != outside {
-						sn.children[i] = newNode(childT, sn.depth-1, 10)
+						sn.children[i] = sn.s.newNode(childT, sn.depth-1)
 					}
 				}
 
@@ -120,17 +122,13 @@ func (sn *streeNode) put(st, et time.Time, samples uint64, cb func(n *streeNode,
 	}
 }
 
-func normalize(st, et time.Time) (time.Time, time.Time) {
-	st = st.Truncate(durations[0])
-	et2 := et.Truncate(durations[0])
+func normalize(st, et time.Time, resolution time.Duration) (time.Time, time.Time) {
+	st = st.Truncate(resolution)
+	et2 := et.Truncate(resolution)
 	if et2.Equal(et) && !st.Equal(et2) {
 		return st, et
 	}
-	return st, et2.Add(durations[0])
-}
-
-func normalizeTime(t time.Time) time.Time {
-	return t.Truncate(durations[0])
+	return st, et2.Add(resolution)
 }
 
 //  relationship                               overlap read             overlap write
@@ -144,7 +142,7 @@ func (sn *streeNode) get(st, et time.Time, cb func(sn *streeNode, d int, t time.
 	if sn.present && (rel == contain || rel == match) {
 		cb(sn, sn.depth, sn.time, big.NewRat(1, 1))
 	} else if rel != outside { // inside or overlap
-		if sn.present && len(sn.children) == 0 {
+		if sn.present && !sn.hasChildren() {
 			// TODO: I did not test this logic as extensively as I would love to.
 			//   See https://github.com/pyroscope-io/pyroscope/issues/28 for more context and ideas on what to do
 			cb(sn, sn.depth, sn.time, sn.overlapRead(st, et))
@@ -159,6 +157,28 @@ func (sn *streeNode) get(st, et time.Time, cb func(sn *streeNode, d int, t time.
 	}
 }
 
+// walk is like get, but it defers to children whenever they hold all the data
+// of the node, so that the data is read at the finest resolution available
+func (sn *streeNode) walk(st, et time.Time, cb func(sn *streeNode, d int, t time.Time, r *big.Rat)) {
+	rel := sn.relationship(st, et)
+	if rel == outside {
+		return
+	}
+	if sn.hasChildren() && (!sn.present || sn.childrenHoldData()) {
+		for _, v := range sn.children {
+			if v != nil {
+				v.walk(st, et, cb)
+			}
+		}
+	} else if sn.present {
+		r := big.NewRat(1, 1)
+		if rel == inside || rel == overlap {
+			r = sn.overlapRead(st, et)
+		}
+		cb(sn, sn.depth, sn.time, r)
+	}
+}
+
 // deleteDataBefore returns true if the node should be deleted
 func (sn *streeNode) deleteDataBefore(retentionThreshold time.Time, cb func(depth int, t time.Time)) bool {
 	if !sn.isAfter(retentionThreshold) {
@@ -181,6 +201,86 @@ func (sn *streeNode) deleteDataBefore(retentionThreshold time.Time, cb func(dept
 	return false
 }
 
+// childrenHoldData reports whether the children have all the samples of the node.
+// They don't if the node was written as a whole while some children were missing,
+// or if some children were deleted by retention. Samples of writes split between
+// children are rounded down, each child can lose up to one sample per write.
+func (sn *streeNode) childrenHoldData() bool {
+	var samples uint64
+	for _, v := range sn.children {
+		if v != nil {
+			samples += v.samples
+		}
+	}
+	return samples+sn.writes*uint64(len(sn.children)) >= sn.samples
+}
+
+func (sn *streeNode) hasChildren() bool {
+	for _, v := range sn.children {
+		if v != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (sn *streeNode) walkPresent(cb func(depth int, t time.Time)) {
+	if sn.present {
+		cb(sn.depth, sn.time)
+	}
+	for _, v := range sn.children {
+		if v != nil {
+			v.walkPresent(cb)
+		}
+	}
+}
+
+// deleteLevelsBefore removes children of present nodes that are older than
+// the threshold for the children level. Data is still available in the parent
+// node, so only the resolution is reduced. Children of nodes that are not present
+// are kept, because otherwise the data would be lost.
+func (sn *streeNode) deleteLevelsBefore(thresholds []time.Time, cb func(depth int, t time.Time)) {
+	if sn.depth == 0 {
+		return
+	}
+	childDepth := sn.depth - 1
+	if sn.present && childDepth < len(thresholds) && !thresholds[childDepth].IsZero() && sn.isBefore(thresholds[childDepth]) {
+		for i, v := range sn.children {
+			if v != nil {
+				v.walkPresent(cb)
+				sn.children[i] = nil
+			}
+		}
+		return
+	}
+
+	for _, v := range sn.children {
+		if v != nil {
+			v.deleteLevelsBefore(thresholds, cb)
+		}
+	}
+}
+
+// deleteNode removes the tree of the node at the depth and time, it returns
+// true if the node has neither a tree nor children left and should be deleted
+func (sn *streeNode) deleteNode(depth int, t time.Time) bool {
+	if sn.depth == depth {
+		if !sn.time.Equal(t) {
+			return false
+		}
+		sn.present = false
+	} else if sn.depth < depth || t.Before(sn.time) || !t.Before(sn.endTime()) {
+		return false
+	} else {
+		for i, v := range sn.children {
+			if v != nil && v.deleteNode(depth, t) {
+				sn.children[i] = nil
+			}
+		}
+	}
+	return !sn.present && !sn.hasChildren()
+}
+
 type Segment struct {
 	m          sync.RWMutex
 	resolution time.Duration
@@ -194,25 +294,38 @@ type Segment struct {
 	aggregationType string
 }
 
-func newNode(t time.Time, depth, multiplier int) *streeNode {
+func (s *Segment) newNode(t time.Time, depth int) *streeNode {
 	sn := &streeNode{
+		s:     s,
 		depth: depth,
 		time:  t,
 	}
 	if depth > 0 {
-		sn.children = make([]*streeNode, multiplier)
+		sn.children = make([]*streeNode, s.multiplier)
 	}
 	return sn
 }
 
 func New() *Segment {
-	st := &Segment{
-		resolution: resolution,
-		multiplier: multiplier,
-		durations:  durations,
-	}
+	return NewWithResolution(DefaultResolution, DefaultMultiplier)
+}
 
-	return st
+// NewWithResolution creates a segment where the finest nodes cover resolution
+// each, and every parent node covers multiplier children
+func NewWithResolution(resolution time.Duration, multiplier int) *Segment {
+	s := &Segment{}
+	s.setResolution(resolution, multiplier)
+	return s
+}
+
+func (s *Segment) setResolution(resolution time.Duration, multiplier int) {
+	s.resolution = resolution
+	s.multiplier = multiplier
+	if resolution == DefaultResolution && multiplier == DefaultMultiplier {
+		s.durations = durations
+	} else {
+		s.durations = generateDurations(resolution, multiplier)
+	}
 }
 
 // TODO: DRY
@@ -232,12 +345,15 @@ func minTime(a, b time.Time) time.Time {
 
 func (s *Segment) growTree(st, et time.Time) {
 	var prevVal *streeNode
-	if s.root != nil {
+	// the first root is only a starting point, it's not kept as a child of the coarser
+	// roots, so that data written for the whole coarser node is stored at its depth only
+	empty := s.root == nil
+	if !empty {
 		st = minTime(st, s.root.time)
 		et = maxTime(et, s.root.endTime())
 	} else {
-		st = st.Truncate(s.durations[0])
-		s.root = newNode(st, 0, s.multiplier)
+		st = st.Truncate(s.resolution)
+		s.root = s.newNode(st, 0)
 	}
 
 	for {
@@ -249,8 +365,8 @@ func (s *Segment) growTree(st, et time.Time) {
 
 		prevVal = s.root
 		newDepth := prevVal.depth + 1
-		s.root = newNode(prevVal.time.Truncate(s.durations[newDepth]), newDepth, s.multiplier)
-		if prevVal != nil {
+		s.root = s.newNode(prevVal.time.Truncate(s.durations[newDepth]), newDepth)
+		if !empty {
 			s.root.samples = prevVal.samples
 			s.root.writes = prevVal.writes
 			s.root.replace(prevVal)
@@ -269,7 +385,7 @@ func (s *Segment) Put(st, et time.Time, samples uint64, cb func(depth int, t tim
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	st, et = normalize(st, et)
+	st, et = normalize(st, et, s.resolution)
 	s.growTree(st, et)
 	v := newVis()
 	s.root.put(st, et, samples, func(sn *streeNode, depth int, tm time.Time, r *big.Rat, addons []Addon) {
@@ -285,7 +401,7 @@ func (s *Segment) Get(st, et time.Time, cb func(depth int, samples, writes uint6
 	s.m.RLock()
 	defer s.m.RUnlock()
 
-	st, et = normalize(st, et)
+	st, et = normalize(st, et, s.resolution)
 	if s.root == nil {
 		return
 	}
@@ -299,6 +415,32 @@ func (s *Segment) Get(st, et time.Time, cb func(depth int, samples, writes uint6
 	v.print(filepath.Join(os.TempDir(), fmt.Sprintf("0-get-%s-%s.html", st.String(), et.String())))
 }
 
+// Walk is like Get, but instead of covering the time range with as few nodes as possible,
+// it calls cb for the nodes at the finest resolution available. Coarser nodes are only
+// passed to cb for the time ranges finer levels were deleted for.
+func (s *Segment) Walk(st, et time.Time, cb func(depth int, samples, writes uint64, t time.Time, r *big.Rat)) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	st, et = normalize(st, et, s.resolution)
+	if s.root == nil {
+		return
+	}
+	s.root.walk(st, et, func(sn *streeNode, depth int, t time.Time, r *big.Rat) {
+		cb(depth, sn.samples, sn.writes, t, r)
+	})
+}
+
+// WalkPresent calls cb for every node of the segment tree there is a tree stored for
+func (s *Segment) WalkPresent(cb func(depth int, t time.Time)) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	if s.root != nil {
+		s.root.walkPresent(cb)
+	}
+}
+
 func (s *Segment) DeleteDataBefore(retentionThreshold time.Time, cb func(depth int, t time.Time)) bool {
 	s.m.Lock()
 	defer s.m.Unlock()
@@ -307,7 +449,7 @@ func (s *Segment) DeleteDataBefore(retentionThreshold time.Time, cb func(depth i
 		return true
 	}
 
-	retentionThreshold = normalizeTime(retentionThreshold)
+	retentionThreshold = retentionThreshold.Truncate(s.resolution)
 	shouldDeleteRoot := s.root.deleteDataBefore(retentionThreshold, func(depth int, t time.Time) {
 		cb(depth, t)
 	})
@@ -320,6 +462,44 @@ func (s *Segment) DeleteDataBefore(retentionThreshold time.Time, cb func(depth i
 	return false
 }
 
+// DeleteLevelsBefore downsamples the segment: thresholds[d] is the retention
+// threshold for trees at depth d, zero time means the level is kept forever.
+// A tree is only deleted if its data is also present at a coarser level, so Get
+// falls back to coarser trees for old data. Deleting a level also deletes all finer levels below it.
+func (s *Segment) DeleteLevelsBefore(thresholds []time.Time, cb func(depth int, t time.Time)) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.root == nil {
+		return
+	}
+
+	normalized := make([]time.Time, len(thresholds))
+	for i, t := range thresholds {
+		if !t.IsZero() {
+			normalized[i] = t.Truncate(s.resolution)
+		}
+	}
+	s.root.deleteLevelsBefore(normalized, cb)
+}
+
+// DeleteNode removes the tree at the depth and time from the segment, e.g when
+// the tree can't be read. Nodes left without trees and children are removed as well.
+// It returns true if the segment is empty afterwards
+func (s *Segment) DeleteNode(depth int, t time.Time) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.root == nil {
+		return true
+	}
+	if s.root.deleteNode(depth, t) {
+		s.root = nil
+		return true
+	}
+	return false
+}
+
 // TODO: this should be refactored
 func (s *Segment) SetMetadata(spyName string, sampleRate uint32, units, aggregationType string) {
 	s.spyName = spyName
@@ -328,6 +508,32 @@ func (s *Segment) SetMetadata(spyName string, sampleRate uint32, units, aggregat
 	s.aggregationType = aggregationType
 }
 
+// Resolution is the duration of the finest segment nodes
+func (s *Segment) Resolution() time.Duration {
+	return s.resolution
+}
+
+func (s *Segment) Multiplier() int {
+	return s.multiplier
+}
+
+// IsEmpty reports whether any data has been put into the segment yet
+func (s *Segment) IsEmpty() bool {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.root == nil
+}
+
+// SetResolution changes resolution and multiplier of an empty segment, see NewWithResolution.
+// It does nothing if the segment already has data
+func (s *Segment) SetResolution(resolution time.Duration, multiplier int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.root == nil {
+		s.setResolution(resolution, multiplier)
+	}
+}
+
 func (s *Segment) SpyName() string {
 	return s.spyName
 }
@@ -58,6 +58,46 @@ var _ = Describe("stree", func() {
 		})
 	})
 
+	Context("Walk", func() {
+		It("reads the data at the finest resolution available", func() {
+			s := New()
+			put := func(st, et int) {
+				s.Put(testing.SimpleUTime(st), testing.SimpleUTime(et), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			}
+			put(10, 19)
+			put(20, 29)
+			put(1010, 1019)
+
+			walk := func(st, et int) []string {
+				res := []string{}
+				s.Walk(testing.SimpleUTime(st), testing.SimpleUTime(et), func(d int, samples, writes uint64, t time.Time, r *big.Rat) {
+					res = append(res, strconv.Itoa(d)+":"+strconv.Itoa(int(t.Unix()))+":"+r.String())
+				})
+				return res
+			}
+			Expect(doGet(s, testing.SimpleUTime(0), testing.SimpleUTime(99))).To(Equal([]time.Time{testing.SimpleUTime(0)}))
+			Expect(walk(0, 99)).To(Equal([]string{"0:10:1/1", "0:20:1/1"}))
+
+			s.DeleteLevelsBefore([]time.Time{testing.SimpleUTime(100)}, func(depth int, t time.Time) {})
+			Expect(walk(0, 2000)).To(Equal([]string{"1:0:1/1", "0:1010:1/1"}))
+			Expect(walk(0, 49)).To(Equal([]string{"1:0:1/2"}))
+		})
+
+		It("reads the data of nodes written as a whole", func() {
+			s := New()
+			s.Put(testing.SimpleUTime(10), testing.SimpleUTime(19), 10, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			s.Put(testing.SimpleUTime(20), testing.SimpleUTime(29), 10, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			s.Put(testing.SimpleUTime(1000), testing.SimpleUTime(1099), 100, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			s.Put(testing.SimpleUTime(1010), testing.SimpleUTime(1019), 10, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+
+			res := []string{}
+			s.Walk(testing.SimpleUTime(0), testing.SimpleUTime(2000), func(d int, samples, writes uint64, t time.Time, r *big.Rat) {
+				res = append(res, strconv.Itoa(d)+":"+strconv.Itoa(int(t.Unix())))
+			})
+			Expect(res).To(Equal([]string{"0:10", "0:20", "1:1000"}))
+		})
+	})
+
 	Context("StartTime", func() {
 		Context("empty segment", func() {
 			It("returns zero time", func() {
@@ -167,6 +207,110 @@ var _ = Describe("stree", func() {
 		})
 	})
 
+	Context("DeleteLevelsBefore", func() {
+		var s *Segment
+		var keys []string
+		deleteLevels := func(thresholds ...time.Time) {
+			keys = []string{}
+			s.DeleteLevelsBefore(thresholds, func(depth int, t time.Time) {
+				keys = append(keys, strconv.Itoa(depth)+":"+strconv.Itoa(int(t.Unix())))
+			})
+		}
+
+		BeforeEach(func() {
+			s = New()
+		})
+
+		Context("empty segment", func() {
+			It("doesn't fail", func() {
+				deleteLevels(testing.SimpleUTime(100))
+				Expect(keys).To(BeEmpty())
+			})
+		})
+
+		Context("data is present at a coarser level", func() {
+			BeforeEach(func() {
+				s.Put(testing.SimpleUTime(10), testing.SimpleUTime(19), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+				s.Put(testing.SimpleUTime(20), testing.SimpleUTime(29), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			})
+
+			It("deletes finer level and falls back to the coarser one", func() {
+				deleteLevels(testing.SimpleUTime(100))
+				Expect(keys).To(ConsistOf("0:10", "0:20"))
+				Expect(doGet(s, testing.SimpleUTime(0), testing.SimpleUTime(100))).To(Equal([]time.Time{testing.SimpleUTime(0)}))
+				Expect(doGet(s, testing.SimpleUTime(10), testing.SimpleUTime(19))).To(Equal([]time.Time{testing.SimpleUTime(0)}))
+			})
+
+			It("keeps data newer than the threshold", func() {
+				deleteLevels(testing.SimpleUTime(99))
+				Expect(keys).To(BeEmpty())
+				Expect(doGet(s, testing.SimpleUTime(10), testing.SimpleUTime(19))).To(Equal([]time.Time{testing.SimpleUTime(10)}))
+			})
+
+			It("keeps levels with zero threshold", func() {
+				deleteLevels(time.Time{}, testing.SimpleUTime(1000))
+				Expect(keys).To(BeEmpty())
+			})
+		})
+
+		Context("data is not present at a coarser level", func() {
+			BeforeEach(func() {
+				s.Put(testing.SimpleUTime(10), testing.SimpleUTime(19), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+				s.Put(testing.SimpleUTime(110), testing.SimpleUTime(119), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			})
+
+			It("keeps the data", func() {
+				deleteLevels(testing.SimpleUTime(1000))
+				Expect(keys).To(BeEmpty())
+			})
+
+			It("deletes all finer levels together with the coarser one", func() {
+				deleteLevels(time.Time{}, testing.SimpleUTime(1000))
+				Expect(keys).To(ConsistOf("0:10", "0:110"))
+				Expect(doGet(s, testing.SimpleUTime(0), testing.SimpleUTime(1000))).To(HaveLen(1))
+			})
+		})
+	})
+
+	Context("DeleteNode", func() {
+		var s *Segment
+		present := func() []string {
+			keys := []string{}
+			s.WalkPresent(func(depth int, t time.Time) {
+				keys = append(keys, strconv.Itoa(depth)+":"+strconv.Itoa(int(t.Unix())))
+			})
+			return keys
+		}
+
+		BeforeEach(func() {
+			s = New()
+			s.Put(testing.SimpleUTime(10), testing.SimpleUTime(19), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			s.Put(testing.SimpleUTime(20), testing.SimpleUTime(29), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			Expect(present()).To(ConsistOf("1:0", "0:10", "0:20"))
+		})
+
+		It("deletes the node and keeps the others", func() {
+			Expect(s.DeleteNode(0, testing.SimpleUTime(10))).To(BeFalse())
+			Expect(present()).To(ConsistOf("1:0", "0:20"))
+			Expect(s.DeleteNode(1, testing.SimpleUTime(0))).To(BeFalse())
+			Expect(present()).To(ConsistOf("0:20"))
+			Expect(doGet(s, testing.SimpleUTime(0), testing.SimpleUTime(100))).To(Equal([]time.Time{testing.SimpleUTime(20)}))
+		})
+
+		It("ignores nodes that don't exist", func() {
+			Expect(s.DeleteNode(0, testing.SimpleUTime(30))).To(BeFalse())
+			Expect(s.DeleteNode(2, testing.SimpleUTime(0))).To(BeFalse())
+			Expect(present()).To(ConsistOf("1:0", "0:10", "0:20"))
+		})
+
+		It("returns true once the segment is empty", func() {
+			Expect(s.DeleteNode(0, testing.SimpleUTime(10))).To(BeFalse())
+			Expect(s.DeleteNode(0, testing.SimpleUTime(20))).To(BeFalse())
+			Expect(s.DeleteNode(1, testing.SimpleUTime(0))).To(BeTrue())
+			Expect(s.IsEmpty()).To(BeTrue())
+		})
+	})
+
 	Context("Put", func() {
 		Context("When inserts are far apart", func() {
 			Context("When second insert is far in the future", func() {
@@ -26,6 +26,15 @@ func (s *Segment) populateFromMetadata(metadata map[string]interface{}) {
 	if v, ok := metadata["aggregationType"]; ok {
 		s.aggregationType = v.(string)
 	}
+	// segments serialized before resolution was configurable don't have these
+	resolution, multiplier := DefaultResolution, DefaultMultiplier
+	if v, ok := metadata["resolution"]; ok {
+		resolution = time.Duration(v.(float64)) * time.Second
+	}
+	if v, ok := metadata["multiplier"]; ok {
+		multiplier = int(v.(float64))
+	}
+	s.setResolution(resolution, multiplier)
 }
 
 func (s *Segment) generateMetadata() map[string]interface{} {
@@ -34,6 +43,8 @@ func (s *Segment) generateMetadata() map[string]interface{} {
 		"spyName":         s.spyName,
 		"units":           s.units,
 		"aggregationType": s.aggregationType,
+		"resolution":      int64(s.resolution / time.Second),
+		"multiplier":      s.multiplier,
 	}
 }
 
@@ -125,7 +136,7 @@ func Deserialize(r io.Reader) (*Segment, error) {
 		if err != nil {
 			return nil, err
 		}
-		node := newNode(time.Unix(int64(timeVal), 0), int(depth), s.multiplier)
+		node := s.newNode(time.Unix(int64(timeVal), 0), int(depth))
 		if presentVal == 1 {
 			node.present = true
 		}
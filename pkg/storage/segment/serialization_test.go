@@ -20,6 +20,10 @@ var serializedExampleV2 = "\x02={\"aggregationType\":\"\",\"sampleRate\":0,\"spy
 	"\x01\x80\x92\xb8Ø\xfe\xff\xff\xff\x01\x03\x03\x01\x03\x00\x80\x92\xb8Ø\xfe\xff\xff\xff\x01\x01\x01\x01\x00" +
 	"\x00\x8a\x92\xb8Ø\xfe\xff\xff\xff\x01\x01\x01\x01\x00\x00\x94\x92\xb8Ø\xfe\xff\xff\xff\x01\x01\x01\x01\x00"
 
+var serializedExampleV2WithResolution = "\x02]{\"aggregationType\":\"\",\"multiplier\":10,\"resolution\":10,\"sampleRate\":0,\"spyName\":\"\",\"units\":\"\"}" +
+	"\x01\x80\x92\xb8Ø\xfe\xff\xff\xff\x01\x03\x03\x01\x03\x00\x80\x92\xb8Ø\xfe\xff\xff\xff\x01\x01\x01\x01\x00" +
+	"\x00\x8a\x92\xb8Ø\xfe\xff\xff\xff\x01\x01\x01\x01\x00\x00\x94\x92\xb8Ø\xfe\xff\xff\xff\x01\x01\x01\x01\x00"
+
 var _ = Describe("stree", func() {
 	Context("Serialize / Deserialize", func() {
 		It("both functions work properly", func() {
@@ -59,7 +63,7 @@ var _ = Describe("stree", func() {
 			s.Serialize(&buf)
 			serialized := buf.Bytes()
 			log.Printf("q: %q", string(serialized))
-			Expect(string(serialized)).To(Equal(serializedExampleV2))
+			Expect(string(serialized)).To(Equal(serializedExampleV2WithResolution))
 		})
 	})
 
@@ -83,6 +87,27 @@ var _ = Describe("stree", func() {
 				Expect(s.root.children[2]).ToNot(BeNil())
 				Expect(s.root.children[3]).To(BeNil())
 				Expect(s.root.writes).To(Equal(uint64(3)))
+				Expect(s.Resolution()).To(Equal(DefaultResolution))
+				Expect(s.Multiplier()).To(Equal(DefaultMultiplier))
+			})
+		})
+		Context("custom resolution", func() {
+			It("restores resolution and multiplier", func() {
+				s := NewWithResolution(time.Minute, 5)
+				s.Put(testing.SimpleTime(0),
+					testing.SimpleTime(60), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+				s.Put(testing.SimpleTime(60),
+					testing.SimpleTime(120), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+
+				b, err := s.Bytes()
+				Expect(err).ToNot(HaveOccurred())
+				s, err = FromBytes(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s.Resolution()).To(Equal(time.Minute))
+				Expect(s.Multiplier()).To(Equal(5))
+				Expect(s.root.depth).To(Equal(1))
+				Expect(s.root.children).To(HaveLen(5))
+				Expect(s.root.children[1]).ToNot(BeNil())
 			})
 		})
 	})
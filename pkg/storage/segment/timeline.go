@@ -14,7 +14,7 @@ type Timeline struct {
 }
 
 func GenerateTimeline(st, et time.Time) *Timeline {
-	st, et = normalize(st, et)
+	st, et = normalize(st, et, durations[0])
 
 	totalDuration := et.Sub(st)
 	minDuration := totalDuration / time.Duration(2048/2)
@@ -58,7 +58,7 @@ func (tl *Timeline) PopulateTimeline(s *Segment) {
 func (sn *streeNode) populateTimeline(st, et time.Time, minDuration time.Duration, buf []uint64) {
 	rel := sn.relationship(st, et)
 	if rel != outside {
-		currentDuration := durations[sn.depth]
+		currentDuration := sn.duration()
 		if len(sn.children) > 0 && currentDuration >= minDuration {
 			for _, v := range sn.children {
 				if v != nil {
@@ -27,7 +27,7 @@ func (s *Segment) Visualize() {
 			// log.Debug("node:", durations[n.depth])
 			res = append(res, &visualizeNode{
 				T1:      n.time.UTC(),
-				T2:      n.time.Add(durations[n.depth]).UTC(),
+				T2:      n.endTime().UTC(),
 				Depth:   n.depth,
 				HasTrie: n.present,
 			})
@@ -21,25 +21,35 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/storage/labels"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
 	"github.com/pyroscope-io/pyroscope/pkg/structs/merge"
 	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 	"github.com/pyroscope-io/pyroscope/pkg/util/disk"
+	"github.com/pyroscope-io/pyroscope/pkg/util/keylock"
 	"github.com/pyroscope-io/pyroscope/pkg/util/metrics"
 	"github.com/pyroscope-io/pyroscope/pkg/util/slices"
 )
 
 var (
-	errOutOfSpace = errors.New("running out of space")
-	errRetention  = errors.New("could not write because of retention settings")
+	errOutOfSpace        = errors.New("running out of space")
+	ErrRetention         = errors.New("could not write because of retention settings")
+	ErrInvalidResolution = errors.New("resolution must be a whole number of seconds")
 
 	evictInterval     = time.Second
 	writeBackInterval = time.Second
 	retentionInterval = time.Minute
-	gcInterval        = 5 * time.Minute
+	// TODO: it's probably a good idea to make it configurable
+	sizeRetentionInterval = time.Minute
+	gcInterval            = 5 * time.Minute
 )
 
+// segmentLocksCount is the number of mutexes segment keys are spread across
+const segmentLocksCount = 1024
+
 type Storage struct {
-	putMutex sync.Mutex
+	// segmentLocks serialize writes to the same segment key. Shared objects
+	// (dimensions, dicts, labels) are safe for concurrent use on their own
+	segmentLocks *keylock.KeyLock
 
 	config   *config.Server
 	segments *cache.Cache
@@ -49,6 +59,22 @@ type Storage struct {
 	trees      *cache.Cache
 	labels     *labels.Labels
 
+	// renameMutex serializes RenameApp calls, so that two applications
+	// can't be renamed to the same name at once
+	renameMutex sync.Mutex
+
+	retentionRules []*retentionRule
+	// sizeRetentionPending is the space deleted by the size-based retention
+	// that badger may not have reclaimed yet, see enforceSizeLimit
+	sizeRetentionPending []pendingReclaim
+
+	// walMutex is held for reading while a PutInput is appended to the write-ahead
+	// log and applied, so that a checkpoint never observes a partially applied write
+	walMutex sync.RWMutex
+	wal      *wal.WAL
+
+	*APIKeyStore
+
 	db           *badger.DB
 	dbTrees      *badger.DB
 	dbDicts      *badger.DB
@@ -62,28 +88,32 @@ type Storage struct {
 }
 
 func (s *Storage) newBadger(name string) (*badger.DB, error) {
-	badgerPath := filepath.Join(s.config.StoragePath, name)
+	db, err := openBadger(s.config, name)
+	if err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.periodicTask(gcInterval, s.badgerGCTask(db))
+	return db, nil
+}
+
+func openBadger(c *config.Server, name string) (*badger.DB, error) {
+	badgerPath := filepath.Join(c.StoragePath, name)
 	err := os.MkdirAll(badgerPath, 0o755)
 	if err != nil {
 		return nil, err
 	}
 	badgerOptions := badger.DefaultOptions(badgerPath)
-	badgerOptions = badgerOptions.WithTruncate(!s.config.BadgerNoTruncate)
+	badgerOptions = badgerOptions.WithTruncate(!c.BadgerNoTruncate)
 	badgerOptions = badgerOptions.WithSyncWrites(false)
 	badgerOptions = badgerOptions.WithCompactL0OnClose(false)
 	badgerOptions = badgerOptions.WithCompression(options.ZSTD)
 	badgerLevel := logrus.ErrorLevel
-	if l, err := logrus.ParseLevel(s.config.BadgerLogLevel); err == nil {
+	if l, err := logrus.ParseLevel(c.BadgerLogLevel); err == nil {
 		badgerLevel = l
 	}
 	badgerOptions = badgerOptions.WithLogger(badgerLogger{name: name, logLevel: badgerLevel})
-	db, err := badger.Open(badgerOptions)
-	if err != nil {
-		return nil, err
-	}
-	s.wg.Add(1)
-	go s.periodicTask(gcInterval, s.badgerGCTask(db))
-	return db, nil
+	return badger.Open(badgerOptions)
 }
 
 func New(c *config.Server) (*Storage, error) {
@@ -91,12 +121,17 @@ func New(c *config.Server) (*Storage, error) {
 		config:           c,
 		stop:             make(chan struct{}),
 		localProfilesDir: filepath.Join(c.StoragePath, "local-profiles"),
+		segmentLocks:     keylock.New(segmentLocksCount),
 	}
 	var err error
+	if s.retentionRules, err = newRetentionRules(c.RetentionRules); err != nil {
+		return nil, err
+	}
 	s.db, err = s.newBadger("main")
 	if err != nil {
 		return nil, err
 	}
+	s.APIKeyStore = &APIKeyStore{db: s.db}
 	s.labels = labels.New(s.db)
 	s.dbTrees, err = s.newBadger("trees")
 	if err != nil {
@@ -161,6 +196,16 @@ func New(c *config.Server) (*Storage, error) {
 		return tree.New()
 	}
 
+	if c.EnableWAL {
+		if err = s.openWAL(); err != nil {
+			return nil, fmt.Errorf("write-ahead log: %v", err)
+		}
+		if c.WALSyncPolicy == wal.SyncInterval {
+			s.wg.Add(1)
+			go s.periodicTask(walSyncInterval, s.walSyncTask)
+		}
+	}
+
 	memTotal, err := getMemTotal()
 	if err != nil {
 		return nil, err
@@ -168,11 +213,23 @@ func New(c *config.Server) (*Storage, error) {
 
 	s.wg.Add(2)
 	go s.periodicTask(evictInterval, s.evictionTask(memTotal))
-	go s.periodicTask(writeBackInterval, s.writeBackTask)
-	if s.config.Retention > 0 {
+	if s.wal != nil {
+		interval := walCheckpointInterval
+		if c.WALCheckpointInterval > 0 {
+			interval = c.WALCheckpointInterval
+		}
+		go s.periodicTask(interval, s.checkpointTask)
+	} else {
+		go s.periodicTask(writeBackInterval, s.writeBackTask)
+	}
+	if s.retentionEnabled() {
 		s.wg.Add(1)
 		go s.periodicTask(retentionInterval, s.retentionTask)
 	}
+	if s.config.MaxStorageSize > 0 {
+		s.wg.Add(1)
+		go s.periodicTask(sizeRetentionInterval, s.sizeRetentionTask)
+	}
 
 	return s, nil
 }
@@ -186,6 +243,9 @@ type PutInput struct {
 	SampleRate      uint32
 	Units           string
 	AggregationType string
+	// Resolution is used when the first data of an application is put,
+	// existing segments keep their resolution. Zero means the default one
+	Resolution time.Duration
 }
 
 func (s *Storage) treeFromBytes(k string, v []byte) (interface{}, error) {
@@ -223,24 +283,47 @@ func (s *Storage) treeBytes(k string, v interface{}) ([]byte, error) {
 	if d == nil { // key not found
 		return nil, nil
 	}
-	return v.(*tree.Tree).Bytes(d.(*dict.Dict), s.config.MaxNodesSerialization)
+	b, err := v.(*tree.Tree).Bytes(d.(*dict.Dict), s.config.MaxNodesSerialization)
+	if err == nil && s.wal != nil {
+		// serialization adds new names to the dictionary, it has to be saved as well
+		s.dicts.Put(key, d)
+	}
+	return b, err
 }
 
 var OutOfSpaceThreshold = 512 * bytesize.MB
 
 func (s *Storage) Put(po *PutInput) error {
-	// TODO: This is a pretty broad lock. We should find a way to make these locks more selective.
-	s.putMutex.Lock()
-	defer s.putMutex.Unlock()
+	return s.putBefore(po, s.levelRetentionThreshold())
+}
 
+// putBefore is like Put, but data is only rejected if it's older than the retention
+// period or levelThreshold. Import writes coarser trees at their own depth, so it
+// doesn't have to respect the per-level retention thresholds
+func (s *Storage) putBefore(po *PutInput, levelThreshold time.Time) error {
 	if err := s.performFreeSpaceCheck(); err != nil {
 		return err
 	}
 
-	if po.StartTime.Before(s.lifetimeBasedRetentionThreshold()) {
-		return errRetention
+	if po.StartTime.Before(s.retentionThreshold(po.Key)) || po.StartTime.Before(levelThreshold) {
+		return ErrRetention
+	}
+	if po.Resolution < 0 || po.Resolution%time.Second != 0 {
+		return ErrInvalidResolution
+	}
+
+	if s.wal != nil {
+		s.walMutex.RLock()
+		defer s.walMutex.RUnlock()
+		if err := s.wal.Append(encodePutInput(po)); err != nil {
+			return fmt.Errorf("write-ahead log: %v", err)
+		}
 	}
 
+	return s.put(po)
+}
+
+func (s *Storage) put(po *PutInput) error {
 	logrus.WithFields(logrus.Fields{
 		"startTime":       po.StartTime.String(),
 		"endTime":         po.EndTime.String(),
@@ -250,11 +333,14 @@ func (s *Storage) Put(po *PutInput) error {
 		"aggregationType": po.AggregationType,
 	}).Debug("storage.Put")
 
+	sk := po.Key.SegmentKey()
+	s.segmentLocks.Lock(sk)
+	defer s.segmentLocks.Unlock(sk)
+
 	for k, v := range po.Key.labels {
 		s.labels.Put(k, v)
 	}
 
-	sk := po.Key.SegmentKey()
 	for k, v := range po.Key.labels {
 		key := k + ":" + v
 		res, err := s.dimensions.Get(key)
@@ -264,6 +350,7 @@ func (s *Storage) Put(po *PutInput) error {
 		}
 		if res != nil {
 			res.(*dimension.Dimension).Insert([]byte(sk))
+			s.dimensions.Put(key, res)
 		}
 	}
 
@@ -276,6 +363,9 @@ func (s *Storage) Put(po *PutInput) error {
 	}
 
 	st := res.(*segment.Segment)
+	if po.Resolution > 0 {
+		st.SetResolution(po.Resolution, segment.DefaultMultiplier)
+	}
 	st.SetMetadata(po.SpyName, po.SampleRate, po.Units, po.AggregationType)
 	samples := po.Val.Samples()
 	st.Put(po.StartTime, po.EndTime, samples, func(depth int, t time.Time, r *big.Rat, addons []segment.Addon) {
@@ -318,6 +408,8 @@ type GetInput struct {
 	StartTime time.Time
 	EndTime   time.Time
 	Key       *Key
+	// Query takes precedence over Key when set, see ParseQuery
+	Query *Query
 }
 
 type GetOutput struct {
@@ -329,28 +421,24 @@ type GetOutput struct {
 }
 
 func (s *Storage) Get(gi *GetInput) (*GetOutput, error) {
-	logrus.WithFields(logrus.Fields{
+	logger := logrus.WithFields(logrus.Fields{
 		"startTime": gi.StartTime.String(),
 		"endTime":   gi.EndTime.String(),
-		"key":       gi.Key.Normalized(),
-	}).Trace("storage.Get")
+	})
+	if gi.Query != nil {
+		logger.WithField("query", gi.Query.AppName).Trace("storage.Get")
+	} else {
+		logger.WithField("key", gi.Key.Normalized()).Trace("storage.Get")
+	}
 	triesToMerge := []merge.Merger{}
 
-	dimensions := []*dimension.Dimension{}
-	for k, v := range gi.Key.labels {
-		key := k + ":" + v
-		res, err := s.dimensions.Get(key)
-		if err != nil {
-			logrus.Errorf("dimensions cache for %v: %v", key, err)
-			continue
-		}
-		if res != nil {
-			dimensions = append(dimensions, res.(*dimension.Dimension))
-		}
+	var segmentKeys []dimension.Key
+	if gi.Query != nil {
+		segmentKeys = s.execQuery(gi.Query)
+	} else {
+		segmentKeys = s.keyToSegmentKeys(gi.Key)
 	}
 
-	segmentKeys := dimension.Intersection(dimensions...)
-
 	tl := segment.GenerateTimeline(gi.StartTime, gi.EndTime)
 	var lastSegment *segment.Segment
 	var writesTotal uint64
@@ -418,6 +506,23 @@ func (s *Storage) Get(gi *GetInput) (*GetOutput, error) {
 	}, nil
 }
 
+func (s *Storage) keyToSegmentKeys(k *Key) []dimension.Key {
+	dimensions := []*dimension.Dimension{}
+	for k, v := range k.labels {
+		key := k + ":" + v
+		res, err := s.dimensions.Get(key)
+		if err != nil {
+			logrus.Errorf("dimensions cache for %v: %v", key, err)
+			continue
+		}
+		if res != nil {
+			dimensions = append(dimensions, res.(*dimension.Dimension))
+		}
+	}
+
+	return dimension.Intersection(dimensions...)
+}
+
 func (s *Storage) iterateOverAllSegments(cb func(*Key, *segment.Segment) error) error {
 	nameKey := "__name__"
 
@@ -454,22 +559,34 @@ func (s *Storage) iterateOverAllSegments(cb func(*Key, *segment.Segment) error)
 
 func (s *Storage) DeleteDataBefore(threshold time.Time) error {
 	return s.iterateOverAllSegments(func(sk *Key, st *segment.Segment) error {
-		var err error
-		deletedRoot := st.DeleteDataBefore(threshold, func(depth int, t time.Time) {
-			tk := sk.TreeKey(depth, t)
-			if delErr := s.trees.Delete(tk); delErr != nil {
-				err = delErr
-			}
-		})
-		if err != nil {
-			return err
-		}
+		_, err := s.deleteSegmentDataBefore(sk, st, threshold)
+		return err
+	})
+}
 
-		if deletedRoot {
-			s.deleteSegmentAndRelatedData(sk)
+// deleteSegmentDataBefore returns estimated size of deleted trees on disk
+func (s *Storage) deleteSegmentDataBefore(sk *Key, st *segment.Segment, threshold time.Time) (uint64, error) {
+	var err error
+	var size uint64
+	var deleted bool
+	deletedRoot := st.DeleteDataBefore(threshold, func(depth int, t time.Time) {
+		tk := sk.TreeKey(depth, t)
+		size += s.trees.DiskSize(tk)
+		deleted = true
+		if delErr := s.trees.Delete(tk); delErr != nil {
+			err = delErr
 		}
-		return nil
 	})
+	if err != nil {
+		return size, err
+	}
+
+	if deletedRoot {
+		s.deleteSegmentAndRelatedData(sk)
+	} else if deleted {
+		s.segments.Put(sk.SegmentKey(), st)
+	}
+	return size, nil
 }
 
 type DeleteInput struct {
@@ -493,29 +610,36 @@ func (s *Storage) Delete(di *DeleteInput) error {
 
 	for _, sk := range segmentKeys {
 		skk, _ := ParseKey(string(sk))
-		stInt, err := s.segments.Get(skk.SegmentKey())
-		if err != nil {
-			return nil
-		}
-		st := stInt.(*segment.Segment)
-		if st == nil {
-			continue
-		}
-
-		st.Get(zeroTime, maxTime, func(depth int, _, _ uint64, t time.Time, _ *big.Rat) {
-			treeKey := skk.TreeKey(depth, t)
-			err = s.trees.Delete(treeKey)
-		})
-		if err != nil {
+		if err := s.deleteSegment(skk); err != nil {
 			return err
 		}
-
-		s.deleteSegmentAndRelatedData(skk)
 	}
 
 	return nil
 }
 
+// deleteSegment removes all the trees of the segment along with the segment itself
+func (s *Storage) deleteSegment(key *Key) error {
+	sk := key.SegmentKey()
+	s.segmentLocks.Lock(sk)
+	defer s.segmentLocks.Unlock(sk)
+
+	stInt, err := s.segments.Get(sk)
+	if err != nil {
+		return err
+	}
+	st := stInt.(*segment.Segment)
+	st.WalkPresent(func(depth int, t time.Time) {
+		if delErr := s.trees.Delete(key.TreeKey(depth, t)); delErr != nil {
+			err = delErr
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return s.deleteSegmentAndRelatedData(key)
+}
+
 func (s *Storage) deleteSegmentAndRelatedData(key *Key) error {
 	s.dicts.Delete(key.DictKey())
 	s.segments.Delete(key.SegmentKey())
@@ -527,6 +651,21 @@ func (s *Storage) deleteSegmentAndRelatedData(key *Key) error {
 		}
 		d := dInt.(*dimension.Dimension)
 		d.Delete(dimension.Key(key.SegmentKey()))
+		if len(dimension.Union(d)) > 0 {
+			s.dimensions.Put(k+":"+v, d)
+			continue
+		}
+		// the last segment with the label is gone
+		if err = s.dimensions.Delete(k + ":" + v); err != nil {
+			return err
+		}
+		if err = s.labels.Delete(k, v); err != nil {
+			return err
+		}
+		if k == "__name__" {
+			// dictionary of the application
+			s.dicts.Delete(v)
+		}
 	}
 	return nil
 }
@@ -535,6 +674,13 @@ func (s *Storage) Close() error {
 	close(s.stop)
 	s.wg.Wait()
 
+	if s.wal != nil {
+		if err := s.checkpoint(); err != nil {
+			logrus.WithError(err).Error("write-ahead log checkpoint failed")
+		}
+		s.wal.Close()
+	}
+
 	metrics.Timing("storage_caches_flush_timer", func() {
 		wg := sync.WaitGroup{}
 		wg.Add(3)
@@ -577,6 +723,32 @@ func (s *Storage) GetValues(key string, cb func(v string) bool) {
 	})
 }
 
+// GetValuesByQuery calls cb for every distinct value of the label
+// among the series matching the query
+func (s *Storage) GetValuesByQuery(label string, q *Query, cb func(v string) bool) {
+	seen := make(map[string]struct{})
+	for _, sk := range s.execQuery(q) {
+		k, err := ParseKey(string(sk))
+		if err != nil {
+			continue
+		}
+		v, ok := k.labels[label]
+		if !ok {
+			continue
+		}
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		if label == "__name__" && slices.StringContains(s.config.HideApplications, v) {
+			continue
+		}
+		if !cb(v) {
+			return
+		}
+	}
+}
+
 func (s *Storage) DiskUsage() map[string]bytesize.ByteSize {
 	res := map[string]bytesize.ByteSize{
 		"main":       0,
@@ -615,14 +787,6 @@ func (s *Storage) CacheStats() map[string]interface{} {
 
 var zeroTime time.Time
 
-func (s *Storage) lifetimeBasedRetentionThreshold() time.Time {
-	var t time.Time
-	if s.config.Retention != 0 {
-		t = time.Now().Add(-1 * s.config.Retention)
-	}
-	return t
-}
-
 func (s *Storage) performFreeSpaceCheck() error {
 	freeSpace, err := disk.FreeSpace(s.config.StoragePath)
 	if err == nil {
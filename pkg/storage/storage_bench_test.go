@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// BenchmarkStoragePut compares concurrent ingestion from many applications
+// with and without a global lock around Put (the way it used to work).
+//
+//	go test -run=^$ -bench=BenchmarkStoragePut -cpu=1,4,8 ./pkg/storage
+func BenchmarkStoragePut(b *testing.B) {
+	logrus.SetLevel(logrus.ErrorLevel)
+	var global sync.Mutex
+	b.Run("global-lock", func(b *testing.B) {
+		benchmarkPut(b, func(put func()) {
+			global.Lock()
+			defer global.Unlock()
+			put()
+		})
+	})
+	b.Run("per-key-lock", func(b *testing.B) {
+		benchmarkPut(b, func(put func()) { put() })
+	})
+}
+
+func benchmarkPut(b *testing.B, wrap func(func())) {
+	dir, err := ioutil.TempDir("", "pyroscope-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st, err := New(&config.Server{
+		StoragePath:           dir,
+		CacheEvictThreshold:   0.25,
+		CacheEvictVolume:      0.33,
+		MaxNodesSerialization: 2048,
+		MaxNodesRender:        2048,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer st.Close()
+
+	const apps = 1000
+	keys := make([]*Key, apps)
+	for i := range keys {
+		keys[i], _ = ParseKey("app-" + strconv.Itoa(i) + ".cpu{env=bench}")
+	}
+	start := time.Now().Truncate(10 * time.Second)
+
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			t := tree.New()
+			t.Insert([]byte("main;foo;bar"), 1)
+			t.Insert([]byte("main;foo;baz"), 2)
+			st0 := start.Add(time.Duration(i/apps) * 10 * time.Second)
+			wrap(func() {
+				err := st.Put(&PutInput{
+					StartTime:  st0,
+					EndTime:    st0.Add(10 * time.Second),
+					Key:        keys[i%apps],
+					Val:        t,
+					SpyName:    "gospy",
+					SampleRate: 100,
+					Units:      "samples",
+				})
+				if err != nil {
+					b.Error(err)
+				}
+			})
+		}
+	})
+}
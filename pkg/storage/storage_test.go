@@ -3,6 +3,7 @@ package storage
 import (
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -350,6 +351,44 @@ var _ = Describe("storage package", func() {
 					Expect(s2.Close()).ToNot(HaveOccurred())
 				})
 			})
+			Context("concurrent writes", func() {
+				It("doesn't lose data", func() {
+					st := testing.SimpleTime(10)
+					et := testing.SimpleTime(19)
+					wg := sync.WaitGroup{}
+					for i := 0; i < 8; i++ {
+						wg.Add(1)
+						go func(i int) {
+							defer GinkgoRecover()
+							defer wg.Done()
+							key, _ := ParseKey("foo{worker=" + strconv.Itoa(i%4) + "}")
+							for j := 0; j < 50; j++ {
+								tree := tree.New()
+								tree.Insert([]byte("a;b"), uint64(1))
+								Expect(s.Put(&PutInput{
+									StartTime:  st,
+									EndTime:    et,
+									Key:        key,
+									Val:        tree,
+									SpyName:    "testspy",
+									SampleRate: 100,
+								})).ToNot(HaveOccurred())
+							}
+						}(i)
+					}
+					wg.Wait()
+
+					appKey, _ := ParseKey("foo")
+					o, err := s.Get(&GetInput{
+						StartTime: st,
+						EndTime:   et,
+						Key:       appKey,
+					})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(o.Tree.Samples()).To(Equal(uint64(400)))
+					Expect(s.Close()).ToNot(HaveOccurred())
+				})
+			})
 		})
 	})
 })
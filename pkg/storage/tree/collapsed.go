@@ -0,0 +1,22 @@
+package tree
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// WriteCollapsed writes the tree in "folded stacks" format used by
+// Brendan Gregg's FlameGraph scripts, one "foo;bar;baz 42" line per stack.
+func (t *Tree) WriteCollapsed(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 0, 20)
+	t.Iterate(func(k []byte, v uint64) {
+		bw.Write(k)
+		bw.WriteByte(' ')
+		bw.Write(strconv.AppendUint(buf[:0], v, 10))
+		bw.WriteByte('\n')
+	})
+	// bufio.Writer keeps the first error, so it's enough to check it here
+	return bw.Flush()
+}
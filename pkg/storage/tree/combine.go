@@ -0,0 +1,45 @@
+package tree
+
+import "math/big"
+
+// CombineTree returns copies of the two trees aligned to the same structure:
+// nodes present in only one of the trees are added to the other one with zero
+// self and total values. Children of every pair of matching nodes end up in the
+// same order, so the resulting trees can be traversed side by side.
+func CombineTree(leftTree, rightTree *Tree) (*Tree, *Tree) {
+	one := big.NewRat(1, 1)
+	leftTree = leftTree.Clone(one)
+	rightTree = rightTree.Clone(one)
+
+	leftNodes := []*treeNode{leftTree.root}
+	rightNodes := []*treeNode{rightTree.root}
+	for len(leftNodes) > 0 {
+		left, right := leftNodes[0], rightNodes[0]
+		leftNodes, rightNodes = leftNodes[1:], rightNodes[1:]
+
+		for _, n := range left.ChildrenNodes {
+			right.insert(n.Name)
+		}
+		for _, n := range right.ChildrenNodes {
+			left.insert(n.Name)
+		}
+
+		leftNodes = append(leftNodes, left.ChildrenNodes...)
+		rightNodes = append(rightNodes, right.ChildrenNodes...)
+	}
+	return leftTree, rightTree
+}
+
+// combineIterateWithCum walks two combined trees side by side, see CombineTree.
+func combineIterateWithCum(leftTree, rightTree *Tree, cb func(leftCum, rightCum uint64) bool) {
+	leftNodes := []*treeNode{leftTree.root}
+	rightNodes := []*treeNode{rightTree.root}
+	for len(leftNodes) > 0 {
+		left, right := leftNodes[0], rightNodes[0]
+		leftNodes, rightNodes = leftNodes[1:], rightNodes[1:]
+		if cb(left.Total, right.Total) {
+			leftNodes = append(left.ChildrenNodes, leftNodes...)
+			rightNodes = append(right.ChildrenNodes, rightNodes...)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package tree
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("tree export", func() {
+	var tree *Tree
+	BeforeEach(func() {
+		tree = New()
+		tree.Insert([]byte("a;b"), uint64(1))
+		tree.Insert([]byte("a;c"), uint64(2))
+		tree.Insert([]byte("a"), uint64(3))
+	})
+
+	Context("WriteCollapsed", func() {
+		It("writes folded stacks", func() {
+			var buf bytes.Buffer
+			Expect(tree.WriteCollapsed(&buf)).ToNot(HaveOccurred())
+			Expect(buf.String()).To(Equal("a 3\na;b 1\na;c 2\n"))
+		})
+	})
+
+	Context("Speedscope", func() {
+		It("converts tree into a sampled profile", func() {
+			f := tree.Speedscope("app", "objects", 100)
+			Expect(f.Shared.Frames).To(Equal([]SpeedscopeFrame{{"a"}, {"b"}, {"c"}}))
+			Expect(f.Profiles).To(HaveLen(1))
+			p := f.Profiles[0]
+			Expect(p.Type).To(Equal("sampled"))
+			Expect(p.Unit).To(Equal("none"))
+			Expect(p.Samples).To(Equal([][]int{{0}, {0, 1}, {0, 2}}))
+			Expect(p.Weights).To(Equal([]float64{3, 1, 2}))
+			Expect(p.EndValue).To(Equal(float64(6)))
+		})
+
+		It("converts cpu samples to seconds", func() {
+			p := tree.Speedscope("app", "samples", 100).Profiles[0]
+			Expect(p.Unit).To(Equal("seconds"))
+			Expect(p.Weights).To(Equal([]float64{0.03, 0.01, 0.02}))
+			Expect(p.EndValue).To(Equal(0.06))
+		})
+
+		It("keeps bytes units", func() {
+			Expect(tree.Speedscope("app", "bytes", 100).Profiles[0].Unit).To(Equal("bytes"))
+		})
+	})
+})
@@ -1,10 +1,21 @@
 package tree
 
+const (
+	// FormatSingle is a regular flamegraph, 4 numbers per node
+	FormatSingle = "single"
+	// FormatDouble is a diff flamegraph, 7 numbers per node
+	FormatDouble = "double"
+)
+
 type Flamebearer struct {
 	Names    []string `json:"names"`
 	Levels   [][]int  `json:"levels"`
 	NumTicks int      `json:"numTicks"`
 	MaxSelf  int      `json:"maxSelf"`
+	Format   string   `json:"format"`
+	// these two are only set for diff flamegraphs
+	LeftTicks  uint64 `json:"leftTicks,omitempty"`
+	RightTicks uint64 `json:"rightTicks,omitempty"`
 	// TODO: see note in render.go
 	SpyName    string `json:"spyName"`
 	SampleRate uint32 `json:"sampleRate"`
@@ -20,6 +31,7 @@ func (t *Tree) FlamebearerStruct(maxNodes int) *Flamebearer {
 		Levels:   [][]int{},
 		NumTicks: int(t.Samples()),
 		MaxSelf:  int(0),
+		Format:   FormatSingle,
 	}
 
 	nodes := []*treeNode{t.root}
@@ -107,3 +119,129 @@ func (t *Tree) FlamebearerStruct(maxNodes int) *Flamebearer {
 	// }
 	return &res
 }
+
+// CombineToFlamebearerStruct generates a diff flamegraph out of two trees.
+// Both trees are aligned first (see CombineTree) and every node in the result
+// carries values from both of them, so there are 7 numbers per node:
+//
+//	i+0 = x offset, left tree (delta encoded)
+//	i+1 = total, left tree
+//	i+2 = self, left tree
+//	i+3 = x offset, right tree (delta encoded)
+//	i+4 = total, right tree
+//	i+5 = self, right tree
+//	i+6 = index in names array
+//
+// Nodes smaller than the cut-off value in both trees are grouped
+// into "other" nodes the same way FlamebearerStruct does it.
+func CombineToFlamebearerStruct(leftTree, rightTree *Tree, maxNodes int) *Flamebearer {
+	leftTree, rightTree = CombineTree(leftTree, rightTree)
+
+	res := Flamebearer{
+		Names:      []string{},
+		Levels:     [][]int{},
+		NumTicks:   int(leftTree.Samples() + rightTree.Samples()),
+		MaxSelf:    int(0),
+		Format:     FormatDouble,
+		LeftTicks:  leftTree.Samples(),
+		RightTicks: rightTree.Samples(),
+	}
+
+	leftNodes := []*treeNode{leftTree.root}
+	rightNodes := []*treeNode{rightTree.root}
+	xLeftOffsets := []int{0}
+	xRightOffsets := []int{0}
+	levels := []int{0}
+	minVal := combineMinValue(leftTree, rightTree, maxNodes)
+	nameLocationCache := map[string]int{}
+
+	for len(leftNodes) > 0 {
+		left, right := leftNodes[0], rightNodes[0]
+		leftNodes, rightNodes = leftNodes[1:], rightNodes[1:]
+
+		xLeftOffset, xRightOffset := xLeftOffsets[0], xRightOffsets[0]
+		xLeftOffsets, xRightOffsets = xLeftOffsets[1:], xRightOffsets[1:]
+
+		level := levels[0]
+		levels = levels[1:]
+
+		name := string(left.Name)
+		if maxUint64(left.Total, right.Total) >= minVal || name == "other" {
+			var i int
+			var ok bool
+			if i, ok = nameLocationCache[name]; !ok {
+				i = len(res.Names)
+				nameLocationCache[name] = i
+				if i == 0 {
+					name = "total"
+				}
+				res.Names = append(res.Names, name)
+			}
+
+			if level == len(res.Levels) {
+				res.Levels = append(res.Levels, []int{})
+			}
+			if res.MaxSelf < int(left.Self) {
+				res.MaxSelf = int(left.Self)
+			}
+			if res.MaxSelf < int(right.Self) {
+				res.MaxSelf = int(right.Self)
+			}
+
+			res.Levels[level] = append([]int{
+				xLeftOffset, int(left.Total), int(left.Self),
+				xRightOffset, int(right.Total), int(right.Self),
+				i,
+			}, res.Levels[level]...)
+
+			xLeftOffset += int(left.Self)
+			xRightOffset += int(right.Self)
+			otherLeftTotal, otherRightTotal := uint64(0), uint64(0)
+			for j, ln := range left.ChildrenNodes {
+				rn := right.ChildrenNodes[j]
+				if maxUint64(ln.Total, rn.Total) >= minVal {
+					xLeftOffsets = append([]int{xLeftOffset}, xLeftOffsets...)
+					xRightOffsets = append([]int{xRightOffset}, xRightOffsets...)
+					levels = append([]int{level + 1}, levels...)
+					leftNodes = append([]*treeNode{ln}, leftNodes...)
+					rightNodes = append([]*treeNode{rn}, rightNodes...)
+					xLeftOffset += int(ln.Total)
+					xRightOffset += int(rn.Total)
+				} else {
+					otherLeftTotal += ln.Total
+					otherRightTotal += rn.Total
+				}
+			}
+			if otherLeftTotal != 0 || otherRightTotal != 0 {
+				ln := &treeNode{
+					Name:  jsonableSlice("other"),
+					Total: otherLeftTotal,
+					Self:  otherLeftTotal,
+				}
+				rn := &treeNode{
+					Name:  jsonableSlice("other"),
+					Total: otherRightTotal,
+					Self:  otherRightTotal,
+				}
+				xLeftOffsets = append([]int{xLeftOffset}, xLeftOffsets...)
+				xRightOffsets = append([]int{xRightOffset}, xRightOffsets...)
+				levels = append([]int{level + 1}, levels...)
+				leftNodes = append([]*treeNode{ln}, leftNodes...)
+				rightNodes = append([]*treeNode{rn}, rightNodes...)
+			}
+		}
+	}
+
+	// delta encoding
+	for _, l := range res.Levels {
+		prevLeft, prevRight := 0, 0
+		for i := 0; i < len(l); i += 7 {
+			l[i] -= prevLeft
+			prevLeft += l[i] + l[i+1]
+			l[i+3] -= prevRight
+			prevRight += l[i+3] + l[i+4]
+		}
+	}
+
+	return &res
+}
@@ -42,4 +42,53 @@ var _ = Describe("FlamebearerStruct", func() {
 			Expect(f.Names).To(ContainElement("other"))
 		})
 	})
+
+	Context("diff of two trees", func() {
+		It("sets all attributes correctly", func() {
+			leftTree := New()
+			leftTree.Insert([]byte("a;b"), uint64(1))
+			leftTree.Insert([]byte("a;c"), uint64(2))
+
+			rightTree := New()
+			rightTree.Insert([]byte("a;b"), uint64(4))
+			rightTree.Insert([]byte("a;d"), uint64(8))
+
+			f := CombineToFlamebearerStruct(leftTree, rightTree, 1024)
+			Expect(f.Format).To(Equal(FormatDouble))
+			Expect(f.Names).To(ConsistOf("total", "a", "b", "c", "d"))
+			Expect(f.Levels).To(Equal([][]int{
+				// i+0 = x offset, left tree (delta encoded)
+				// i+1 = total, left tree
+				// i+2 = self, left tree
+				// i+3 = x offset, right tree (delta encoded)
+				// i+4 = total, right tree
+				// i+5 = self, right tree
+				// i+6 = index in names array
+				{0, 3, 0, 0, 12, 0, 0},
+				{0, 3, 0, 0, 12, 0, 1},
+				{0, 1, 1, 0, 4, 4, 4, 0, 2, 2, 0, 0, 0, 3, 0, 0, 0, 0, 8, 8, 2},
+			}))
+			Expect(f.NumTicks).To(Equal(15))
+			Expect(f.LeftTicks).To(Equal(uint64(3)))
+			Expect(f.RightTicks).To(Equal(uint64(12)))
+			Expect(f.MaxSelf).To(Equal(8))
+
+			By("leaving original trees intact")
+			Expect(leftTree.String()).To(Equal("\"a;b\" 1\n\"a;c\" 2\n"))
+		})
+
+		It("groups small nodes into \"other\" node", func() {
+			leftTree := New()
+			rightTree := New()
+			r := rand.New(rand.NewSource(123))
+			for i := 0; i < 2048; i++ {
+				leftTree.Insert([]byte(fmt.Sprintf("foo;bar%d", i)), uint64(r.Intn(4000)))
+				rightTree.Insert([]byte(fmt.Sprintf("foo;baz%d", i)), uint64(r.Intn(4000)))
+			}
+
+			f := CombineToFlamebearerStruct(leftTree, rightTree, 10)
+			Expect(f.Names).To(ContainElement("other"))
+			Expect(len(f.Names)).To(BeNumerically("<=", 12))
+		})
+	})
 })
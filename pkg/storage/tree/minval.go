@@ -9,3 +9,18 @@ func (t *Tree) minValue(maxNodes int) uint64 {
 	})
 	return c.MinValue()
 }
+
+func combineMinValue(leftTree, rightTree *Tree, maxNodes int) uint64 {
+	c := cappedarr.New(maxNodes)
+	combineIterateWithCum(leftTree, rightTree, func(leftCum, rightCum uint64) bool {
+		return c.Push(maxUint64(leftCum, rightCum))
+	})
+	return c.MinValue()
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,97 @@
+package tree
+
+import (
+	"bytes"
+)
+
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+// SpeedscopeFile is a subset of speedscope file format sufficient to
+// represent a tree as a single sampled profile.
+// See https://github.com/jlfwong/speedscope/wiki/Importing-from-custom-sources
+type SpeedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             SpeedscopeShared    `json:"shared"`
+	Profiles           []SpeedscopeProfile `json:"profiles"`
+	Name               string              `json:"name"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+type SpeedscopeShared struct {
+	Frames []SpeedscopeFrame `json:"frames"`
+}
+
+type SpeedscopeFrame struct {
+	Name string `json:"name"`
+}
+
+type SpeedscopeProfile struct {
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	Unit       string    `json:"unit"`
+	StartValue float64   `json:"startValue"`
+	EndValue   float64   `json:"endValue"`
+	Samples    [][]int   `json:"samples"`
+	Weights    []float64 `json:"weights"`
+}
+
+// speedscopeUnit maps pyroscope units to the ones speedscope understands.
+// It also returns the divisor of tree values, e.g. cpu samples are converted
+// to seconds using the sample rate.
+func speedscopeUnit(units string, sampleRate uint32) (string, float64) {
+	switch units {
+	case "bytes":
+		return "bytes", 1
+	case "lock_nanoseconds":
+		return "nanoseconds", 1
+	case "samples", "":
+		if sampleRate > 0 {
+			return "seconds", float64(sampleRate)
+		}
+	}
+	return "none", 1
+}
+
+// Speedscope converts the tree into a speedscope file with one sampled profile.
+// Each stack with a non-zero self value becomes a sample weighted by that value.
+func (t *Tree) Speedscope(name, units string, sampleRate uint32) *SpeedscopeFile {
+	frames := []SpeedscopeFrame{}
+	frameIndex := map[string]int{}
+	unit, divisor := speedscopeUnit(units, sampleRate)
+	p := SpeedscopeProfile{
+		Type:    "sampled",
+		Name:    name,
+		Unit:    unit,
+		Samples: [][]int{},
+		Weights: []float64{},
+	}
+	var total uint64
+
+	t.Iterate(func(k []byte, v uint64) {
+		parts := bytes.Split(k, []byte(";"))
+		sample := make([]int, len(parts))
+		for i, part := range parts {
+			frameName := string(part)
+			idx, ok := frameIndex[frameName]
+			if !ok {
+				idx = len(frames)
+				frameIndex[frameName] = idx
+				frames = append(frames, SpeedscopeFrame{Name: frameName})
+			}
+			sample[i] = idx
+		}
+		p.Samples = append(p.Samples, sample)
+		p.Weights = append(p.Weights, float64(v)/divisor)
+		total += v
+	})
+	p.EndValue = float64(total) / divisor
+
+	return &SpeedscopeFile{
+		Schema:   speedscopeSchema,
+		Shared:   SpeedscopeShared{Frames: frames},
+		Profiles: []SpeedscopeProfile{p},
+		Name:     name,
+		Exporter: "pyroscope",
+	}
+}
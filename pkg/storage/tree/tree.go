@@ -162,6 +162,19 @@ func (t *Tree) iterate(cb func(key []byte, val uint64)) {
 	}
 }
 
+// Iterate calls cb for every stack with a non-zero self value. Stack frames
+// are separated by semicolons, e.g. "foo;bar". The key is only valid until cb returns.
+func (t *Tree) Iterate(cb func(key []byte, val uint64)) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	t.iterate(func(k []byte, v uint64) {
+		if v > 0 && len(k) > 2 {
+			cb(k[2:], v)
+		}
+	})
+}
+
 func (t *Tree) iterateWithCum(cb func(cum uint64) bool) {
 	nodes := []*treeNode{t.root}
 	i := 0
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/cache"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
+	"github.com/pyroscope-io/pyroscope/pkg/util/serialization"
+	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
+)
+
+const walRecordVersion = 1
+
+var walSyncInterval = time.Second
+
+var walCheckpointInterval = time.Minute
+
+// The write-ahead log holds every PutInput accepted since the last checkpoint.
+// A checkpoint synchronously saves everything that was put into the caches
+// and empties the log, so on startup only the data that never made it to disk
+// is replayed. Caches don't write to disk between checkpoints, see TrackDirty.
+func (s *Storage) openWAL() error {
+	dir := filepath.Join(s.config.StoragePath, "wal")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	w, err := wal.Open(filepath.Join(dir, "put.log"), s.config.WALSyncPolicy)
+	if err != nil {
+		return err
+	}
+	s.wal = w
+	for _, c := range []*cache.Cache{s.trees, s.dicts, s.segments, s.dimensions} {
+		c.TrackDirty = true
+	}
+	return s.replayWAL()
+}
+
+func (s *Storage) replayWAL() error {
+	var n int
+	err := s.wal.Replay(func(b []byte) error {
+		po, err := decodePutInput(b)
+		if err != nil {
+			return fmt.Errorf("%w: %v", wal.ErrCorrupted, err)
+		}
+		if err = s.put(po); err != nil {
+			logrus.WithError(err).Warn("failed to replay write-ahead log record")
+		}
+		n++
+		return nil
+	})
+	if errors.Is(err, wal.ErrCorrupted) {
+		logrus.WithError(err).Warn("skipping the rest of write-ahead log")
+	} else if err != nil {
+		return err
+	}
+	if n > 0 {
+		logrus.WithField("records", n).Info("replayed write-ahead log")
+	}
+	return s.checkpoint()
+}
+
+// checkpoint saves all the data put since the previous checkpoint and truncates the log
+func (s *Storage) checkpoint() error {
+	s.walMutex.Lock()
+	defer s.walMutex.Unlock()
+
+	// trees go first because they write to dictionaries when serialized
+	for _, c := range []*cache.Cache{s.trees, s.dicts, s.segments, s.dimensions} {
+		if err := c.Sync(); err != nil {
+			return err
+		}
+	}
+	if s.config.WALSyncPolicy != wal.SyncNever {
+		for _, db := range []*badger.DB{s.db, s.dbTrees, s.dbDicts, s.dbDimensions, s.dbSegments} {
+			if err := db.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.wal.Truncate()
+}
+
+func encodePutInput(po *PutInput) []byte {
+	var b bytes.Buffer
+	vw := varint.NewWriter()
+	vw.Write(&b, walRecordVersion)
+	vw.Write(&b, uint64(po.StartTime.Unix()))
+	vw.Write(&b, uint64(po.EndTime.Unix()))
+	vw.Write(&b, uint64(po.SampleRate))
+	serialization.WriteMetadata(&b, map[string]interface{}{
+		"key":             po.Key.Normalized(),
+		"spyName":         po.SpyName,
+		"units":           po.Units,
+		"aggregationType": po.AggregationType,
+		"resolution":      int64(po.Resolution / time.Second),
+	})
+	// stacks are stored as is, tree serialization would drop the smallest nodes
+	po.Val.Iterate(func(k []byte, v uint64) {
+		vw.Write(&b, uint64(len(k)))
+		b.Write(k)
+		vw.Write(&b, v)
+	})
+	return b.Bytes()
+}
+
+func decodePutInput(b []byte) (*PutInput, error) {
+	br := bufio.NewReader(bytes.NewReader(b))
+	version, err := varint.Read(br)
+	if err != nil {
+		return nil, err
+	}
+	if version != walRecordVersion {
+		return nil, fmt.Errorf("unknown record version %d", version)
+	}
+	var v [3]uint64
+	for i := range v {
+		if v[i], err = varint.Read(br); err != nil {
+			return nil, err
+		}
+	}
+	metadata, err := serialization.ReadMetadata(br)
+	if err != nil {
+		return nil, err
+	}
+	po := PutInput{
+		StartTime:  time.Unix(int64(v[0]), 0),
+		EndTime:    time.Unix(int64(v[1]), 0),
+		SampleRate: uint32(v[2]),
+		Val:        tree.New(),
+	}
+	k, _ := metadata["key"].(string)
+	if po.Key, err = ParseKey(k); err != nil {
+		return nil, err
+	}
+	po.SpyName, _ = metadata["spyName"].(string)
+	po.Units, _ = metadata["units"].(string)
+	po.AggregationType, _ = metadata["aggregationType"].(string)
+	if v, ok := metadata["resolution"].(float64); ok {
+		po.Resolution = time.Duration(v) * time.Second
+	}
+
+	for {
+		l, err := varint.Read(br)
+		if err != nil {
+			break
+		}
+		name := make([]byte, l)
+		if _, err = io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		val, err := varint.Read(br)
+		if err != nil {
+			return nil, err
+		}
+		po.Val.Insert(name, val)
+	}
+	return &po, nil
+}
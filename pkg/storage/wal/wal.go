@@ -0,0 +1,151 @@
+// Package wal implements a simple append-only write-ahead log.
+// Records are opaque byte slices; every record is prefixed with its
+// length and CRC32 checksum, so that a torn write at the end of the log
+// (e.g. after a crash) can be detected and skipped on replay.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// fsync policies
+const (
+	// SyncAlways calls fsync after every appended record
+	SyncAlways = "always"
+	// SyncInterval relies on the caller to call Sync periodically
+	SyncInterval = "interval"
+	// SyncNever leaves flushing to the operating system. Data survives
+	// a crash of the process, but not of the machine
+	SyncNever = "never"
+)
+
+const (
+	headerSize    = 8
+	maxRecordSize = 256 << 20
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid wal sync policy")
+	// ErrCorrupted is returned by Replay when a record can not be read.
+	// All records before the corrupted one have been replayed successfully
+	ErrCorrupted = errors.New("wal is corrupted")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type WAL struct {
+	m      sync.Mutex
+	f      *os.File
+	policy string
+	buf    []byte
+}
+
+func Open(path, policy string) (*WAL, error) {
+	switch policy {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPolicy, policy)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{f: f, policy: policy}, nil
+}
+
+// Append writes the record to the log. The record is handed over to the
+// operating system before Append returns, and is fsynced if the policy is SyncAlways
+func (w *WAL) Append(record []byte) error {
+	if len(record) > maxRecordSize {
+		return fmt.Errorf("wal record is too big: %d bytes", len(record))
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	// header and payload are written with a single call to keep records contiguous
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(record)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(record, crcTable))
+	w.buf = append(append(w.buf[:0], header[:]...), record...)
+	if _, err := w.f.Write(w.buf); err != nil {
+		return err
+	}
+	if w.policy == SyncAlways {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+// Replay calls cb for every record in the log, in the order they were appended.
+// If the log ends with a partially written record, it is ignored.
+func (w *WAL) Replay(cb func(record []byte) error) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(w.f)
+	header := make([]byte, headerSize)
+	for offset := 0; ; {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return fmt.Errorf("%w: invalid record size at offset %d", ErrCorrupted, offset)
+		}
+		record := make([]byte, size)
+		if _, err := io.ReadFull(r, record); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		if crc32.Checksum(record, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			return fmt.Errorf("%w: checksum mismatch at offset %d", ErrCorrupted, offset)
+		}
+		if err := cb(record); err != nil {
+			return err
+		}
+		offset += headerSize + int(size)
+	}
+}
+
+// Truncate removes all records from the log. It should only be called
+// once the data from the records has been durably stored elsewhere
+func (w *WAL) Truncate() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if w.policy != SyncNever {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+func (w *WAL) Sync() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.f.Sync()
+}
+
+func (w *WAL) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.f.Close()
+}
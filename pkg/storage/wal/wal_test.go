@@ -0,0 +1,87 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("wal", func() {
+	var tdir *testing.TmpDirectory
+	var path string
+	var w *WAL
+
+	replay := func() ([]string, error) {
+		records := []string{}
+		err := w.Replay(func(b []byte) error {
+			records = append(records, string(b))
+			return nil
+		})
+		return records, err
+	}
+
+	BeforeEach(func() {
+		tdir = testing.TmpDirSync()
+		path = filepath.Join(tdir.Path, "test.log")
+		var err error
+		w, err = Open(path, SyncAlways)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		w.Close()
+		tdir.Close()
+	})
+
+	It("replays appended records in order", func() {
+		Expect(w.Append([]byte("foo"))).ToNot(HaveOccurred())
+		Expect(w.Append([]byte("bar"))).ToNot(HaveOccurred())
+		Expect(w.Close()).ToNot(HaveOccurred())
+
+		var err error
+		w, err = Open(path, SyncNever)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Append([]byte("baz"))).ToNot(HaveOccurred())
+		Expect(replay()).To(Equal([]string{"foo", "bar", "baz"}))
+	})
+
+	It("removes all records on truncate", func() {
+		Expect(w.Append([]byte("foo"))).ToNot(HaveOccurred())
+		Expect(w.Truncate()).ToNot(HaveOccurred())
+		Expect(w.Append([]byte("bar"))).ToNot(HaveOccurred())
+		Expect(replay()).To(Equal([]string{"bar"}))
+	})
+
+	It("ignores partially written records", func() {
+		Expect(w.Append([]byte("foo"))).ToNot(HaveOccurred())
+		Expect(w.Append([]byte("bar"))).ToNot(HaveOccurred())
+		fi, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Truncate(path, fi.Size()-1)).ToNot(HaveOccurred())
+		Expect(replay()).To(Equal([]string{"foo"}))
+	})
+
+	It("detects corrupted records", func() {
+		Expect(w.Append([]byte("foo"))).ToNot(HaveOccurred())
+		Expect(w.Append([]byte("bar"))).ToNot(HaveOccurred())
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.WriteAt([]byte("x"), int64(2*headerSize+3))
+		Expect(err).ToNot(HaveOccurred())
+		f.Close()
+
+		records, err := replay()
+		Expect(errors.Is(err, ErrCorrupted)).To(BeTrue())
+		Expect(records).To(Equal([]string{"foo"}))
+	})
+
+	It("rejects unknown sync policies", func() {
+		_, err := Open(path, "sometimes")
+		Expect(errors.Is(err, ErrInvalidPolicy)).To(BeTrue())
+	})
+})
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("write-ahead log", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		st := testing.SimpleTime(10)
+		et := testing.SimpleTime(19)
+
+		put := func(val uint64) {
+			key, _ := ParseKey("foo{bar=baz}")
+			t := tree.New()
+			t.Insert([]byte("a;b"), val)
+			t.Insert([]byte("a;c"), val)
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    et,
+				Key:        key,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+				Units:      "samples",
+			})).ToNot(HaveOccurred())
+		}
+
+		// crash stops the storage without saving the caches
+		crash := func() {
+			close(s.stop)
+			s.wg.Wait()
+			s.wal.Close()
+			for _, db := range []interface{ Close() error }{s.db, s.dbTrees, s.dbDicts, s.dbDimensions, s.dbSegments} {
+				Expect(db.Close()).ToNot(HaveOccurred())
+			}
+		}
+
+		samples := func() uint64 {
+			key, _ := ParseKey("foo")
+			gOut, err := s.Get(&GetInput{StartTime: st, EndTime: et, Key: key})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut).ToNot(BeNil())
+			Expect(gOut.Units).To(Equal("samples"))
+			return gOut.Tree.Samples()
+		}
+
+		BeforeEach(func() {
+			walCheckpointInterval = time.Hour
+			(*cfg).Server.EnableWAL = true
+			(*cfg).Server.WALSyncPolicy = wal.SyncAlways
+		})
+
+		AfterEach(func() {
+			walCheckpointInterval = time.Minute
+		})
+
+		It("restores data after a crash", func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			put(1)
+			put(2)
+			crash()
+
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(samples()).To(Equal(uint64(6)))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("does not replay data saved by a checkpoint", func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			put(1)
+			Expect(s.checkpoint()).ToNot(HaveOccurred())
+			put(2)
+			crash()
+
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(samples()).To(Equal(uint64(6)))
+			Expect(s.Close()).ToNot(HaveOccurred())
+
+			By("not replaying anything after a clean shutdown")
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(samples()).To(Equal(uint64(6)))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("does not replay data saved by cache evictions", func() {
+			var err error
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			put(1)
+			for _, c := range []interface{ Evict(float64) }{s.dimensions, s.segments, s.trees} {
+				c.Evict(1)
+			}
+			Expect(samples()).To(Equal(uint64(2)))
+			put(2)
+			crash()
+
+			s, err = New(&(*cfg).Server)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(samples()).To(Equal(uint64(6)))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		Context("invalid sync policy", func() {
+			BeforeEach(func() {
+				(*cfg).Server.WALSyncPolicy = "sometimes"
+			})
+			It("returns an error", func() {
+				_, err := New(&(*cfg).Server)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
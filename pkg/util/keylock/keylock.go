@@ -0,0 +1,71 @@
+// Package keylock provides mutual exclusion for operations on the same key
+// while allowing operations on different keys to run concurrently.
+package keylock
+
+import (
+	"sort"
+	"sync"
+)
+
+// KeyLock is a fixed set of mutexes, each key is mapped onto one of them.
+// Different keys may share a mutex, so one must never hold more than one key at a time,
+// unless all of them are locked with LockMany.
+type KeyLock struct {
+	mutexes []sync.Mutex
+}
+
+func New(size int) *KeyLock {
+	if size < 1 {
+		size = 1
+	}
+	return &KeyLock{mutexes: make([]sync.Mutex, size)}
+}
+
+func (l *KeyLock) Lock(key string) {
+	l.mutexes[l.index(key)].Lock()
+}
+
+func (l *KeyLock) Unlock(key string) {
+	l.mutexes[l.index(key)].Unlock()
+}
+
+// LockMany locks all the keys. Mutexes are always taken in the same order
+// and a mutex shared by several keys is taken once, so that concurrent
+// LockMany calls never deadlock.
+func (l *KeyLock) LockMany(keys ...string) {
+	for _, i := range l.indexes(keys) {
+		l.mutexes[i].Lock()
+	}
+}
+
+// UnlockMany unlocks keys locked with LockMany
+func (l *KeyLock) UnlockMany(keys ...string) {
+	indexes := l.indexes(keys)
+	for i := len(indexes) - 1; i >= 0; i-- {
+		l.mutexes[indexes[i]].Unlock()
+	}
+}
+
+func (l *KeyLock) indexes(keys []string) []uint32 {
+	res := make([]uint32, 0, len(keys))
+	seen := make(map[uint32]struct{}, len(keys))
+	for _, k := range keys {
+		i := l.index(k)
+		if _, ok := seen[i]; !ok {
+			seen[i] = struct{}{}
+			res = append(res, i)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// index computes FNV-1a hash of the key without allocations
+func (l *KeyLock) index(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h % uint32(len(l.mutexes))
+}
@@ -0,0 +1,13 @@
+package keylock_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestKeylock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Keylock Suite")
+}
@@ -0,0 +1,61 @@
+package keylock
+
+import (
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("keylock package", func() {
+	It("maps the same key onto the same mutex", func() {
+		l := New(16)
+		Expect(l.index("foo")).To(Equal(l.index("foo")))
+		Expect(l.index("foo")).To(BeNumerically("<", 16))
+		Expect(New(0).index("foo")).To(BeZero())
+	})
+
+	It("provides mutual exclusion for the same key", func() {
+		l := New(16)
+		counter := 0
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					l.Lock("foo")
+					counter++
+					l.Unlock("foo")
+				}
+			}()
+		}
+		wg.Wait()
+		Expect(counter).To(Equal(10000))
+	})
+
+	It("locks several keys at once", func() {
+		l := New(2)
+		counter := 0
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				keys := []string{"foo", "bar", "baz"}
+				if i%2 == 0 {
+					keys = []string{"baz", "foo", "bar"}
+				}
+				for j := 0; j < 1000; j++ {
+					l.LockMany(keys...)
+					counter++
+					l.UnlockMany(keys...)
+					l.Lock("bar")
+					l.Unlock("bar")
+				}
+			}(i)
+		}
+		wg.Wait()
+		Expect(counter).To(Equal(10000))
+	})
+})
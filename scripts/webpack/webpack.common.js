@@ -13,12 +13,16 @@ module.exports = {
   entry: {
     app: "./webapp/javascript/index.jsx",
     styles: "./webapp/sass/profile.scss",
+    standalone: "./webapp/javascript/standalone.jsx",
   },
 
   output: {
     publicPath: "",
     path: path.resolve(__dirname, "../../webapp/public/build"),
-    filename: "[name].[hash].js",
+    // the server inlines the standalone bundle into exported html pages,
+    // see pkg/server/render_html.go, so its name doesn't change between builds
+    filename: (pathData) =>
+      pathData.chunk.name === "standalone" ? "[name].js" : "[name].[hash].js",
   },
 
   resolve: {
@@ -175,6 +179,8 @@ module.exports = {
     }),
     new MiniCssExtractPlugin({
       filename: "[name].[hash].css",
+      moduleFilename: ({ name }) =>
+        name === "standalone" ? "[name].css" : "[name].[hash].css",
     }),
     new webpack.IgnorePlugin(/^\.\/locale$/, /moment$/),
     new CopyPlugin({
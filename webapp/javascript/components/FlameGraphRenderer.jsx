@@ -53,6 +53,10 @@ const unitsToFlamegraphTitle = {
   "objects": "amount of objects in RAM per function",
   "bytes": "amount of RAM per function",
   "samples": "CPU time per function",
+  "goroutines": "number of goroutines per function",
+  "lock_samples": "number of lock contentions per function",
+  "lock_nanoseconds": "time spent waiting on locks per function",
+  "nanoseconds": "time per function",
 }
 
 class FlameGraphRenderer extends React.Component {
@@ -94,7 +98,14 @@ class FlameGraphRenderer extends React.Component {
       );
     }
 
-    if(this.props.viewSide === 'left' || this.props.viewSide === 'right') {
+    if (this.props.flamebearer) {
+      // standalone pages embed the data instead of fetching it from /render,
+      // levels are copied as setFlamebearer modifies them and props must not change
+      this.setFlamebearer({
+        ...this.props.flamebearer,
+        levels: this.props.flamebearer.levels.map((level) => level.slice()),
+      });
+    } else if(this.props.viewSide === 'left' || this.props.viewSide === 'right') {
       this.fetchFlameBearerData(this.props[`${this.props.viewSide}RenderURL`])
     } else {
       this.fetchFlameBearerData(this.props.renderURL)
@@ -102,14 +113,16 @@ class FlameGraphRenderer extends React.Component {
   }
 
   componentDidUpdate(prevProps, prevState) {
-    if (this.getParamsFromRenderURL(this.props.renderURL).name != this.getParamsFromRenderURL(prevProps.renderURL).name ||
+    // standalone pages have no data to fetch
+    if (!this.props.flamebearer && (
+      this.getParamsFromRenderURL(this.props.renderURL).name != this.getParamsFromRenderURL(prevProps.renderURL).name ||
       prevProps.from != this.props.from ||
       prevProps.until != this.props.until ||
       prevProps.maxNodes != this.props.maxNodes ||
       prevProps.refreshToken != this.props.refreshToken ||
       prevProps[`${this.props.viewSide}From`] != this.props[`${this.props.viewSide}From`] ||
       prevProps[`${this.props.viewSide}Until`] != this.props[`${this.props.viewSide}Until`]
-    ) {
+    )) {
       if(this.props.viewSide === 'left' || this.props.viewSide === 'right') {
         this.fetchFlameBearerData(this.props[`${this.props.viewSide}RenderURL`])
       } else {
@@ -134,18 +147,21 @@ class FlameGraphRenderer extends React.Component {
     fetch(`${url}&format=json`, { signal: this.currentJSONController.signal })
       .then((response) => response.json())
       .then((data) => {
-        let flamebearer = data.flamebearer;
-        deltaDiff(flamebearer.levels);
-
-        this.setState({
-          flamebearer: flamebearer
-        }, () => {
-          this.updateData();
-        })
+        this.setFlamebearer(data.flamebearer);
       })
       .finally();
   }
 
+  setFlamebearer(flamebearer) {
+    deltaDiff(flamebearer.levels);
+
+    this.setState({
+      flamebearer: flamebearer
+    }, () => {
+      this.updateData();
+    })
+  }
+
   getParamsFromRenderURL(inputURL) {
     let urlParamsRegexp = /(.*render\?)(?<urlParams>(.*))/
     let paramsString = inputURL.match(urlParamsRegexp);
@@ -0,0 +1,42 @@
+import ReactDOM from "react-dom";
+import React from "react";
+
+import { Provider } from "react-redux";
+import { ShortcutProvider } from "react-keybind";
+import { createStore } from "redux";
+
+import rootReducer from "./redux/reducers";
+import { setFrom, setUntil } from "./redux/actions";
+
+import FlameGraphRenderer from "./components/FlameGraphRenderer";
+
+import "../sass/profile.scss";
+
+// Entry point of pages exported with /render?format=html. The page embeds
+// the flamegraph data and this bundle, see pkg/server/render_html.go
+const { title, from, until, flamebearer } = window.standalone;
+
+// unlike the app store this one is not synced with the page url
+const store = createStore(rootReducer);
+store.dispatch(setFrom(from));
+store.dispatch(setUntil(until));
+
+ReactDOM.render(
+  <Provider store={store}>
+    <ShortcutProvider>
+      <div className="pyroscope-app">
+        <div className="main-wrapper">
+          <div className="navbar">
+            <h1>{title}</h1>
+            <div className="navbar-space-filler" />
+            <span>
+              {from} &ndash; {until}
+            </span>
+          </div>
+          <FlameGraphRenderer viewType="single" flamebearer={flamebearer} />
+        </div>
+      </div>
+    </ShortcutProvider>
+  </Provider>,
+  document.getElementById("root")
+);
@@ -61,6 +61,17 @@ export class DurationFormatter {
   }
 }
 
+// lock durations and other nanosecond values from pprof profiles
+//   don't depend on the sample rate
+export class NanosecondsFormatter extends DurationFormatter {
+  constructor(maxNanoseconds) {
+    super(maxNanoseconds / 1e9);
+  }
+
+  format(nanoseconds) {
+    return super.format(nanoseconds, 1e9);
+  }
+}
 
 const bytes = [
   [1024, "KB"],
@@ -162,6 +173,12 @@ export function getFormatter(max, sampleRate, units){
       return new ObjectsFormatter(max);
     case "bytes":
       return new BytesFormatter(max);
+    case "goroutines":
+    case "lock_samples":
+      return new ObjectsFormatter(max);
+    case "lock_nanoseconds":
+    case "nanoseconds":
+      return new NanosecondsFormatter(max);
     default:
       return new DurationFormatter(max / sampleRate);
   }